@@ -0,0 +1,481 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/auditlogs"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// AuditStatus filters audit log entries by their outcome
+type AuditStatus string
+
+const (
+	AuditStatusSuccess AuditStatus = "success"
+	AuditStatusFailure AuditStatus = "failure"
+)
+
+// auditLogPageSize is the number of entries read per page, the most the audit logs allow
+const auditLogPageSize = int32(1000)
+
+// AuditLogFilter selects audit log entries. Every field that is set must match.
+type AuditLogFilter struct {
+	// UserID and UserPrincipalName match the user that signed in, or that initiated the
+	// directory change
+	UserID            string
+	UserPrincipalName string
+
+	// AppID matches the application that was signed in to, or that initiated the
+	// directory change
+	AppID string
+
+	Status AuditStatus
+
+	// IPAddress matches the address sign-ins came from, or the address of the user that
+	// initiated the directory change. Directory audits cannot be filtered by address in
+	// Graph, they are matched as they are read.
+	IPAddress string
+
+	// Start and End bound the time of the entries, inclusive
+	Start time.Time
+	End   time.Time
+
+	// Activity is the activity display name (e.g. "Add user") and Category the category
+	// (e.g. UserManagement) of directory audits. Ignored for sign-ins.
+	Activity string
+	Category string
+
+	// TargetID matches a target resource of directory audits. Ignored for sign-ins.
+	TargetID string
+
+	// Limit is the most entries returned, all entries when 0
+	Limit int
+}
+
+// SignIn is a sign-in of a user
+type SignIn struct {
+	ID                      string      `json:"id"`
+	Time                    time.Time   `json:"time"`
+	UserID                  string      `json:"userId,omitempty"`
+	UserPrincipalName       string      `json:"userPrincipalName,omitempty"`
+	UserDisplayName         string      `json:"userDisplayName,omitempty"`
+	AppID                   string      `json:"appId,omitempty"`
+	AppName                 string      `json:"appName,omitempty"`
+	ResourceName            string      `json:"resourceName,omitempty"`
+	ClientApp               string      `json:"clientApp,omitempty"`
+	IPAddress               string      `json:"ipAddress,omitempty"`
+	Interactive             bool        `json:"interactive"`
+	Status                  AuditStatus `json:"status"`
+	ErrorCode               int         `json:"errorCode"`
+	FailureReason           string      `json:"failureReason,omitempty"`
+	City                    string      `json:"city,omitempty"`
+	State                   string      `json:"state,omitempty"`
+	Country                 string      `json:"country,omitempty"`
+	DeviceID                string      `json:"deviceId,omitempty"`
+	DeviceName              string      `json:"deviceName,omitempty"`
+	OperatingSystem         string      `json:"operatingSystem,omitempty"`
+	Browser                 string      `json:"browser,omitempty"`
+	ConditionalAccessStatus string      `json:"conditionalAccessStatus,omitempty"`
+	RiskLevel               string      `json:"riskLevel,omitempty"`
+	CorrelationID           string      `json:"correlationId,omitempty"`
+}
+
+// DirectoryAudit is a change made to the directory
+type DirectoryAudit struct {
+	ID            string         `json:"id"`
+	Time          time.Time      `json:"time"`
+	Activity      string         `json:"activity"`
+	Category      string         `json:"category,omitempty"`
+	OperationType string         `json:"operationType,omitempty"`
+	Service       string         `json:"service,omitempty"`
+	Status        AuditStatus    `json:"status"`
+	StatusReason  string         `json:"statusReason,omitempty"`
+	InitiatedBy   AuditInitiator `json:"initiatedBy"`
+	Targets       []*AuditTarget `json:"targets,omitempty"`
+	CorrelationID string         `json:"correlationId,omitempty"`
+}
+
+// AuditInitiator is the user or application that made a directory change
+type AuditInitiator struct {
+	UserID            string `json:"userId,omitempty"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+	AppID             string `json:"appId,omitempty"`
+	AppName           string `json:"appName,omitempty"`
+	IPAddress         string `json:"ipAddress,omitempty"`
+}
+
+// AuditTarget is a resource changed by a directory change
+type AuditTarget struct {
+	ID                string                 `json:"id,omitempty"`
+	Type              string                 `json:"type,omitempty"`
+	DisplayName       string                 `json:"displayName,omitempty"`
+	UserPrincipalName string                 `json:"userPrincipalName,omitempty"`
+	Changes           []*AuditPropertyChange `json:"changes,omitempty"`
+}
+
+// AuditPropertyChange is a property of a target resource changed by a directory change.
+// The values are JSON encoded, as Graph returns them.
+type AuditPropertyChange struct {
+	Property string `json:"property"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+}
+
+type MsGraphAuditLogManager struct {
+	*MsGraph
+}
+
+func NewMsGraphAuditLogManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphAuditLogManager, error) {
+	am := &MsGraphAuditLogManager{
+		MsGraph: &MsGraph{},
+	}
+	err := am.Configure(cfg)
+
+	return am, err
+}
+
+// StreamSignIns calls fn with each sign-in that matches the filter, newest first, reading
+// the sign-ins page by page. Stops when fn returns false.
+func (am *MsGraphAuditLogManager) StreamSignIns(ctx context.Context, filter *AuditLogFilter, fn func(*SignIn) bool) error {
+	if filter == nil {
+		filter = &AuditLogFilter{}
+	}
+
+	query := signInFilter(filter)
+	cloudy.Info(ctx, "StreamSignIns %s", query)
+
+	top := auditLogPageSize
+	if filter.Limit > 0 && filter.Limit < int(top) {
+		top = int32(filter.Limit)
+	}
+	// sign-ins are returned newest first, $orderby is not supported
+	params := &auditlogs.SignInsRequestBuilderGetQueryParameters{
+		Top: &top,
+	}
+	if query != "" {
+		params.Filter = &query
+	}
+
+	result, err := am.Client.AuditLogs().SignIns().Get(ctx,
+		&auditlogs.SignInsRequestBuilderGetRequestConfiguration{
+			QueryParameters: params,
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "StreamSignIns Error: %s", message)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.SignInable](result, am.Adapter, models.CreateSignInCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	err = pageIterator.Iterate(ctx, func(pageItem models.SignInable) bool {
+		count++
+		return fn(SignInToCloudy(pageItem)) && (filter.Limit <= 0 || count < filter.Limit)
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "StreamSignIns Error after %d sign-ins: %s", count, message)
+	}
+
+	return nil
+}
+
+// ListSignIns returns the sign-ins that match the filter, newest first
+func (am *MsGraphAuditLogManager) ListSignIns(ctx context.Context, filter *AuditLogFilter) ([]*SignIn, error) {
+	rtn := []*SignIn{}
+	err := am.StreamSignIns(ctx, filter, func(s *SignIn) bool {
+		rtn = append(rtn, s)
+		return true
+	})
+	return rtn, err
+}
+
+// StreamDirectoryAudits calls fn with each directory audit that matches the filter,
+// newest first, reading the audits page by page. Stops when fn returns false.
+func (am *MsGraphAuditLogManager) StreamDirectoryAudits(ctx context.Context, filter *AuditLogFilter, fn func(*DirectoryAudit) bool) error {
+	if filter == nil {
+		filter = &AuditLogFilter{}
+	}
+
+	query := directoryAuditFilter(filter)
+	cloudy.Info(ctx, "StreamDirectoryAudits %s", query)
+
+	top := auditLogPageSize
+	if filter.Limit > 0 && filter.Limit < int(top) {
+		top = int32(filter.Limit)
+	}
+	// audits are returned newest first, $orderby is not supported
+	params := &auditlogs.DirectoryAuditsRequestBuilderGetQueryParameters{
+		Top: &top,
+	}
+	if query != "" {
+		params.Filter = &query
+	}
+
+	result, err := am.Client.AuditLogs().DirectoryAudits().Get(ctx,
+		&auditlogs.DirectoryAuditsRequestBuilderGetRequestConfiguration{
+			QueryParameters: params,
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "StreamDirectoryAudits Error: %s", message)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.DirectoryAuditable](result, am.Adapter, models.CreateDirectoryAuditCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	err = pageIterator.Iterate(ctx, func(pageItem models.DirectoryAuditable) bool {
+		audit := DirectoryAuditToCloudy(pageItem)
+		if !directoryAuditMatches(filter, audit) {
+			return true
+		}
+		count++
+		return fn(audit) && (filter.Limit <= 0 || count < filter.Limit)
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "StreamDirectoryAudits Error after %d audits: %s", count, message)
+	}
+
+	return nil
+}
+
+// ListDirectoryAudits returns the directory audits that match the filter, newest first
+func (am *MsGraphAuditLogManager) ListDirectoryAudits(ctx context.Context, filter *AuditLogFilter) ([]*DirectoryAudit, error) {
+	rtn := []*DirectoryAudit{}
+	err := am.StreamDirectoryAudits(ctx, filter, func(a *DirectoryAudit) bool {
+		rtn = append(rtn, a)
+		return true
+	})
+	return rtn, err
+}
+
+// ExportSignIns writes the sign-ins that match the filter to w as JSON Lines, one object
+// per sign-in, and returns the number written
+func (am *MsGraphAuditLogManager) ExportSignIns(ctx context.Context, w io.Writer, filter *AuditLogFilter) (int, error) {
+	encoder := json.NewEncoder(w)
+
+	count := 0
+	var writeErr error
+	err := am.StreamSignIns(ctx, filter, func(s *SignIn) bool {
+		writeErr = encoder.Encode(s)
+		if writeErr != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+	if writeErr != nil {
+		return count, cloudy.Error(ctx, "ExportSignIns Error after %d sign-ins: %v", count, writeErr)
+	}
+
+	cloudy.Info(ctx, "ExportSignIns %d sign-ins", count)
+	return count, nil
+}
+
+// ExportDirectoryAudits writes the directory audits that match the filter to w as JSON
+// Lines, one object per audit, and returns the number written
+func (am *MsGraphAuditLogManager) ExportDirectoryAudits(ctx context.Context, w io.Writer, filter *AuditLogFilter) (int, error) {
+	encoder := json.NewEncoder(w)
+
+	count := 0
+	var writeErr error
+	err := am.StreamDirectoryAudits(ctx, filter, func(a *DirectoryAudit) bool {
+		writeErr = encoder.Encode(a)
+		if writeErr != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+	if writeErr != nil {
+		return count, cloudy.Error(ctx, "ExportDirectoryAudits Error after %d audits: %v", count, writeErr)
+	}
+
+	cloudy.Info(ctx, "ExportDirectoryAudits %d audits", count)
+	return count, nil
+}
+
+// directoryAuditMatches checks the parts of the filter that cannot be sent in $filter
+func directoryAuditMatches(filter *AuditLogFilter, audit *DirectoryAudit) bool {
+	return filter.IPAddress == "" || strings.EqualFold(filter.IPAddress, audit.InitiatedBy.IPAddress)
+}
+
+// signInFilter returns the $filter of the sign-ins that match the filter
+func signInFilter(filter *AuditLogFilter) string {
+	clauses := []string{}
+	if filter.UserID != "" {
+		clauses = append(clauses, fmt.Sprintf("userId eq '%s'", odataString(filter.UserID)))
+	}
+	if filter.UserPrincipalName != "" {
+		clauses = append(clauses, fmt.Sprintf("userPrincipalName eq '%s'", odataString(filter.UserPrincipalName)))
+	}
+	if filter.AppID != "" {
+		clauses = append(clauses, fmt.Sprintf("appId eq '%s'", odataString(filter.AppID)))
+	}
+	switch filter.Status {
+	case AuditStatusSuccess:
+		clauses = append(clauses, "status/errorCode eq 0")
+	case AuditStatusFailure:
+		clauses = append(clauses, "status/errorCode ne 0")
+	}
+	if filter.IPAddress != "" {
+		clauses = append(clauses, fmt.Sprintf("ipAddress eq '%s'", odataString(filter.IPAddress)))
+	}
+	return strings.Join(append(clauses, timeWindowFilter("createdDateTime", filter.Start, filter.End)...), " and ")
+}
+
+// directoryAuditFilter returns the $filter of the directory audits that match the filter
+func directoryAuditFilter(filter *AuditLogFilter) string {
+	clauses := []string{}
+	if filter.UserID != "" {
+		clauses = append(clauses, fmt.Sprintf("initiatedBy/user/id eq '%s'", odataString(filter.UserID)))
+	}
+	if filter.UserPrincipalName != "" {
+		clauses = append(clauses, fmt.Sprintf("initiatedBy/user/userPrincipalName eq '%s'", odataString(filter.UserPrincipalName)))
+	}
+	if filter.AppID != "" {
+		clauses = append(clauses, fmt.Sprintf("initiatedBy/app/appId eq '%s'", odataString(filter.AppID)))
+	}
+	if filter.Status != "" {
+		clauses = append(clauses, fmt.Sprintf("result eq '%s'", odataString(string(filter.Status))))
+	}
+	if filter.Activity != "" {
+		clauses = append(clauses, fmt.Sprintf("activityDisplayName eq '%s'", odataString(filter.Activity)))
+	}
+	if filter.Category != "" {
+		clauses = append(clauses, fmt.Sprintf("category eq '%s'", odataString(filter.Category)))
+	}
+	if filter.TargetID != "" {
+		clauses = append(clauses, fmt.Sprintf("targetResources/any(t:t/id eq '%s')", odataString(filter.TargetID)))
+	}
+	return strings.Join(append(clauses, timeWindowFilter("activityDateTime", filter.Start, filter.End)...), " and ")
+}
+
+func timeWindowFilter(property string, start time.Time, end time.Time) []string {
+	clauses := []string{}
+	if !start.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("%s ge %s", property, start.UTC().Format(time.RFC3339)))
+	}
+	if !end.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("%s le %s", property, end.UTC().Format(time.RFC3339)))
+	}
+	return clauses
+}
+
+// odataString escapes the single quotes of a $filter string literal
+func odataString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+func SignInToCloudy(s models.SignInable) *SignIn {
+	rtn := &SignIn{
+		Status:            AuditStatusSuccess,
+		ID:                cloudy.StringFromP(s.GetId()),
+		UserID:            cloudy.StringFromP(s.GetUserId()),
+		UserPrincipalName: cloudy.StringFromP(s.GetUserPrincipalName()),
+		UserDisplayName:   cloudy.StringFromP(s.GetUserDisplayName()),
+		AppID:             cloudy.StringFromP(s.GetAppId()),
+		AppName:           cloudy.StringFromP(s.GetAppDisplayName()),
+		ResourceName:      cloudy.StringFromP(s.GetResourceDisplayName()),
+		ClientApp:         cloudy.StringFromP(s.GetClientAppUsed()),
+		IPAddress:         cloudy.StringFromP(s.GetIpAddress()),
+		CorrelationID:     cloudy.StringFromP(s.GetCorrelationId()),
+	}
+	if s.GetCreatedDateTime() != nil {
+		rtn.Time = *s.GetCreatedDateTime()
+	}
+	if s.GetIsInteractive() != nil {
+		rtn.Interactive = *s.GetIsInteractive()
+	}
+	if status := s.GetStatus(); status != nil {
+		if status.GetErrorCode() != nil && *status.GetErrorCode() != 0 {
+			rtn.Status = AuditStatusFailure
+			rtn.ErrorCode = int(*status.GetErrorCode())
+		}
+		rtn.FailureReason = cloudy.StringFromP(status.GetFailureReason())
+	}
+	if location := s.GetLocation(); location != nil {
+		rtn.City = cloudy.StringFromP(location.GetCity())
+		rtn.State = cloudy.StringFromP(location.GetState())
+		rtn.Country = cloudy.StringFromP(location.GetCountryOrRegion())
+	}
+	if device := s.GetDeviceDetail(); device != nil {
+		rtn.DeviceID = cloudy.StringFromP(device.GetDeviceId())
+		rtn.DeviceName = cloudy.StringFromP(device.GetDisplayName())
+		rtn.OperatingSystem = cloudy.StringFromP(device.GetOperatingSystem())
+		rtn.Browser = cloudy.StringFromP(device.GetBrowser())
+	}
+	if s.GetConditionalAccessStatus() != nil {
+		rtn.ConditionalAccessStatus = s.GetConditionalAccessStatus().String()
+	}
+	if s.GetRiskLevelDuringSignIn() != nil {
+		rtn.RiskLevel = s.GetRiskLevelDuringSignIn().String()
+	}
+	return rtn
+}
+
+func DirectoryAuditToCloudy(a models.DirectoryAuditable) *DirectoryAudit {
+	rtn := &DirectoryAudit{
+		ID:            cloudy.StringFromP(a.GetId()),
+		Activity:      cloudy.StringFromP(a.GetActivityDisplayName()),
+		Category:      cloudy.StringFromP(a.GetCategory()),
+		OperationType: cloudy.StringFromP(a.GetOperationType()),
+		Service:       cloudy.StringFromP(a.GetLoggedByService()),
+		StatusReason:  cloudy.StringFromP(a.GetResultReason()),
+		CorrelationID: cloudy.StringFromP(a.GetCorrelationId()),
+	}
+	if a.GetActivityDateTime() != nil {
+		rtn.Time = *a.GetActivityDateTime()
+	}
+	if a.GetResult() != nil {
+		rtn.Status = AuditStatus(a.GetResult().String())
+	}
+	if initiator := a.GetInitiatedBy(); initiator != nil {
+		if user := initiator.GetUser(); user != nil {
+			rtn.InitiatedBy.UserID = cloudy.StringFromP(user.GetId())
+			rtn.InitiatedBy.UserPrincipalName = cloudy.StringFromP(user.GetUserPrincipalName())
+			rtn.InitiatedBy.IPAddress = cloudy.StringFromP(user.GetIpAddress())
+		}
+		if app := initiator.GetApp(); app != nil {
+			rtn.InitiatedBy.AppID = cloudy.StringFromP(app.GetAppId())
+			rtn.InitiatedBy.AppName = cloudy.StringFromP(app.GetDisplayName())
+		}
+	}
+	for _, t := range a.GetTargetResources() {
+		target := &AuditTarget{
+			ID:                cloudy.StringFromP(t.GetId()),
+			Type:              cloudy.StringFromP(t.GetTypeEscaped()),
+			DisplayName:       cloudy.StringFromP(t.GetDisplayName()),
+			UserPrincipalName: cloudy.StringFromP(t.GetUserPrincipalName()),
+		}
+		for _, p := range t.GetModifiedProperties() {
+			target.Changes = append(target.Changes, &AuditPropertyChange{
+				Property: cloudy.StringFromP(p.GetDisplayName()),
+				Old:      cloudy.StringFromP(p.GetOldValue()),
+				New:      cloudy.StringFromP(p.GetNewValue()),
+			})
+		}
+		rtn.Targets = append(rtn.Targets, target)
+	}
+	return rtn
+}
@@ -0,0 +1,124 @@
+package cloudymsgraph
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignInFilter(t *testing.T) {
+	assert.Equal(t, "", signInFilter(&AuditLogFilter{}))
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 2, 0, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	filter := signInFilter(&AuditLogFilter{
+		UserPrincipalName: "o'brien@example.com",
+		AppID:             "app",
+		Status:            AuditStatusFailure,
+		IPAddress:         "10.0.0.1",
+		Start:             start,
+		End:               end,
+		Activity:          "ignored",
+	})
+	assert.Equal(t, "userPrincipalName eq 'o''brien@example.com' and appId eq 'app' and status/errorCode ne 0 and "+
+		"ipAddress eq '10.0.0.1' and createdDateTime ge 2024-03-01T00:00:00Z and createdDateTime le 2024-03-02T05:00:00Z", filter)
+
+	assert.Equal(t, "status/errorCode eq 0", signInFilter(&AuditLogFilter{Status: AuditStatusSuccess}))
+}
+
+func TestDirectoryAuditFilter(t *testing.T) {
+	filter := directoryAuditFilter(&AuditLogFilter{
+		UserID:    "u",
+		Status:    AuditStatusSuccess,
+		Activity:  "Add user",
+		Category:  "UserManagement",
+		TargetID:  "t",
+		IPAddress: "10.0.0.1",
+		Start:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	})
+	assert.Equal(t, "initiatedBy/user/id eq 'u' and result eq 'success' and activityDisplayName eq 'Add user' and "+
+		"category eq 'UserManagement' and targetResources/any(t:t/id eq 't') and activityDateTime ge 2024-03-01T00:00:00Z", filter)
+
+	// the address is matched as the audits are read
+	audit := &DirectoryAudit{InitiatedBy: AuditInitiator{IPAddress: "10.0.0.1"}}
+	assert.True(t, directoryAuditMatches(&AuditLogFilter{}, audit))
+	assert.True(t, directoryAuditMatches(&AuditLogFilter{IPAddress: "10.0.0.1"}, audit))
+	assert.False(t, directoryAuditMatches(&AuditLogFilter{IPAddress: "10.0.0.2"}, audit))
+	assert.False(t, directoryAuditMatches(&AuditLogFilter{IPAddress: "10.0.0.1"}, &DirectoryAudit{}))
+}
+
+func TestSignInToCloudy(t *testing.T) {
+	id, upn, ip, reason, city := "s", "john.doe@example.com", "10.0.0.1", "Invalid password", "Denver"
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	code := int32(50126)
+	risk := models.LOW_RISKLEVEL
+
+	status := models.NewSignInStatus()
+	status.SetErrorCode(&code)
+	status.SetFailureReason(&reason)
+	location := models.NewSignInLocation()
+	location.SetCity(&city)
+
+	s := models.NewSignIn()
+	s.SetId(&id)
+	s.SetUserPrincipalName(&upn)
+	s.SetIpAddress(&ip)
+	s.SetCreatedDateTime(&created)
+	s.SetStatus(status)
+	s.SetLocation(location)
+	s.SetRiskLevelDuringSignIn(&risk)
+
+	signIn := SignInToCloudy(s)
+	assert.Equal(t, "s", signIn.ID)
+	assert.Equal(t, upn, signIn.UserPrincipalName)
+	assert.Equal(t, created, signIn.Time)
+	assert.Equal(t, AuditStatusFailure, signIn.Status)
+	assert.Equal(t, 50126, signIn.ErrorCode)
+	assert.Equal(t, reason, signIn.FailureReason)
+	assert.Equal(t, city, signIn.City)
+	assert.Equal(t, "low", signIn.RiskLevel)
+
+	assert.Equal(t, AuditStatusSuccess, SignInToCloudy(models.NewSignIn()).Status)
+}
+
+func TestDirectoryAuditToCloudy(t *testing.T) {
+	id, activity, upn, targetId, property, oldValue, newValue := "a", "Update user", "admin@example.com", "t", "DisplayName", `["a"]`, `["b"]`
+	result := models.SUCCESS_OPERATIONRESULT
+
+	user := models.NewUserIdentity()
+	user.SetUserPrincipalName(&upn)
+	initiator := models.NewAuditActivityInitiator()
+	initiator.SetUser(user)
+
+	change := models.NewModifiedProperty()
+	change.SetDisplayName(&property)
+	change.SetOldValue(&oldValue)
+	change.SetNewValue(&newValue)
+	target := models.NewTargetResource()
+	target.SetId(&targetId)
+	target.SetModifiedProperties([]models.ModifiedPropertyable{change})
+
+	a := models.NewDirectoryAudit()
+	a.SetId(&id)
+	a.SetActivityDisplayName(&activity)
+	a.SetResult(&result)
+	a.SetInitiatedBy(initiator)
+	a.SetTargetResources([]models.TargetResourceable{target})
+
+	audit := DirectoryAuditToCloudy(a)
+	assert.Equal(t, "a", audit.ID)
+	assert.Equal(t, activity, audit.Activity)
+	assert.Equal(t, AuditStatusSuccess, audit.Status)
+	assert.Equal(t, upn, audit.InitiatedBy.UserPrincipalName)
+	assert.Len(t, audit.Targets, 1)
+	assert.Equal(t, "t", audit.Targets[0].ID)
+	assert.Equal(t, &AuditPropertyChange{Property: property, Old: oldValue, New: newValue}, audit.Targets[0].Changes[0])
+
+	data, err := json.Marshal(audit)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"activity":"Update user"`)
+	assert.Contains(t, string(data), `"userPrincipalName":"admin@example.com"`)
+}
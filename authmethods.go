@@ -0,0 +1,223 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+type AuthMethodType string
+
+const (
+	AuthMethodPassword               AuthMethodType = "password"
+	AuthMethodPhone                  AuthMethodType = "phone"
+	AuthMethodMicrosoftAuthenticator AuthMethodType = "microsoftAuthenticator"
+	AuthMethodFido2                  AuthMethodType = "fido2"
+	AuthMethodWindowsHello           AuthMethodType = "windowsHelloForBusiness"
+	AuthMethodEmail                  AuthMethodType = "email"
+	AuthMethodSoftwareOath           AuthMethodType = "softwareOath"
+	AuthMethodTemporaryAccessPass    AuthMethodType = "temporaryAccessPass"
+	AuthMethodUnknown                AuthMethodType = "unknown"
+)
+
+// Well known ids of the phone methods. A user can only have one phone of each type
+// and the id of the method is always the same.
+const (
+	MobilePhoneMethodId          = "3179e48a-750b-4051-897c-87b9720928f7"
+	AlternateMobilePhoneMethodId = "b6332ec1-7057-4abe-9331-3d72feddfe41"
+	OfficePhoneMethodId          = "e37fc753-ff3b-4958-9484-eaa9425c82bc"
+)
+
+// AuthMethod is a provider neutral view of a registered authentication method
+type AuthMethod struct {
+	ID   string
+	Type AuthMethodType
+
+	// DisplayName is the name of the device or key, if any
+	DisplayName string
+
+	// Detail holds the phone number, email address or device model depending on the type
+	Detail string
+
+	// PhoneType is mobile, alternateMobile or office for phone methods
+	PhoneType string
+
+	Created *time.Time
+}
+
+type MsGraphAuthMethodManager struct {
+	*MsGraph
+}
+
+func NewMsGraphAuthMethodManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphAuthMethodManager, error) {
+	am := &MsGraphAuthMethodManager{
+		MsGraph: &MsGraph{},
+	}
+	err := am.Configure(cfg)
+
+	return am, err
+}
+
+// ListMethods lists all the authentication methods registered for a user
+func (am *MsGraphAuthMethodManager) ListMethods(ctx context.Context, uid string) ([]*AuthMethod, error) {
+	cloudy.Info(ctx, "[%s] ListMethods", uid)
+
+	result, err := am.Client.Users().ByUserId(uid).Authentication().Methods().Get(ctx, nil)
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			return nil, cloudy.Error(ctx, "ListMethods Error: %s - ResourceNotFound - %s", uid, message)
+		}
+
+		return nil, cloudy.Error(ctx, "ListMethods Error: %s %s", uid, message)
+	}
+
+	rtn := []*AuthMethod{}
+	for _, m := range result.GetValue() {
+		rtn = append(rtn, AuthMethodToCloudy(m))
+	}
+
+	return rtn, nil
+}
+
+// RemoveMethod removes a single authentication method from a user. The password method
+// cannot be removed.
+func (am *MsGraphAuthMethodManager) RemoveMethod(ctx context.Context, uid string, methodType AuthMethodType, methodId string) error {
+	cloudy.Info(ctx, "[%s] RemoveMethod %s %s", uid, methodType, methodId)
+
+	auth := am.Client.Users().ByUserId(uid).Authentication()
+
+	var err error
+	switch methodType {
+	case AuthMethodPhone:
+		err = auth.PhoneMethods().ByPhoneAuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodMicrosoftAuthenticator:
+		err = auth.MicrosoftAuthenticatorMethods().ByMicrosoftAuthenticatorAuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodFido2:
+		err = auth.Fido2Methods().ByFido2AuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodWindowsHello:
+		err = auth.WindowsHelloForBusinessMethods().ByWindowsHelloForBusinessAuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodEmail:
+		err = auth.EmailMethods().ByEmailAuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodSoftwareOath:
+		err = auth.SoftwareOathMethods().BySoftwareOathAuthenticationMethodId(methodId).Delete(ctx, nil)
+	case AuthMethodTemporaryAccessPass:
+		err = auth.TemporaryAccessPassMethods().ByTemporaryAccessPassAuthenticationMethodId(methodId).Delete(ctx, nil)
+	default:
+		return cloudy.Error(ctx, "[%s] RemoveMethod - %s methods cannot be removed", uid, methodType)
+	}
+
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] RemoveMethod Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// SetPhoneMethod adds or updates the phone of the given type (mobile, alternateMobile
+// or office) for a user.
+func (am *MsGraphAuthMethodManager) SetPhoneMethod(ctx context.Context, uid string, phoneType string, phoneNumber string) (*AuthMethod, error) {
+	cloudy.Info(ctx, "[%s] SetPhoneMethod %s", uid, phoneType)
+
+	parsed, err := models.ParseAuthenticationPhoneType(phoneType)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "[%s] SetPhoneMethod Invalid phone type: %s", uid, phoneType)
+	}
+
+	body := models.NewPhoneAuthenticationMethod()
+	body.SetPhoneNumber(&phoneNumber)
+	body.SetPhoneType(parsed.(*models.AuthenticationPhoneType))
+
+	phones := am.Client.Users().ByUserId(uid).Authentication().PhoneMethods()
+
+	existing, err := am.findPhoneMethod(ctx, uid, phoneType)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.PhoneAuthenticationMethodable
+	if existing != nil {
+		result, err = phones.ByPhoneAuthenticationMethodId(existing.ID).Patch(ctx, body, nil)
+	} else {
+		result, err = phones.Post(ctx, body, nil)
+	}
+
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] SetPhoneMethod Error: %s", uid, message)
+	}
+
+	if result == nil {
+		return nil, nil
+	}
+
+	return AuthMethodToCloudy(result), nil
+}
+
+func (am *MsGraphAuthMethodManager) findPhoneMethod(ctx context.Context, uid string, phoneType string) (*AuthMethod, error) {
+	result, err := am.Client.Users().ByUserId(uid).Authentication().PhoneMethods().Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] findPhoneMethod Error: %s", uid, message)
+	}
+
+	for _, phone := range result.GetValue() {
+		method := AuthMethodToCloudy(phone)
+		if strings.EqualFold(method.PhoneType, phoneType) {
+			return method, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func AuthMethodToCloudy(m models.AuthenticationMethodable) *AuthMethod {
+	am := &AuthMethod{
+		ID:   cloudy.StringFromP(m.GetId()),
+		Type: AuthMethodUnknown,
+	}
+
+	switch method := m.(type) {
+	case models.PasswordAuthenticationMethodable:
+		am.Type = AuthMethodPassword
+		am.Created = method.GetCreatedDateTime()
+	case models.PhoneAuthenticationMethodable:
+		am.Type = AuthMethodPhone
+		am.Detail = cloudy.StringFromP(method.GetPhoneNumber())
+		if method.GetPhoneType() != nil {
+			am.PhoneType = method.GetPhoneType().String()
+		}
+	case models.MicrosoftAuthenticatorAuthenticationMethodable:
+		am.Type = AuthMethodMicrosoftAuthenticator
+		am.DisplayName = cloudy.StringFromP(method.GetDisplayName())
+		am.Detail = cloudy.StringFromP(method.GetDeviceTag())
+		am.Created = method.GetCreatedDateTime()
+	case models.Fido2AuthenticationMethodable:
+		am.Type = AuthMethodFido2
+		am.DisplayName = cloudy.StringFromP(method.GetDisplayName())
+		am.Detail = cloudy.StringFromP(method.GetModel())
+		am.Created = method.GetCreatedDateTime()
+	case models.WindowsHelloForBusinessAuthenticationMethodable:
+		am.Type = AuthMethodWindowsHello
+		am.DisplayName = cloudy.StringFromP(method.GetDisplayName())
+		if method.GetDevice() != nil {
+			am.Detail = cloudy.StringFromP(method.GetDevice().GetDisplayName())
+		}
+		am.Created = method.GetCreatedDateTime()
+	case models.EmailAuthenticationMethodable:
+		am.Type = AuthMethodEmail
+		am.Detail = cloudy.StringFromP(method.GetEmailAddress())
+	case models.SoftwareOathAuthenticationMethodable:
+		am.Type = AuthMethodSoftwareOath
+	case models.TemporaryAccessPassAuthenticationMethodable:
+		am.Type = AuthMethodTemporaryAccessPass
+		am.Created = method.GetCreatedDateTime()
+	}
+
+	return am
+}
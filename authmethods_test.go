@@ -0,0 +1,66 @@
+package cloudymsgraph
+
+import (
+	"log"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/testutil"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMethodManager(t *testing.T) {
+	ctx := cloudy.StartContext()
+
+	env := testutil.CreateTestEnvironment()
+	cloudy.SetDefaultEnvironment(env)
+
+	testEnv := env.Segment("TEST")
+	loader := MSGraphCredentialLoader{}
+	cfg := loader.ReadFromEnv(testEnv).(*MsGraphConfig)
+	cfg.SetInstance(&USGovernment)
+
+	am, err := NewMsGraphAuthMethodManager(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Error %v", err)
+	}
+
+	methods, err := am.ListMethods(ctx, "unittest@collider.onmicrosoft.us")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, methods)
+}
+
+func TestAuthMethodModel(t *testing.T) {
+	phone := models.NewPhoneAuthenticationMethod()
+	phone.SetId(cloudy.StringP(MobilePhoneMethodId))
+	phone.SetPhoneNumber(cloudy.StringP("+1 5555551234"))
+	phoneType := models.MOBILE_AUTHENTICATIONPHONETYPE
+	phone.SetPhoneType(&phoneType)
+
+	m := AuthMethodToCloudy(phone)
+	assert.Equal(t, MobilePhoneMethodId, m.ID)
+	assert.Equal(t, AuthMethodPhone, m.Type)
+	assert.Equal(t, "+1 5555551234", m.Detail)
+	assert.Equal(t, "mobile", m.PhoneType)
+
+	fido := models.NewFido2AuthenticationMethod()
+	fido.SetId(cloudy.StringP("fido"))
+	fido.SetDisplayName(cloudy.StringP("YubiKey"))
+	fido.SetModel(cloudy.StringP("YubiKey 5 NFC"))
+
+	m = AuthMethodToCloudy(fido)
+	assert.Equal(t, AuthMethodFido2, m.Type)
+	assert.Equal(t, "YubiKey", m.DisplayName)
+	assert.Equal(t, "YubiKey 5 NFC", m.Detail)
+
+	email := models.NewEmailAuthenticationMethod()
+	email.SetEmailAddress(cloudy.StringP("someone@example.com"))
+
+	m = AuthMethodToCloudy(email)
+	assert.Equal(t, AuthMethodEmail, m.Type)
+	assert.Equal(t, "someone@example.com", m.Detail)
+
+	m = AuthMethodToCloudy(models.NewAuthenticationMethod())
+	assert.Equal(t, AuthMethodUnknown, m.Type)
+}
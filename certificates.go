@@ -0,0 +1,280 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// CertificateBindingType is one of the certificateUserIds formats supported by
+// Entra certificate based authentication
+// SEE : https://learn.microsoft.com/en-us/entra/identity/authentication/concept-certificate-based-authentication-certificateuserids
+type CertificateBindingType string
+
+const (
+	CertBindingPrincipalName CertificateBindingType = "PN"
+	CertBindingRFC822        CertificateBindingType = "RFC822"
+	CertBindingSKI           CertificateBindingType = "SKI"
+	CertBindingSHA1PublicKey CertificateBindingType = "SHA1-PUKEY"
+	CertBindingIssuerSerial  CertificateBindingType = "I+SR"
+	CertBindingSubject       CertificateBindingType = "S"
+	CertBindingIssuerSubject CertificateBindingType = "I+S"
+	CertBindingUnknown       CertificateBindingType = "UNKNOWN"
+)
+
+const CertificateUserIdPrefix = "X509:"
+
+// MaxCertificateUserIds is the number of certificate user ids that can be bound to a single user
+const MaxCertificateUserIds = 5
+
+var ErrInvalidCertificateBinding = errors.New("invalid certificate binding")
+var ErrTooManyCertificateBindings = fmt.Errorf("a user can have at most %d certificate bindings", MaxCertificateUserIds)
+
+// CertificateBinding is a parsed certificateUserIds value. Value holds the principal
+// name, RFC822 name, SKI or public key hash. Issuer, Subject and SerialNumber are used
+// by the issuer and subject based bindings.
+type CertificateBinding struct {
+	Type         CertificateBindingType
+	Value        string
+	Issuer       string
+	Subject      string
+	SerialNumber string
+}
+
+// String formats the binding as a certificateUserIds value, e.g. X509:<PN>user@domain
+func (b *CertificateBinding) String() string {
+	switch b.Type {
+	case CertBindingIssuerSerial:
+		return CertificateUserIdPrefix + "<I>" + b.Issuer + "<SR>" + b.SerialNumber
+	case CertBindingIssuerSubject:
+		return CertificateUserIdPrefix + "<I>" + b.Issuer + "<S>" + b.Subject
+	case CertBindingSubject:
+		return CertificateUserIdPrefix + "<S>" + b.Subject
+	case CertBindingUnknown:
+		return b.Value
+	}
+
+	return CertificateUserIdPrefix + "<" + string(b.Type) + ">" + b.Value
+}
+
+// Equals compares two bindings. Values are compared case insensitively
+func (b *CertificateBinding) Equals(other *CertificateBinding) bool {
+	return other != nil && strings.EqualFold(b.String(), other.String())
+}
+
+func NewPrincipalNameBinding(principalName string) *CertificateBinding {
+	return &CertificateBinding{Type: CertBindingPrincipalName, Value: principalName}
+}
+
+// ParseCertificateBinding parses a certificateUserIds value into a binding
+func ParseCertificateBinding(certUserId string) (*CertificateBinding, error) {
+	if !hasCertificateUserIdPrefix(certUserId) {
+		return nil, fmt.Errorf("%w: missing %s prefix in %s", ErrInvalidCertificateBinding, CertificateUserIdPrefix, certUserId)
+	}
+	rest := certUserId[len(CertificateUserIdPrefix):]
+
+	switch {
+	case strings.HasPrefix(rest, "<I>"):
+		issuer := rest[len("<I>"):]
+		if i := strings.Index(issuer, "<SR>"); i >= 0 {
+			return checkBinding(certUserId, &CertificateBinding{
+				Type:         CertBindingIssuerSerial,
+				Issuer:       issuer[:i],
+				SerialNumber: issuer[i+len("<SR>"):],
+			})
+		}
+		if i := strings.Index(issuer, "<S>"); i >= 0 {
+			return checkBinding(certUserId, &CertificateBinding{
+				Type:    CertBindingIssuerSubject,
+				Issuer:  issuer[:i],
+				Subject: issuer[i+len("<S>"):],
+			})
+		}
+		return nil, fmt.Errorf("%w: issuer without serial number or subject in %s", ErrInvalidCertificateBinding, certUserId)
+
+	case strings.HasPrefix(rest, "<S>"):
+		return checkBinding(certUserId, &CertificateBinding{
+			Type:    CertBindingSubject,
+			Subject: rest[len("<S>"):],
+		})
+	}
+
+	for _, bindingType := range []CertificateBindingType{CertBindingPrincipalName, CertBindingRFC822, CertBindingSKI, CertBindingSHA1PublicKey} {
+		tag := "<" + string(bindingType) + ">"
+		if strings.HasPrefix(rest, tag) {
+			return checkBinding(certUserId, &CertificateBinding{
+				Type:  bindingType,
+				Value: rest[len(tag):],
+			})
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown format %s", ErrInvalidCertificateBinding, certUserId)
+}
+
+func checkBinding(certUserId string, b *CertificateBinding) (*CertificateBinding, error) {
+	if b.Value == "" && b.Subject == "" && b.SerialNumber == "" {
+		return nil, fmt.Errorf("%w: empty value in %s", ErrInvalidCertificateBinding, certUserId)
+	}
+	if (b.Type == CertBindingIssuerSerial || b.Type == CertBindingIssuerSubject) && b.Issuer == "" {
+		return nil, fmt.Errorf("%w: empty issuer in %s", ErrInvalidCertificateBinding, certUserId)
+	}
+	return b, nil
+}
+
+// ParseCertificateBindings parses all the certificate user ids. Values that cannot be
+// parsed are kept as CertBindingUnknown so they are preserved when the bindings are written back.
+func ParseCertificateBindings(certUserIds []string) []*CertificateBinding {
+	rtn := []*CertificateBinding{}
+	for _, certUserId := range certUserIds {
+		b, err := ParseCertificateBinding(certUserId)
+		if err != nil {
+			b = &CertificateBinding{Type: CertBindingUnknown, Value: certUserId}
+		}
+		rtn = append(rtn, b)
+	}
+	return rtn
+}
+
+// MergeCertificateBindings adds the bindings to the existing ones, skipping duplicates.
+// Returns ErrTooManyCertificateBindings if the result exceeds MaxCertificateUserIds.
+func MergeCertificateBindings(existing []*CertificateBinding, add ...*CertificateBinding) ([]*CertificateBinding, bool, error) {
+	rtn := append([]*CertificateBinding{}, existing...)
+	changed := false
+
+	for _, b := range add {
+		if indexOfBinding(rtn, b) >= 0 {
+			continue
+		}
+		rtn = append(rtn, b)
+		changed = true
+	}
+
+	if len(rtn) > MaxCertificateUserIds {
+		return existing, false, ErrTooManyCertificateBindings
+	}
+
+	return rtn, changed, nil
+}
+
+// RemoveCertificateBindings removes the given bindings from the existing ones
+func RemoveCertificateBindings(existing []*CertificateBinding, remove ...*CertificateBinding) ([]*CertificateBinding, bool) {
+	rtn := []*CertificateBinding{}
+	for _, b := range existing {
+		if indexOfBinding(remove, b) < 0 {
+			rtn = append(rtn, b)
+		}
+	}
+	return rtn, len(rtn) != len(existing)
+}
+
+func indexOfBinding(bindings []*CertificateBinding, b *CertificateBinding) int {
+	for i, item := range bindings {
+		if item.Equals(b) {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetCertificateBindings retrieves and parses the certificate user ids of a user
+func (um *MsGraphUserManager) GetCertificateBindings(ctx context.Context, uid string) ([]*CertificateBinding, error) {
+	certIds, err := um.getCertificateUserIds(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCertificateBindings(certIds), nil
+}
+
+// AddCertificateBindings binds the certificates to the user. Bindings that already exist are skipped
+func (um *MsGraphUserManager) AddCertificateBindings(ctx context.Context, uid string, bindings ...*CertificateBinding) error {
+	existing, err := um.GetCertificateBindings(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	merged, changed, err := MergeCertificateBindings(existing, bindings...)
+	if err != nil {
+		return cloudy.Error(ctx, "[%s] AddCertificateBindings - %v", uid, err)
+	}
+
+	if !changed {
+		cloudy.Info(ctx, "[%s] AddCertificateBindings - already bound", uid)
+		return nil
+	}
+
+	return um.SetCertificateBindings(ctx, uid, merged)
+}
+
+// RemoveCertificateBindings removes the bindings from a user
+func (um *MsGraphUserManager) RemoveCertificateBindings(ctx context.Context, uid string, bindings ...*CertificateBinding) error {
+	existing, err := um.GetCertificateBindings(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	remaining, changed := RemoveCertificateBindings(existing, bindings...)
+	if !changed {
+		return nil
+	}
+
+	return um.SetCertificateBindings(ctx, uid, remaining)
+}
+
+// SetCertificateBindings replaces all the certificate bindings of a user. Only the
+// authorizationInfo of the user is patched.
+func (um *MsGraphUserManager) SetCertificateBindings(ctx context.Context, uid string, bindings []*CertificateBinding) error {
+	if len(bindings) > MaxCertificateUserIds {
+		return cloudy.Error(ctx, "[%s] SetCertificateBindings - %v", uid, ErrTooManyCertificateBindings)
+	}
+
+	certIds := []string{}
+	for _, b := range bindings {
+		certIds = append(certIds, b.String())
+	}
+
+	info := models.NewAuthorizationInfo()
+	info.SetCertificateUserIds(certIds)
+
+	u := models.NewUser()
+	u.SetAuthorizationInfo(info)
+
+	_, err := um.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SetCertificateBindings Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+func (um *MsGraphUserManager) getCertificateUserIds(ctx context.Context, uid string) ([]string, error) {
+	azUser, err := um.Client.Users().ByUserId(uid).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+				Select: []string{"id", "authorizationInfo"},
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] getCertificateUserIds Error: %s", uid, message)
+	}
+
+	if azUser.GetAuthorizationInfo() == nil {
+		return []string{}, nil
+	}
+
+	return azUser.GetAuthorizationInfo().GetCertificateUserIds(), nil
+}
+
+// hasCertificateUserIdPrefix returns true when the value starts with the X509: prefix, Graph
+// compares it case insensitively
+func hasCertificateUserIdPrefix(certUserId string) bool {
+	return len(certUserId) >= len(CertificateUserIdPrefix) && strings.EqualFold(certUserId[:len(CertificateUserIdPrefix)], CertificateUserIdPrefix)
+}
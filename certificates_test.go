@@ -0,0 +1,83 @@
+package cloudymsgraph
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateBindingParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected CertificateBinding
+	}{
+		{"X509:<PN>1234567890@mil", CertificateBinding{Type: CertBindingPrincipalName, Value: "1234567890@mil"}},
+		{"X509:<RFC822>user@example.com", CertificateBinding{Type: CertBindingRFC822, Value: "user@example.com"}},
+		{"X509:<SKI>aB1cD2eF3", CertificateBinding{Type: CertBindingSKI, Value: "aB1cD2eF3"}},
+		{"X509:<SHA1-PUKEY>cD2eF3aB1", CertificateBinding{Type: CertBindingSHA1PublicKey, Value: "cD2eF3aB1"}},
+		{"X509:<I>DC=com,DC=contoso,CN=CONTOSO-DC-CA<SR>b24134139f069b49997212a86ba0ef48", CertificateBinding{Type: CertBindingIssuerSerial, Issuer: "DC=com,DC=contoso,CN=CONTOSO-DC-CA", SerialNumber: "b24134139f069b49997212a86ba0ef48"}},
+		{"X509:<S>DC=com,DC=contoso,OU=UserAccounts,CN=mfatest", CertificateBinding{Type: CertBindingSubject, Subject: "DC=com,DC=contoso,OU=UserAccounts,CN=mfatest"}},
+		{"X509:<I>DC=com,DC=contoso,CN=CONTOSO-DC-CA<S>DC=com,DC=contoso,OU=UserAccounts,CN=mfatest", CertificateBinding{Type: CertBindingIssuerSubject, Issuer: "DC=com,DC=contoso,CN=CONTOSO-DC-CA", Subject: "DC=com,DC=contoso,OU=UserAccounts,CN=mfatest"}},
+	}
+
+	for _, test := range tests {
+		b, err := ParseCertificateBinding(test.in)
+		assert.Nil(t, err, test.in)
+		assert.Equal(t, test.expected, *b, test.in)
+		assert.Equal(t, test.in, b.String())
+	}
+
+	invalid := []string{
+		"1234567890@mil",
+		"X509:<PN>",
+		"X509:<XX>value",
+		"X509:<I>issuer only",
+		"X509:<I><SR>1234",
+	}
+	for _, in := range invalid {
+		_, err := ParseCertificateBinding(in)
+		assert.True(t, errors.Is(err, ErrInvalidCertificateBinding), in)
+	}
+
+	// the prefix is case insensitive
+	assert.True(t, hasCertificateUserIdPrefix("x509:<PN>1234567890@mil"))
+	assert.False(t, hasCertificateUserIdPrefix("X50"))
+	b, err := ParseCertificateBinding("x509:<PN>1234567890@mil")
+	assert.Nil(t, err)
+	assert.Equal(t, CertBindingPrincipalName, b.Type)
+
+	bindings := ParseCertificateBindings([]string{"X509:<PN>a@mil", "garbage"})
+	assert.Equal(t, CertBindingUnknown, bindings[1].Type)
+	assert.Equal(t, "garbage", bindings[1].String())
+}
+
+func TestCertificateBindingMerge(t *testing.T) {
+	existing := ParseCertificateBindings([]string{"X509:<PN>1234567890@mil", "X509:<RFC822>user@example.com"})
+
+	merged, changed, err := MergeCertificateBindings(existing, NewPrincipalNameBinding("1234567890@MIL"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Len(t, merged, 2)
+
+	merged, changed, err = MergeCertificateBindings(existing,
+		NewPrincipalNameBinding("0987654321@mil"),
+		NewPrincipalNameBinding("0987654321@mil"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Len(t, merged, 3)
+
+	_, _, err = MergeCertificateBindings(merged,
+		NewPrincipalNameBinding("1@mil"),
+		NewPrincipalNameBinding("2@mil"),
+		NewPrincipalNameBinding("3@mil"))
+	assert.True(t, errors.Is(err, ErrTooManyCertificateBindings))
+
+	remaining, changed := RemoveCertificateBindings(merged, NewPrincipalNameBinding("1234567890@mil"))
+	assert.True(t, changed)
+	assert.Len(t, remaining, 2)
+	assert.Equal(t, "X509:<RFC822>user@example.com", remaining[0].String())
+
+	_, changed = RemoveCertificateBindings(remaining, NewPrincipalNameBinding("nobody@mil"))
+	assert.False(t, changed)
+}
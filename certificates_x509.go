@@ -0,0 +1,224 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+)
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+var oidUserPrincipalName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+// Short names Entra uses when formatting issuer and subject names
+var attributeTypeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "SERIALNUMBER",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "S",
+	"2.5.4.9":                    "STREET",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "E",
+}
+
+// CertificateBindingPolicy controls which certificate user ids are derived from a certificate
+type CertificateBindingPolicy struct {
+	// Types of bindings to produce, in order of preference. Types the certificate
+	// does not have the data for are skipped
+	Types []CertificateBindingType
+
+	// ReverseSerialNumber writes the serial number in reverse byte order, as Active
+	// Directory altSecurityIdentities mappings do
+	ReverseSerialNumber bool
+}
+
+// DefaultCertificateBindingPolicy binds CAC/PIV certificates by the UPN in the subject alternative name
+var DefaultCertificateBindingPolicy = &CertificateBindingPolicy{
+	Types: []CertificateBindingType{CertBindingPrincipalName},
+}
+
+// ParseCertificate reads a PEM or DER encoded certificate
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	return x509.ParseCertificate(data)
+}
+
+// CertificatePrincipalNames returns the user principal names in the subject alternative
+// name otherName fields of the certificate. On a CAC this is the EDIPI@mil value.
+func CertificatePrincipalNames(cert *x509.Certificate) ([]string, error) {
+	rtn := []string{}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(ext.Value, &seq)
+		if err != nil {
+			return nil, err
+		} else if len(rest) != 0 || !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+			return nil, fmt.Errorf("invalid subject alternative name extension")
+		}
+
+		rest = seq.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			rest, err = asn1.Unmarshal(rest, &name)
+			if err != nil {
+				return nil, err
+			}
+
+			// otherName [0]
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			// The value is an explicitly tagged [0] UTF8String
+			var other struct {
+				TypeID asn1.ObjectIdentifier
+				Value  asn1.RawValue
+			}
+			_, err = asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0")
+			if err != nil {
+				return nil, err
+			}
+
+			if !other.TypeID.Equal(oidUserPrincipalName) {
+				continue
+			}
+
+			var upn string
+			_, err = asn1.Unmarshal(other.Value.Bytes, &upn)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, upn)
+		}
+	}
+
+	return rtn, nil
+}
+
+// CertificateBindings derives the candidate certificate user ids from the certificate
+// according to the policy. A nil policy uses DefaultCertificateBindingPolicy.
+func CertificateBindings(cert *x509.Certificate, policy *CertificateBindingPolicy) ([]*CertificateBinding, error) {
+	if policy == nil {
+		policy = DefaultCertificateBindingPolicy
+	}
+
+	rtn := []*CertificateBinding{}
+	for _, bindingType := range policy.Types {
+		switch bindingType {
+		case CertBindingPrincipalName:
+			names, err := CertificatePrincipalNames(cert)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				rtn = append(rtn, NewPrincipalNameBinding(name))
+			}
+		case CertBindingRFC822:
+			for _, email := range cert.EmailAddresses {
+				rtn = append(rtn, &CertificateBinding{Type: CertBindingRFC822, Value: email})
+			}
+		case CertBindingSKI:
+			if len(cert.SubjectKeyId) > 0 {
+				rtn = append(rtn, &CertificateBinding{Type: CertBindingSKI, Value: hex.EncodeToString(cert.SubjectKeyId)})
+			}
+		case CertBindingSHA1PublicKey:
+			// Entra compares this to the SHA1 thumbprint of the certificate
+			sum := sha1.Sum(cert.Raw)
+			rtn = append(rtn, &CertificateBinding{Type: CertBindingSHA1PublicKey, Value: hex.EncodeToString(sum[:])})
+		case CertBindingIssuerSerial:
+			serial := cert.SerialNumber.Bytes()
+			if policy.ReverseSerialNumber {
+				serial = reverseBytes(serial)
+			}
+			rtn = append(rtn, &CertificateBinding{
+				Type:         CertBindingIssuerSerial,
+				Issuer:       DistinguishedName(cert.Issuer),
+				SerialNumber: hex.EncodeToString(serial),
+			})
+		case CertBindingSubject:
+			rtn = append(rtn, &CertificateBinding{Type: CertBindingSubject, Subject: DistinguishedName(cert.Subject)})
+		case CertBindingIssuerSubject:
+			rtn = append(rtn, &CertificateBinding{
+				Type:    CertBindingIssuerSubject,
+				Issuer:  DistinguishedName(cert.Issuer),
+				Subject: DistinguishedName(cert.Subject),
+			})
+		default:
+			return nil, fmt.Errorf("%w: unsupported binding type %s", ErrInvalidCertificateBinding, bindingType)
+		}
+	}
+
+	return rtn, nil
+}
+
+// DistinguishedName formats the name the way Entra expects it in certificate user ids,
+// in the order the attributes appear in the certificate, e.g. DC=com,DC=contoso,CN=CONTOSO-DC-CA
+func DistinguishedName(name pkix.Name) string {
+	parts := []string{}
+	for _, rdn := range name.ToRDNSequence() {
+		for _, atv := range rdn {
+			key, ok := attributeTypeNames[atv.Type.String()]
+			if !ok {
+				key = atv.Type.String()
+			}
+			parts = append(parts, fmt.Sprintf("%s=%v", key, atv.Value))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func reverseBytes(data []byte) []byte {
+	rtn := make([]byte, len(data))
+	for i, b := range data {
+		rtn[len(data)-1-i] = b
+	}
+	return rtn
+}
+
+// BindCertificate derives the certificate user ids from an uploaded PEM or DER certificate,
+// using the configured CertificateBindingPolicy, and binds them to the user. Returns the
+// bindings derived from the certificate.
+func (um *MsGraphUserManager) BindCertificate(ctx context.Context, uid string, certData []byte) ([]*CertificateBinding, error) {
+	cert, err := ParseCertificate(certData)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "[%s] BindCertificate - invalid certificate: %v", uid, err)
+	}
+
+	bindings, err := CertificateBindings(cert, um.Cfg.CertificateBindingPolicy)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "[%s] BindCertificate - %v", uid, err)
+	}
+
+	if len(bindings) == 0 {
+		return nil, cloudy.Error(ctx, "[%s] BindCertificate - no certificate user ids found in %s", uid, cert.Subject.String())
+	}
+
+	err = um.AddCertificateBindings(ctx, uid, bindings...)
+	if err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
@@ -0,0 +1,114 @@
+package cloudymsgraph
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCertificate(t *testing.T) []byte {
+	upn, err := asn1.Marshal("1234567890@mil")
+	assert.Nil(t, err)
+
+	otherName, err := asn1.MarshalWithParams(struct {
+		TypeID asn1.ObjectIdentifier
+		Value  asn1.RawValue
+	}{
+		TypeID: oidUserPrincipalName,
+		Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: upn},
+	}, "tag:0")
+	assert.Nil(t, err)
+
+	email := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte("john.doe@mail.mil")}
+	emailBytes, err := asn1.Marshal(email)
+	assert.Nil(t, err)
+
+	san, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(otherName, emailBytes...)})
+	assert.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x0102ab),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"U.S. Government"},
+			OrganizationalUnit: []string{"DoD", "PKI"},
+			CommonName:         "DOE.JOHN.1234567890",
+		},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		SubjectKeyId:    []byte{0xab, 0xcd, 0xef},
+		ExtraExtensions: []pkix.Extension{{Id: oidSubjectAltName, Value: san}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return der
+}
+
+func TestCertificateBindingsFromCertificate(t *testing.T) {
+	der := testCertificate(t)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	cert, err := ParseCertificate(pemData)
+	assert.Nil(t, err)
+
+	fromDer, err := ParseCertificate(der)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Raw, fromDer.Raw)
+
+	names, err := CertificatePrincipalNames(cert)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1234567890@mil"}, names)
+
+	bindings, err := CertificateBindings(cert, nil)
+	assert.Nil(t, err)
+	assert.Len(t, bindings, 1)
+	assert.Equal(t, "X509:<PN>1234567890@mil", bindings[0].String())
+
+	sum := sha1.Sum(der)
+	bindings, err = CertificateBindings(cert, &CertificateBindingPolicy{
+		Types: []CertificateBindingType{
+			CertBindingPrincipalName,
+			CertBindingRFC822,
+			CertBindingSKI,
+			CertBindingSHA1PublicKey,
+			CertBindingIssuerSerial,
+			CertBindingSubject,
+		},
+		ReverseSerialNumber: true,
+	})
+	assert.Nil(t, err)
+
+	subject := "C=US,O=U.S. Government,OU=DoD,OU=PKI,CN=DOE.JOHN.1234567890"
+	expected := []string{
+		"X509:<PN>1234567890@mil",
+		"X509:<RFC822>john.doe@mail.mil",
+		"X509:<SKI>abcdef",
+		"X509:<SHA1-PUKEY>" + hex.EncodeToString(sum[:]),
+		"X509:<I>" + subject + "<SR>ab0201",
+		"X509:<S>" + subject,
+	}
+	actual := []string{}
+	for _, b := range bindings {
+		actual = append(actual, b.String())
+	}
+	assert.Equal(t, expected, actual)
+
+	_, err = ParseCertificate([]byte("not a certificate"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,458 @@
+package cloudymsgraph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// CustomSecurityAttributeType is the data type of a custom security attribute
+type CustomSecurityAttributeType string
+
+const (
+	CustomSecurityAttributeString  CustomSecurityAttributeType = "String"
+	CustomSecurityAttributeInteger CustomSecurityAttributeType = "Integer"
+	CustomSecurityAttributeBoolean CustomSecurityAttributeType = "Boolean"
+)
+
+const customSecurityAttributeValueOdataType = "#microsoft.graph.customSecurityAttributeValue"
+
+// CustomSecurityAttributeMapping maps a custom security attribute to a field of the
+// cloudy User or, when Field is empty, to an entry in the extra attributes map.
+type CustomSecurityAttributeMapping struct {
+	// Field is the name of the cloudy User field, e.g. "ContractNumber". Multi valued
+	// attributes are stored in the field as a comma separated list
+	Field string
+
+	// Name is the key used in the extra attributes map. Defaults to Attribute
+	Name string
+
+	AttributeSet string
+	Attribute    string
+	Type         CustomSecurityAttributeType
+	MultiValued  bool
+}
+
+func (m *CustomSecurityAttributeMapping) key() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Attribute
+}
+
+// CustomSecurityAttributeMappings is the set of custom security attributes read and
+// written for users
+type CustomSecurityAttributeMappings []*CustomSecurityAttributeMapping
+
+// DefaultCustomSecurityAttributes maps the cloudy attribute set
+var DefaultCustomSecurityAttributes = CustomSecurityAttributeMappings{
+	{Field: "AccountType", AttributeSet: "cloudy", Attribute: "AccountType", Type: CustomSecurityAttributeString},
+	{Field: "Citizenship", AttributeSet: "cloudy", Attribute: "Citizenship", Type: CustomSecurityAttributeString},
+	{Field: "ContractDate", AttributeSet: "cloudy", Attribute: "ContractExpirationDate", Type: CustomSecurityAttributeString},
+	{Field: "ContractNumber", AttributeSet: "cloudy", Attribute: "ContractNumber", Type: CustomSecurityAttributeString},
+	{Field: "Organization", AttributeSet: "cloudy", Attribute: "Organization", Type: CustomSecurityAttributeString},
+	{Field: "Project", AttributeSet: "cloudy", Attribute: "Project", Type: CustomSecurityAttributeString},
+	{Field: "ProgramRole", AttributeSet: "cloudy", Attribute: "ProgramRole", Type: CustomSecurityAttributeString},
+	{AttributeSet: "cloudy", Attribute: "Justification", Type: CustomSecurityAttributeString},
+	{AttributeSet: "cloudy", Attribute: "Sponsor", Type: CustomSecurityAttributeString},
+	{AttributeSet: "cloudy", Attribute: "StatusReason", Type: CustomSecurityAttributeString},
+}
+
+// customSecurityAttributes returns the configured mappings or the defaults
+func (graph *MsGraph) customSecurityAttributes() CustomSecurityAttributeMappings {
+	if graph.Cfg != nil && graph.Cfg.CustomSecurityAttributes != nil {
+		return graph.Cfg.CustomSecurityAttributes
+	}
+	return DefaultCustomSecurityAttributes
+}
+
+// userToCloudy converts the user with the configured custom security attributes
+func (graph *MsGraph) userToCloudy(user models.Userable) *cloudymodels.User {
+	u, _ := graph.userToCloudyWithAttributes(user)
+	return u
+}
+
+// userToCloudyWithAttributes converts the user with the configured custom security
+// attributes and street address fallback
+func (graph *MsGraph) userToCloudyWithAttributes(user models.Userable) (*cloudymodels.User, map[string]interface{}) {
+	legacyFallback := graph.Cfg == nil || !graph.Cfg.DisableStreetAddressFallback
+	return userToCloudy(user, graph.customSecurityAttributes(), legacyFallback)
+}
+
+// AttributeSets lists the distinct attribute sets used by the mappings
+func (mappings CustomSecurityAttributeMappings) AttributeSets() []string {
+	rtn := []string{}
+	for _, m := range mappings {
+		if !cloudy.StrContains(m.AttributeSet, rtn) {
+			rtn = append(rtn, m.AttributeSet)
+		}
+	}
+	return rtn
+}
+
+// ToAzure builds the custom security attributes of a user from the mapped fields
+// and the extra attributes. Empty fields are not sent. Returns nil when there are
+// no attributes to send.
+func (mappings CustomSecurityAttributeMappings) ToAzure(user *cloudymodels.User, extra map[string]interface{}) *models.CustomSecurityAttributeValue {
+	sets := make(map[string]map[string]interface{})
+
+	for _, m := range mappings {
+		var value interface{}
+		var ok bool
+
+		if m.Field != "" {
+			value, ok = m.fromField(cloudy.GetFieldString(user, m.Field))
+		} else if raw, exists := extra[m.key()]; exists {
+			value, ok = m.fromValue(raw)
+		}
+
+		if !ok {
+			continue
+		}
+
+		m.set(sets, value)
+	}
+
+	return customSecurityAttributeValue(sets)
+}
+
+// ToCloudy reads the mapped custom security attributes into the user fields and
+// returns the unbound attributes keyed by name. Returns false when the user has
+// none of the mapped attribute sets.
+func (mappings CustomSecurityAttributeMappings) ToCloudy(azUser models.Userable, user *cloudymodels.User) (map[string]interface{}, bool) {
+	extra := make(map[string]interface{})
+
+	csa := azUser.GetCustomSecurityAttributes()
+	if csa == nil || csa.GetAdditionalData() == nil {
+		return extra, false
+	}
+
+	found := false
+	for _, m := range mappings {
+		set, ok := csa.GetAdditionalData()[m.AttributeSet].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		found = true
+
+		raw, exists := set[m.Attribute]
+		if !exists || raw == nil {
+			continue
+		}
+
+		value, ok := m.fromValue(raw)
+		if !ok {
+			continue
+		}
+
+		if m.Field != "" {
+			cloudy.SetFieldString(user, m.Field, m.toField(value))
+		} else {
+			extra[m.key()] = plainValue(value)
+		}
+	}
+
+	return extra, found
+}
+
+// Diff builds the custom security attributes that changed between the current and the
+// updated user. Cleared values are sent as null, or an empty collection when multi valued.
+// Unbound attributes are only compared when present in extra. Returns nil when nothing changed.
+func (mappings CustomSecurityAttributeMappings) Diff(user *cloudymodels.User, extra map[string]interface{},
+	current *cloudymodels.User, currentExtra map[string]interface{}) (*models.CustomSecurityAttributeValue, []*UserChange) {
+
+	sets := make(map[string]map[string]interface{})
+	changes := []*UserChange{}
+
+	for _, m := range mappings {
+		var value, old interface{}
+		var ok, oldOk bool
+
+		if m.Field != "" {
+			value, ok = m.fromField(cloudy.GetFieldString(user, m.Field))
+			old, oldOk = m.fromField(cloudy.GetFieldString(current, m.Field))
+		} else {
+			raw, exists := extra[m.key()]
+			if !exists {
+				continue
+			}
+			value, ok = m.fromValue(raw)
+			old, oldOk = m.fromValue(currentExtra[m.key()])
+		}
+
+		newValue, oldValue := "", ""
+		if ok {
+			newValue = m.toField(value)
+		}
+		if oldOk {
+			oldValue = m.toField(old)
+		}
+		if newValue == oldValue {
+			continue
+		}
+
+		if newValue == "" {
+			value = m.cleared()
+		}
+		m.set(sets, value)
+		changes = append(changes, &UserChange{Property: m.AttributeSet + "." + m.Attribute, Old: oldValue, New: newValue})
+	}
+
+	return customSecurityAttributeValue(sets), changes
+}
+
+// cleared is the value that removes the attribute from a user
+func (m *CustomSecurityAttributeMapping) cleared() interface{} {
+	switch {
+	case m.MultiValued && m.Type == CustomSecurityAttributeInteger:
+		return []int32{}
+	case m.MultiValued:
+		return []string{}
+	}
+	return nil
+}
+
+func (m *CustomSecurityAttributeMapping) set(sets map[string]map[string]interface{}, value interface{}) {
+	set, exists := sets[m.AttributeSet]
+	if !exists {
+		set = map[string]interface{}{
+			"@odata.type": cloudy.StringP(customSecurityAttributeValueOdataType),
+		}
+		sets[m.AttributeSet] = set
+	}
+
+	set[m.Attribute] = value
+	if odataType := m.odataType(); odataType != "" {
+		set[m.Attribute+"@odata.type"] = cloudy.StringP(odataType)
+	}
+}
+
+// odataType is the type annotation Graph requires for integers and collections
+func (m *CustomSecurityAttributeMapping) odataType() string {
+	switch {
+	case m.MultiValued && m.Type == CustomSecurityAttributeInteger:
+		return "#Collection(Int32)"
+	case m.MultiValued:
+		return "#Collection(String)"
+	case m.Type == CustomSecurityAttributeInteger:
+		return "#Int32"
+	}
+	return ""
+}
+
+// fromField converts the string value of a user field to the attribute value
+func (m *CustomSecurityAttributeMapping) fromField(field string) (interface{}, bool) {
+	if field == "" {
+		return nil, false
+	}
+
+	if m.MultiValued {
+		values := []string{}
+		for _, v := range strings.Split(field, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+		return m.fromValue(values)
+	}
+
+	return m.fromValue(field)
+}
+
+// toField converts an attribute value to the string stored in a user field
+func (m *CustomSecurityAttributeMapping) toField(value interface{}) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []int32:
+		values := []string{}
+		for _, i := range v {
+			values = append(values, strconv.Itoa(int(i)))
+		}
+		return strings.Join(values, ",")
+	case *string:
+		return *v
+	case *int32:
+		return strconv.Itoa(int(*v))
+	case *bool:
+		return strconv.FormatBool(*v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// fromValue normalizes a value, either read from Graph or supplied by the caller,
+// to the representation written to Graph: *string, *int32, *bool, []string or []int32
+func (m *CustomSecurityAttributeMapping) fromValue(value interface{}) (interface{}, bool) {
+	if m.MultiValued {
+		items := toInterfaceSlice(value)
+		if items == nil {
+			return nil, false
+		}
+
+		if m.Type == CustomSecurityAttributeInteger {
+			rtn := []int32{}
+			for _, item := range items {
+				i, ok := toInt32(item)
+				if !ok {
+					return nil, false
+				}
+				rtn = append(rtn, i)
+			}
+			return rtn, true
+		}
+
+		rtn := []string{}
+		for _, item := range items {
+			s, ok := toString(item)
+			if !ok {
+				return nil, false
+			}
+			rtn = append(rtn, s)
+		}
+		return rtn, true
+	}
+
+	switch m.Type {
+	case CustomSecurityAttributeInteger:
+		i, ok := toInt32(value)
+		if !ok {
+			return nil, false
+		}
+		return &i, true
+	case CustomSecurityAttributeBoolean:
+		b, ok := toBool(value)
+		if !ok {
+			return nil, false
+		}
+		return &b, true
+	}
+
+	s, ok := toString(value)
+	if !ok {
+		return nil, false
+	}
+	return &s, true
+}
+
+// plainValue dereferences the attribute value for the extra attributes map
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *string:
+		return *v
+	case *int32:
+		return int(*v)
+	case *bool:
+		return *v
+	case []int32:
+		rtn := []int{}
+		for _, i := range v {
+			rtn = append(rtn, int(i))
+		}
+		return rtn
+	}
+	return value
+}
+
+func customSecurityAttributeValue(sets map[string]map[string]interface{}) *models.CustomSecurityAttributeValue {
+	if len(sets) == 0 {
+		return nil
+	}
+
+	csa := models.NewCustomSecurityAttributeValue()
+	for name, set := range sets {
+		csa.GetAdditionalData()[name] = set
+	}
+	return csa
+}
+
+func toInterfaceSlice(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		rtn := []interface{}{}
+		for _, s := range v {
+			rtn = append(rtn, s)
+		}
+		return rtn
+	case []int:
+		rtn := []interface{}{}
+		for _, i := range v {
+			rtn = append(rtn, i)
+		}
+		return rtn
+	case []int32:
+		rtn := []interface{}{}
+		for _, i := range v {
+			rtn = append(rtn, i)
+		}
+		return rtn
+	}
+	return nil
+}
+
+func toString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case *string:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return "", false
+}
+
+func toInt32(value interface{}) (int32, bool) {
+	switch v := value.(type) {
+	case int:
+		return int32(v), true
+	case int32:
+		return v, true
+	case int64:
+		return int32(v), true
+	case *int32:
+		if v != nil {
+			return *v, true
+		}
+	case *int64:
+		if v != nil {
+			return int32(*v), true
+		}
+	case float64:
+		return int32(v), v == float64(int32(v))
+	case *float64:
+		if v != nil {
+			return int32(*v), true
+		}
+	case string:
+		i, err := strconv.Atoi(v)
+		return int32(i), err == nil
+	case *string:
+		if v != nil {
+			return toInt32(*v)
+		}
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case *bool:
+		if v != nil {
+			return *v, true
+		}
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	case *string:
+		if v != nil {
+			return toBool(*v)
+		}
+	}
+	return false, false
+}
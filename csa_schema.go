@@ -0,0 +1,499 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/directory"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+const (
+	AttributeStatusAvailable  = "Available"
+	AttributeStatusDeprecated = "Deprecated"
+)
+
+type AttributeSetDefinition struct {
+	Name                string
+	Description         string
+	MaxAttributesPerSet int
+}
+
+type AttributeDefinition struct {
+	AttributeSet string
+	Name         string
+	Description  string
+	Type         CustomSecurityAttributeType
+	MultiValued  bool
+
+	// Searchable cannot be changed once the definition is created. Nil does not
+	// check it and creates a definition that is not searchable.
+	Searchable *bool
+
+	// UsePredefinedValuesOnly restricts the values to the active AllowedValues. It can
+	// be turned off but not on once the definition is created. Nil leaves it unchanged.
+	UsePredefinedValuesOnly *bool
+
+	// AllowedValues are the active predefined values, values no longer listed are
+	// deactivated. Nil leaves the predefined values unchanged.
+	AllowedValues []string
+
+	// Status is Available or Deprecated
+	Status string
+
+	// inactiveValues are allowed values that have been deactivated
+	inactiveValues []string
+}
+
+// ID is the id Graph uses for the definition, {attributeSet}_{name}
+func (def *AttributeDefinition) ID() string {
+	return def.AttributeSet + "_" + def.Name
+}
+
+// AttributeSchema is the declared set of custom security attributes of a tenant
+type AttributeSchema struct {
+	AttributeSets []*AttributeSetDefinition
+	Attributes    []*AttributeDefinition
+}
+
+// SchemaFromMappings declares the attribute sets and definitions needed by the mappings
+func SchemaFromMappings(mappings CustomSecurityAttributeMappings) *AttributeSchema {
+	schema := &AttributeSchema{}
+	for _, set := range mappings.AttributeSets() {
+		schema.AttributeSets = append(schema.AttributeSets, &AttributeSetDefinition{Name: set})
+	}
+
+	for _, m := range mappings {
+		schema.Attributes = append(schema.Attributes, &AttributeDefinition{
+			AttributeSet: m.AttributeSet,
+			Name:         m.Attribute,
+			Type:         m.Type,
+			MultiValued:  m.MultiValued,
+			Status:       AttributeStatusAvailable,
+		})
+	}
+
+	return schema
+}
+
+// MsGraphAttributeManager manages the custom security attribute sets and definitions of the tenant
+type MsGraphAttributeManager struct {
+	*MsGraph
+}
+
+func NewMsGraphAttributeManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphAttributeManager, error) {
+	am := &MsGraphAttributeManager{
+		MsGraph: &MsGraph{},
+	}
+	err := am.Configure(cfg)
+
+	return am, err
+}
+
+func (am *MsGraphAttributeManager) ListAttributeSets(ctx context.Context) ([]*AttributeSetDefinition, error) {
+	result, err := am.Client.Directory().AttributeSets().Get(ctx, nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListAttributeSets Error: %s", message)
+	}
+
+	rtn := []*AttributeSetDefinition{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.AttributeSetable](result, am.Adapter, models.CreateAttributeSetCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(set models.AttributeSetable) bool {
+		rtn = append(rtn, AttributeSetToCloudy(set))
+		return true
+	})
+
+	return rtn, err
+}
+
+func (am *MsGraphAttributeManager) CreateAttributeSet(ctx context.Context, set *AttributeSetDefinition) error {
+	cloudy.Info(ctx, "CreateAttributeSet %s", set.Name)
+
+	_, err := am.Client.Directory().AttributeSets().Post(ctx, AttributeSetToAzure(set), nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] CreateAttributeSet Error: %s", set.Name, message)
+	}
+
+	return nil
+}
+
+// UpdateAttributeSet updates the description and maximum number of attributes of the set
+func (am *MsGraphAttributeManager) UpdateAttributeSet(ctx context.Context, set *AttributeSetDefinition) error {
+	cloudy.Info(ctx, "UpdateAttributeSet %s", set.Name)
+
+	body := AttributeSetToAzure(set)
+	body.SetId(nil)
+
+	_, err := am.Client.Directory().AttributeSets().ByAttributeSetId(set.Name).Patch(ctx, body, nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] UpdateAttributeSet Error: %s", set.Name, message)
+	}
+
+	return nil
+}
+
+// ListAttributeDefinitions lists the attribute definitions along with their allowed values.
+// When attributeSet is empty the definitions of all sets are returned.
+func (am *MsGraphAttributeManager) ListAttributeDefinitions(ctx context.Context, attributeSet string) ([]*AttributeDefinition, error) {
+	params := &directory.CustomSecurityAttributeDefinitionsRequestBuilderGetQueryParameters{
+		Expand: []string{"allowedValues"},
+	}
+	if attributeSet != "" {
+		filter := fmt.Sprintf("attributeSet eq '%s'", odataString(attributeSet))
+		params.Filter = &filter
+	}
+
+	result, err := am.Client.Directory().CustomSecurityAttributeDefinitions().Get(ctx,
+		&directory.CustomSecurityAttributeDefinitionsRequestBuilderGetRequestConfiguration{
+			QueryParameters: params,
+		})
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] ListAttributeDefinitions Error: %s", attributeSet, message)
+	}
+
+	rtn := []*AttributeDefinition{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.CustomSecurityAttributeDefinitionable](result, am.Adapter, models.CreateCustomSecurityAttributeDefinitionCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(def models.CustomSecurityAttributeDefinitionable) bool {
+		rtn = append(rtn, AttributeDefinitionToCloudy(def))
+		return true
+	})
+
+	return rtn, err
+}
+
+func (am *MsGraphAttributeManager) CreateAttributeDefinition(ctx context.Context, def *AttributeDefinition) error {
+	cloudy.Info(ctx, "CreateAttributeDefinition %s", def.ID())
+
+	_, err := am.Client.Directory().CustomSecurityAttributeDefinitions().Post(ctx, AttributeDefinitionToAzure(def), nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] CreateAttributeDefinition Error: %s", def.ID(), message)
+	}
+
+	return nil
+}
+
+// UpdateAttributeDefinition updates the description, status and allowed values of an existing
+// definition. Allowed values cannot be deleted, values no longer allowed are deactivated. The
+// type, collection and searchable settings of a definition cannot be changed once created,
+// and predefined values only cannot be turned on.
+func (am *MsGraphAttributeManager) UpdateAttributeDefinition(ctx context.Context, def *AttributeDefinition) error {
+	current, err := am.getAttributeDefinition(ctx, def.ID())
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return cloudy.Error(ctx, "[%s] UpdateAttributeDefinition - definition does not exist", def.ID())
+	}
+
+	_, err = am.updateAttributeDefinition(ctx, current, def)
+	return err
+}
+
+// EnsureSchema creates or updates the attribute sets and definitions so the tenant matches
+// the schema. Attributes not in the schema are left alone. Returns a description of every
+// change made, an empty list means the tenant already matched.
+func (am *MsGraphAttributeManager) EnsureSchema(ctx context.Context, schema *AttributeSchema) ([]string, error) {
+	changes := []string{}
+
+	sets, err := am.ListAttributeSets(ctx)
+	if err != nil {
+		return changes, err
+	}
+
+	for _, set := range schema.AttributeSets {
+		var current *AttributeSetDefinition
+		for _, s := range sets {
+			if strings.EqualFold(s.Name, set.Name) {
+				current = s
+			}
+		}
+
+		if current == nil {
+			err = am.CreateAttributeSet(ctx, set)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, fmt.Sprintf("created attribute set %s", set.Name))
+			continue
+		}
+
+		if attributeSetChanged(current, set) {
+			err = am.UpdateAttributeSet(ctx, set)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, fmt.Sprintf("updated attribute set %s", set.Name))
+		}
+	}
+
+	definitions, err := am.ListAttributeDefinitions(ctx, "")
+	if err != nil {
+		return changes, err
+	}
+
+	for _, def := range schema.Attributes {
+		var current *AttributeDefinition
+		for _, d := range definitions {
+			if strings.EqualFold(d.ID(), def.ID()) {
+				current = d
+			}
+		}
+
+		if current == nil {
+			err = am.CreateAttributeDefinition(ctx, def)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, fmt.Sprintf("created attribute %s", def.ID()))
+			continue
+		}
+
+		changed, err := am.updateAttributeDefinition(ctx, current, def)
+		if err != nil {
+			return changes, err
+		}
+		if changed {
+			changes = append(changes, fmt.Sprintf("updated attribute %s", def.ID()))
+		}
+	}
+
+	return changes, nil
+}
+
+func (am *MsGraphAttributeManager) getAttributeDefinition(ctx context.Context, id string) (*AttributeDefinition, error) {
+	result, err := am.Client.Directory().CustomSecurityAttributeDefinitions().ByCustomSecurityAttributeDefinitionId(id).Get(ctx,
+		&directory.CustomSecurityAttributeDefinitionsCustomSecurityAttributeDefinitionItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &directory.CustomSecurityAttributeDefinitionsCustomSecurityAttributeDefinitionItemRequestBuilderGetQueryParameters{
+				Expand: []string{"allowedValues"},
+			},
+		})
+	if err != nil {
+		code, message := errorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] getAttributeDefinition Error: %s", id, message)
+	}
+
+	return AttributeDefinitionToCloudy(result), nil
+}
+
+func (am *MsGraphAttributeManager) updateAttributeDefinition(ctx context.Context, current *AttributeDefinition, def *AttributeDefinition) (bool, error) {
+	diff, err := diffAttributeDefinition(current, def)
+	if err != nil {
+		return false, cloudy.Error(ctx, "[%s] UpdateAttributeDefinition - %v", def.ID(), err)
+	}
+
+	item := am.Client.Directory().CustomSecurityAttributeDefinitions().ByCustomSecurityAttributeDefinitionId(def.ID())
+
+	for _, value := range diff.addValues {
+		allowed := models.NewAllowedValue()
+		allowed.SetId(cloudy.StringP(value))
+		allowed.SetIsActive(cloudy.BoolP(true))
+
+		_, err = item.AllowedValues().Post(ctx, allowed, nil)
+		if err != nil {
+			message := errorMessage(ctx, err)
+			return false, cloudy.Error(ctx, "[%s] UpdateAttributeDefinition add value %s Error: %s", def.ID(), value, message)
+		}
+	}
+
+	for value, active := range diff.activateValues {
+		allowed := models.NewAllowedValue()
+		allowed.SetIsActive(cloudy.BoolP(active))
+
+		_, err = item.AllowedValues().ByAllowedValueId(value).Patch(ctx, allowed, nil)
+		if err != nil {
+			message := errorMessage(ctx, err)
+			return false, cloudy.Error(ctx, "[%s] UpdateAttributeDefinition update value %s Error: %s", def.ID(), value, message)
+		}
+	}
+
+	if diff.patch != nil {
+		_, err = item.Patch(ctx, diff.patch, nil)
+		if err != nil {
+			message := errorMessage(ctx, err)
+			return false, cloudy.Error(ctx, "[%s] UpdateAttributeDefinition Error: %s", def.ID(), message)
+		}
+	}
+
+	return diff.changed(), nil
+}
+
+type attributeDefinitionDiff struct {
+	patch          *models.CustomSecurityAttributeDefinition
+	addValues      []string
+	activateValues map[string]bool
+}
+
+func (diff *attributeDefinitionDiff) changed() bool {
+	return diff.patch != nil || len(diff.addValues) > 0 || len(diff.activateValues) > 0
+}
+
+// diffAttributeDefinition works out the changes needed to make the current definition
+// match the desired one. Returns an error for changes Graph does not allow.
+func diffAttributeDefinition(current *AttributeDefinition, desired *AttributeDefinition) (*attributeDefinitionDiff, error) {
+	if current.Type != desired.Type {
+		return nil, fmt.Errorf("type cannot be changed from %s to %s", current.Type, desired.Type)
+	}
+	if current.MultiValued != desired.MultiValued {
+		return nil, fmt.Errorf("multi valued cannot be changed")
+	}
+	if desired.Searchable != nil && cloudy.BoolFromP(current.Searchable) != *desired.Searchable {
+		return nil, fmt.Errorf("searchable cannot be changed")
+	}
+	if cloudy.BoolFromP(desired.UsePredefinedValuesOnly) && !cloudy.BoolFromP(current.UsePredefinedValuesOnly) {
+		return nil, fmt.Errorf("use predefined values only cannot be turned on")
+	}
+
+	diff := &attributeDefinitionDiff{
+		activateValues: make(map[string]bool),
+	}
+
+	patch := models.NewCustomSecurityAttributeDefinition()
+	patched := false
+
+	if desired.Description != "" && current.Description != desired.Description {
+		patch.SetDescription(cloudy.StringP(desired.Description))
+		patched = true
+	}
+	if desired.Status != "" && !strings.EqualFold(current.Status, desired.Status) {
+		patch.SetStatus(cloudy.StringP(desired.Status))
+		patched = true
+	}
+	if desired.UsePredefinedValuesOnly != nil && cloudy.BoolFromP(current.UsePredefinedValuesOnly) != *desired.UsePredefinedValuesOnly {
+		patch.SetUsePreDefinedValuesOnly(cloudy.BoolP(*desired.UsePredefinedValuesOnly))
+		patched = true
+	}
+	if patched {
+		diff.patch = patch
+	}
+
+	if desired.AllowedValues == nil {
+		return diff, nil
+	}
+
+	for _, value := range desired.AllowedValues {
+		if !cloudy.StrContains(value, current.AllowedValues) && !cloudy.StrContains(value, current.inactiveValues) {
+			diff.addValues = append(diff.addValues, value)
+		}
+		if cloudy.StrContains(value, current.inactiveValues) {
+			diff.activateValues[value] = true
+		}
+	}
+	for _, value := range current.AllowedValues {
+		if !cloudy.StrContains(value, desired.AllowedValues) {
+			diff.activateValues[value] = false
+		}
+	}
+
+	return diff, nil
+}
+
+func attributeSetChanged(current *AttributeSetDefinition, desired *AttributeSetDefinition) bool {
+	return (desired.Description != "" && current.Description != desired.Description) ||
+		(desired.MaxAttributesPerSet > 0 && current.MaxAttributesPerSet != desired.MaxAttributesPerSet)
+}
+
+func AttributeSetToAzure(set *AttributeSetDefinition) *models.AttributeSet {
+	body := models.NewAttributeSet()
+	body.SetId(cloudy.StringP(set.Name))
+
+	if set.Description != "" {
+		body.SetDescription(cloudy.StringP(set.Description))
+	}
+
+	if set.MaxAttributesPerSet > 0 {
+		max := int32(set.MaxAttributesPerSet)
+		body.SetMaxAttributesPerSet(&max)
+	}
+
+	return body
+}
+
+func AttributeSetToCloudy(set models.AttributeSetable) *AttributeSetDefinition {
+	rtn := &AttributeSetDefinition{
+		Name:        cloudy.StringFromP(set.GetId()),
+		Description: cloudy.StringFromP(set.GetDescription()),
+	}
+
+	if set.GetMaxAttributesPerSet() != nil {
+		rtn.MaxAttributesPerSet = int(*set.GetMaxAttributesPerSet())
+	}
+
+	return rtn
+}
+
+func AttributeDefinitionToAzure(def *AttributeDefinition) *models.CustomSecurityAttributeDefinition {
+	body := models.NewCustomSecurityAttributeDefinition()
+	body.SetAttributeSet(cloudy.StringP(def.AttributeSet))
+	body.SetName(cloudy.StringP(def.Name))
+	body.SetTypeEscaped(cloudy.StringP(string(def.Type)))
+	body.SetIsCollection(cloudy.BoolP(def.MultiValued))
+	body.SetIsSearchable(cloudy.BoolP(cloudy.BoolFromP(def.Searchable)))
+	body.SetUsePreDefinedValuesOnly(cloudy.BoolP(cloudy.BoolFromP(def.UsePredefinedValuesOnly)))
+
+	if def.Description != "" {
+		body.SetDescription(cloudy.StringP(def.Description))
+	}
+
+	status := def.Status
+	if status == "" {
+		status = AttributeStatusAvailable
+	}
+	body.SetStatus(&status)
+
+	if len(def.AllowedValues) > 0 {
+		allowed := []models.AllowedValueable{}
+		for _, value := range def.AllowedValues {
+			v := models.NewAllowedValue()
+			v.SetId(cloudy.StringP(value))
+			v.SetIsActive(cloudy.BoolP(true))
+			allowed = append(allowed, v)
+		}
+		body.SetAllowedValues(allowed)
+	}
+
+	return body
+}
+
+func AttributeDefinitionToCloudy(def models.CustomSecurityAttributeDefinitionable) *AttributeDefinition {
+	rtn := &AttributeDefinition{
+		AttributeSet:            cloudy.StringFromP(def.GetAttributeSet()),
+		Name:                    cloudy.StringFromP(def.GetName()),
+		Description:             cloudy.StringFromP(def.GetDescription()),
+		Type:                    CustomSecurityAttributeType(cloudy.StringFromP(def.GetTypeEscaped())),
+		MultiValued:             cloudy.BoolFromP(def.GetIsCollection()),
+		Searchable:              def.GetIsSearchable(),
+		UsePredefinedValuesOnly: def.GetUsePreDefinedValuesOnly(),
+		Status:                  cloudy.StringFromP(def.GetStatus()),
+	}
+
+	for _, value := range def.GetAllowedValues() {
+		if cloudy.BoolFromP(value.GetIsActive()) {
+			rtn.AllowedValues = append(rtn.AllowedValues, cloudy.StringFromP(value.GetId()))
+		} else {
+			rtn.inactiveValues = append(rtn.inactiveValues, cloudy.StringFromP(value.GetId()))
+		}
+	}
+
+	return rtn
+}
@@ -0,0 +1,101 @@
+package cloudymsgraph
+
+import (
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaFromMappings(t *testing.T) {
+	schema := SchemaFromMappings(testCustomSecurityAttributes)
+
+	assert.Len(t, schema.AttributeSets, 2)
+	assert.Equal(t, "program", schema.AttributeSets[0].Name)
+	assert.Equal(t, "security", schema.AttributeSets[1].Name)
+
+	assert.Len(t, schema.Attributes, len(testCustomSecurityAttributes))
+	assert.Equal(t, "program_Projects", schema.Attributes[1].ID())
+	assert.True(t, schema.Attributes[1].MultiValued)
+	assert.Equal(t, CustomSecurityAttributeInteger, schema.Attributes[2].Type)
+
+	def := AttributeDefinitionToAzure(schema.Attributes[1])
+	assert.Equal(t, "program", *def.GetAttributeSet())
+	assert.Equal(t, "Projects", *def.GetName())
+	assert.Equal(t, "String", *def.GetTypeEscaped())
+	assert.True(t, *def.GetIsCollection())
+	assert.Equal(t, AttributeStatusAvailable, *def.GetStatus())
+	assert.False(t, *def.GetIsSearchable())
+
+	back := AttributeDefinitionToCloudy(def)
+	assert.False(t, *back.Searchable)
+	back.Searchable = nil
+	back.UsePredefinedValuesOnly = nil
+	assert.Equal(t, schema.Attributes[1], back)
+}
+
+func TestAttributeDefinitionDiff(t *testing.T) {
+	current := &AttributeDefinition{
+		AttributeSet:            "program",
+		Name:                    "Project",
+		Type:                    CustomSecurityAttributeString,
+		Searchable:              cloudy.BoolP(true),
+		UsePredefinedValuesOnly: cloudy.BoolP(true),
+		AllowedValues:           []string{"Baker", "Cascade"},
+		Status:                  AttributeStatusAvailable,
+		inactiveValues:          []string{"Delta"},
+	}
+
+	same := *current
+	same.inactiveValues = nil
+	diff, err := diffAttributeDefinition(current, &same)
+	assert.Nil(t, err)
+	assert.False(t, diff.changed())
+
+	desired := same
+	desired.Description = "Programs the user works on"
+	desired.AllowedValues = []string{"Baker", "Delta", "Echo"}
+	diff, err = diffAttributeDefinition(current, &desired)
+	assert.Nil(t, err)
+	assert.True(t, diff.changed())
+	assert.Equal(t, "Programs the user works on", *diff.patch.GetDescription())
+	assert.Nil(t, diff.patch.GetStatus())
+	assert.Equal(t, []string{"Echo"}, diff.addValues)
+	assert.Equal(t, map[string]bool{"Delta": true, "Cascade": false}, diff.activateValues)
+
+	// nil allowed values and flags leave the definition alone
+	unspecified := same
+	unspecified.AllowedValues = nil
+	unspecified.Searchable = nil
+	unspecified.UsePredefinedValuesOnly = nil
+	diff, err = diffAttributeDefinition(current, &unspecified)
+	assert.Nil(t, err)
+	assert.False(t, diff.changed())
+
+	// predefined values only can be turned off but not on
+	loosened := same
+	loosened.UsePredefinedValuesOnly = cloudy.BoolP(false)
+	diff, err = diffAttributeDefinition(current, &loosened)
+	assert.Nil(t, err)
+	assert.False(t, *diff.patch.GetUsePreDefinedValuesOnly())
+
+	freeForm := *current
+	freeForm.UsePredefinedValuesOnly = cloudy.BoolP(false)
+	_, err = diffAttributeDefinition(&freeForm, &same)
+	assert.NotNil(t, err)
+
+	immutable := same
+	immutable.Type = CustomSecurityAttributeInteger
+	_, err = diffAttributeDefinition(current, &immutable)
+	assert.NotNil(t, err)
+
+	immutable = same
+	immutable.MultiValued = true
+	_, err = diffAttributeDefinition(current, &immutable)
+	assert.NotNil(t, err)
+
+	immutable = same
+	immutable.Searchable = cloudy.BoolP(false)
+	_, err = diffAttributeDefinition(current, &immutable)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,109 @@
+package cloudymsgraph
+
+import (
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCustomSecurityAttributes = CustomSecurityAttributeMappings{
+	{Field: "ContractNumber", AttributeSet: "program", Attribute: "Contract", Type: CustomSecurityAttributeString},
+	{Field: "Project", AttributeSet: "program", Attribute: "Projects", Type: CustomSecurityAttributeString, MultiValued: true},
+	{Name: "Clearance", AttributeSet: "program", Attribute: "ClearanceLevel", Type: CustomSecurityAttributeInteger},
+	{AttributeSet: "program", Attribute: "Sites", Type: CustomSecurityAttributeInteger, MultiValued: true},
+	{AttributeSet: "security", Attribute: "Training", Type: CustomSecurityAttributeBoolean},
+}
+
+func TestCustomSecurityAttributesToAzure(t *testing.T) {
+	user := &cloudymodels.User{
+		ContractNumber: "FA8750-20-C-0001",
+		Project:        "Baker, Cascade",
+	}
+
+	csa := testCustomSecurityAttributes.ToAzure(user, map[string]interface{}{
+		"Clearance": 3,
+		"Sites":     []int{10, 20},
+		"Training":  true,
+	})
+	assert.NotNil(t, csa)
+
+	program := csa.GetAdditionalData()["program"].(map[string]interface{})
+	assert.Equal(t, customSecurityAttributeValueOdataType, *program["@odata.type"].(*string))
+	assert.Equal(t, "FA8750-20-C-0001", *program["Contract"].(*string))
+	assert.Equal(t, []string{"Baker", "Cascade"}, program["Projects"])
+	assert.Equal(t, "#Collection(String)", *program["Projects@odata.type"].(*string))
+	assert.Equal(t, int32(3), *program["ClearanceLevel"].(*int32))
+	assert.Equal(t, "#Int32", *program["ClearanceLevel@odata.type"].(*string))
+	assert.Equal(t, []int32{10, 20}, program["Sites"])
+	assert.Equal(t, "#Collection(Int32)", *program["Sites@odata.type"].(*string))
+
+	security := csa.GetAdditionalData()["security"].(map[string]interface{})
+	assert.True(t, *security["Training"].(*bool))
+	_, annotated := security["Training@odata.type"]
+	assert.False(t, annotated)
+
+	assert.Nil(t, testCustomSecurityAttributes.ToAzure(&cloudymodels.User{}, nil))
+}
+
+func TestCustomSecurityAttributesToCloudy(t *testing.T) {
+	// Values as they are deserialized from a Graph response
+	level := int64(4)
+	training := false
+	csa := models.NewCustomSecurityAttributeValue()
+	csa.GetAdditionalData()["program"] = map[string]interface{}{
+		"@odata.type":    cloudy.StringP("#microsoft.graph.customSecurityAttributeValue"),
+		"Contract":       cloudy.StringP("FA8750-20-C-0001"),
+		"Projects":       []interface{}{cloudy.StringP("Baker"), cloudy.StringP("Cascade")},
+		"ClearanceLevel": &level,
+		"Sites":          []interface{}{&level},
+	}
+	csa.GetAdditionalData()["security"] = map[string]interface{}{
+		"Training": &training,
+	}
+
+	azUser := models.NewUser()
+	azUser.SetCustomSecurityAttributes(csa)
+
+	user, attributes := UserToCloudyWithAttributes(azUser, testCustomSecurityAttributes)
+	assert.Equal(t, "FA8750-20-C-0001", user.ContractNumber)
+	assert.Equal(t, "Baker,Cascade", user.Project)
+	assert.Equal(t, map[string]interface{}{
+		"Clearance": 4,
+		"Sites":     []int{4},
+		"Training":  false,
+	}, attributes)
+
+	// Mismatched types are skipped instead of panicking
+	csa.GetAdditionalData()["program"] = map[string]interface{}{
+		"Contract": &level,
+	}
+	user, _ = UserToCloudyWithAttributes(azUser, testCustomSecurityAttributes)
+	assert.Equal(t, "", user.ContractNumber)
+}
+
+func TestDefaultCustomSecurityAttributes(t *testing.T) {
+	user := &cloudymodels.User{
+		AccountType:    "DOD Contractor",
+		Citizenship:    "USA",
+		ContractDate:   "2030-01-01",
+		ContractNumber: "1234",
+		Organization:   "AFRL",
+		Project:        "Collider",
+		ProgramRole:    "Developer",
+	}
+
+	azUser := UserToAzureWithAttributes(user, map[string]interface{}{"Sponsor": "someone"}, DefaultCustomSecurityAttributes)
+	cloudyUser, attributes := UserToCloudyWithAttributes(azUser, DefaultCustomSecurityAttributes)
+
+	assert.Equal(t, user.AccountType, cloudyUser.AccountType)
+	assert.Equal(t, user.Citizenship, cloudyUser.Citizenship)
+	assert.Equal(t, user.ContractDate, cloudyUser.ContractDate)
+	assert.Equal(t, user.ContractNumber, cloudyUser.ContractNumber)
+	assert.Equal(t, user.Organization, cloudyUser.Organization)
+	assert.Equal(t, user.Project, cloudyUser.Project)
+	assert.Equal(t, user.ProgramRole, cloudyUser.ProgramRole)
+	assert.Equal(t, map[string]interface{}{"Sponsor": "someone"}, attributes)
+}
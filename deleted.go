@@ -0,0 +1,362 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/directory"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// DeletedItemRetention is how long Graph keeps deleted users and groups before they are
+// permanently deleted
+const DeletedItemRetention = 30 * 24 * time.Hour
+
+// ErrRestoreConflict is returned when a deleted user cannot be restored because their
+// user principal name is used by another user
+var ErrRestoreConflict = errors.New("user principal name is in use")
+
+// RestoreConflictError describes the user that holds the user principal name of the
+// user being restored
+type RestoreConflictError struct {
+	ID             string
+	UPN            string
+	ConflictingID  string
+	ConflictingUPN string
+}
+
+func (e *RestoreConflictError) Error() string {
+	return fmt.Sprintf("[%s] cannot restore %s: %v by %s", e.ID, e.UPN, ErrRestoreConflict, e.ConflictingID)
+}
+
+func (e *RestoreConflictError) Unwrap() error {
+	return ErrRestoreConflict
+}
+
+// DeletedUser is a user in the deleted items. The UPN of the user is the one they had
+// before they were deleted.
+type DeletedUser struct {
+	User    *cloudymodels.User
+	Deleted time.Time
+}
+
+// PermanentDeletion returns when Graph permanently deletes the user
+func (d *DeletedUser) PermanentDeletion() time.Time {
+	return d.Deleted.Add(DeletedItemRetention)
+}
+
+// DeletedGroup is a group in the deleted items
+type DeletedGroup struct {
+	Group   *cloudymodels.Group
+	Deleted time.Time
+}
+
+// PermanentDeletion returns when Graph permanently deletes the group
+func (d *DeletedGroup) PermanentDeletion() time.Time {
+	return d.Deleted.Add(DeletedItemRetention)
+}
+
+// RestoreUserOptions controls how a deleted user is restored
+type RestoreUserOptions struct {
+	// NewUserPrincipalName restores the user with a different user principal name,
+	// used when their user principal name is taken by another user
+	NewUserPrincipalName string
+
+	// AutoReconcileProxyConflict removes the proxy addresses of the restored user that
+	// are used by another object instead of failing the restore
+	AutoReconcileProxyConflict bool
+}
+
+// ListDeletedUsers returns the users in the deleted items, reading every page
+func (um *MsGraphUserManager) ListDeletedUsers(ctx context.Context) ([]*DeletedUser, error) {
+	cloudy.Info(ctx, "ListDeletedUsers")
+
+	result, err := um.Client.Directory().DeletedItems().GraphUser().Get(ctx,
+		&directory.DeletedItemsGraphUserRequestBuilderGetRequestConfiguration{
+			QueryParameters: &directory.DeletedItemsGraphUserRequestBuilderGetQueryParameters{
+				Select: um.userSelectFields(ctx, nil, "deletedDateTime"),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListDeletedUsers Error: %s", message)
+	}
+
+	rtn := []*DeletedUser{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, um.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
+		rtn = append(rtn, um.deletedUserToCloudy(pageItem))
+		return true
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListDeletedUsers Error: %s", message)
+	}
+
+	return rtn, nil
+}
+
+// GetDeletedUser returns the user from the deleted items, nil when it is not there
+func (um *MsGraphUserManager) GetDeletedUser(ctx context.Context, uid string) (*DeletedUser, error) {
+	cloudy.Info(ctx, "[%s] GetDeletedUser", uid)
+
+	result, err := um.Client.Directory().DeletedItems().ByDirectoryObjectId(uid).GraphUser().Get(ctx,
+		&directory.DeletedItemsItemGraphUserRequestBuilderGetRequestConfiguration{
+			QueryParameters: &directory.DeletedItemsItemGraphUserRequestBuilderGetQueryParameters{
+				Select: um.userSelectFields(ctx, nil, "deletedDateTime"),
+			},
+		})
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Info(ctx, "[%s] GetDeletedUser - ResourceNotFound - %s", uid, message)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetDeletedUser Error: %s", uid, message)
+	}
+
+	return um.deletedUserToCloudy(result), nil
+}
+
+// RestoreUser restores the deleted user. When the user principal name of the user is
+// used by another user a *RestoreConflictError is returned, unless a new user principal
+// name is given in the options.
+func (um *MsGraphUserManager) RestoreUser(ctx context.Context, uid string, opts *RestoreUserOptions) (*cloudymodels.User, error) {
+	cloudy.Info(ctx, "[%s] RestoreUser", uid)
+
+	if opts == nil {
+		opts = &RestoreUserOptions{}
+	}
+
+	deleted, err := um.GetDeletedUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, cloudy.Error(ctx, "[%s] RestoreUser - deleted user not found", uid)
+	}
+
+	if opts.NewUserPrincipalName == "" {
+		conflict, err := um.restoreConflict(ctx, uid, deleted.User.UPN)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			cloudy.Warn(ctx, "%v", conflict)
+			return nil, conflict
+		}
+	}
+
+	body := map[string]interface{}{}
+	if opts.NewUserPrincipalName != "" {
+		body["newUserPrincipalName"] = opts.NewUserPrincipalName
+	}
+	if opts.AutoReconcileProxyConflict {
+		body["autoReconcileProxyConflict"] = true
+	}
+
+	result, err := um.restoreDeletedItem(ctx, uid, body)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] RestoreUser Error: %s", uid, message)
+	}
+
+	user, ok := result.(models.Userable)
+	if !ok {
+		return um.GetUser(ctx, uid)
+	}
+	return um.userToCloudy(user), nil
+}
+
+// PermanentlyDeleteUser removes the deleted user from the deleted items, it can no longer
+// be restored
+func (um *MsGraphUserManager) PermanentlyDeleteUser(ctx context.Context, uid string) error {
+	cloudy.Info(ctx, "[%s] PermanentlyDeleteUser", uid)
+
+	err := um.Client.Directory().DeletedItems().ByDirectoryObjectId(uid).Delete(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] PermanentlyDeleteUser Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// restoreConflict returns the conflict when the user principal name is used by a user
+func (um *MsGraphUserManager) restoreConflict(ctx context.Context, uid string, upn string) (*RestoreConflictError, error) {
+	if upn == "" {
+		return nil, nil
+	}
+
+	existing, err := um.Client.Users().ByUserId(upn).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+				Select: []string{"id", "userPrincipalName"},
+			},
+		})
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] RestoreUser - unable to check %s: %s", uid, upn, message)
+	}
+
+	conflict := &RestoreConflictError{
+		ID:  uid,
+		UPN: upn,
+	}
+	if existing.GetId() != nil {
+		conflict.ConflictingID = *existing.GetId()
+	}
+	if existing.GetUserPrincipalName() != nil {
+		conflict.ConflictingUPN = *existing.GetUserPrincipalName()
+	}
+	return conflict, nil
+}
+
+func (um *MsGraphUserManager) deletedUserToCloudy(user models.Userable) *DeletedUser {
+	u := um.userToCloudy(user)
+	u.UPN = deletedUserPrincipalName(u.ID, u.UPN)
+
+	rtn := &DeletedUser{
+		User: u,
+	}
+	if user.GetDeletedDateTime() != nil {
+		rtn.Deleted = *user.GetDeletedDateTime()
+	}
+	return rtn
+}
+
+// ListDeletedGroups returns the groups in the deleted items, reading every page
+func (gm *MsGraphGroupManager) ListDeletedGroups(ctx context.Context) ([]*DeletedGroup, error) {
+	cloudy.Info(ctx, "ListDeletedGroups")
+
+	result, err := gm.Client.Directory().DeletedItems().GraphGroup().Get(ctx,
+		&directory.DeletedItemsGraphGroupRequestBuilderGetRequestConfiguration{
+			QueryParameters: &directory.DeletedItemsGraphGroupRequestBuilderGetQueryParameters{
+				Select: []string{"id", "displayName", "deletedDateTime"},
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListDeletedGroups Error: %s", message)
+	}
+
+	rtn := []*DeletedGroup{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.Groupable](result, gm.Adapter, models.CreateGroupCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(pageItem models.Groupable) bool {
+		rtn = append(rtn, DeletedGroupToCloudy(pageItem))
+		return true
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListDeletedGroups Error: %s", message)
+	}
+
+	return rtn, nil
+}
+
+// RestoreGroup restores the deleted group. Only Microsoft 365 groups can be restored,
+// deleted security groups are gone for good.
+func (gm *MsGraphGroupManager) RestoreGroup(ctx context.Context, groupId string) (*cloudymodels.Group, error) {
+	cloudy.Info(ctx, "[%s] RestoreGroup", groupId)
+
+	result, err := gm.restoreDeletedItem(ctx, groupId, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] RestoreGroup Error: %s", groupId, message)
+	}
+
+	group, ok := result.(models.Groupable)
+	if !ok || group.GetId() == nil || group.GetDisplayName() == nil {
+		return &cloudymodels.Group{ID: groupId}, nil
+	}
+	return GroupToCloudy(group), nil
+}
+
+// PermanentlyDeleteGroup removes the deleted group from the deleted items, it can no
+// longer be restored
+func (gm *MsGraphGroupManager) PermanentlyDeleteGroup(ctx context.Context, groupId string) error {
+	cloudy.Info(ctx, "[%s] PermanentlyDeleteGroup", groupId)
+
+	err := gm.Client.Directory().DeletedItems().ByDirectoryObjectId(groupId).Delete(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] PermanentlyDeleteGroup Error: %s", groupId, message)
+	}
+
+	return nil
+}
+
+// DeletedGroupToCloudy converts a group from the deleted items
+func DeletedGroupToCloudy(g models.Groupable) *DeletedGroup {
+	rtn := &DeletedGroup{
+		Group: &cloudymodels.Group{},
+	}
+	if g.GetId() != nil {
+		rtn.Group.ID = *g.GetId()
+	}
+	if g.GetDisplayName() != nil {
+		rtn.Group.Name = *g.GetDisplayName()
+	}
+	if g.GetDeletedDateTime() != nil {
+		rtn.Deleted = *g.GetDeletedDateTime()
+	}
+	return rtn
+}
+
+// restoreDeletedItem restores the item, sending the body when it has values. The SDK
+// restore does not take a body.
+func (graph *MsGraph) restoreDeletedItem(ctx context.Context, id string, body map[string]interface{}) (models.DirectoryObjectable, error) {
+	builder := graph.Client.Directory().DeletedItems().ByDirectoryObjectId(id).Restore()
+	if len(body) == 0 {
+		return builder.Post(ctx, nil)
+	}
+
+	requestInfo, err := builder.ToPostRequestInformation(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	requestInfo.SetStreamContentAndContentType(content, "application/json")
+
+	result, err := graph.Adapter.Send(ctx, requestInfo, models.CreateDirectoryObjectFromDiscriminatorValue, odataErrorMapping)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return result.(models.DirectoryObjectable), nil
+}
+
+// deletedUserPrincipalName removes the object id that Graph puts in front of the user
+// principal name of a deleted user, e.g. 0f8b...c2dajohn.doe@example.com
+func deletedUserPrincipalName(id string, upn string) string {
+	prefix := strings.ReplaceAll(id, "-", "")
+	if prefix != "" && len(upn) > len(prefix) && strings.EqualFold(upn[:len(prefix)], prefix) {
+		return upn[len(prefix):]
+	}
+	return upn
+}
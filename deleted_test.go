@@ -0,0 +1,42 @@
+package cloudymsgraph
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedUserPrincipalName(t *testing.T) {
+	id := "0f8b6a6e-1c2d-4e5f-9a8b-7c6d5e4f3a2b"
+	assert.Equal(t, "john.doe@example.com", deletedUserPrincipalName(id, "0f8b6a6e1c2d4e5f9a8b7c6d5e4f3a2bjohn.doe@example.com"))
+	assert.Equal(t, "john.doe@example.com", deletedUserPrincipalName(id, "0F8B6A6E1C2D4E5F9A8B7C6D5E4F3A2Bjohn.doe@example.com"))
+	assert.Equal(t, "john.doe@example.com", deletedUserPrincipalName(id, "john.doe@example.com"))
+	assert.Equal(t, "john.doe@example.com", deletedUserPrincipalName("", "john.doe@example.com"))
+}
+
+func TestDeletedGroupToCloudy(t *testing.T) {
+	id, name := "g", "Group"
+	deleted := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	g := models.NewGroup()
+	g.SetId(&id)
+	g.SetDisplayName(&name)
+	g.SetDeletedDateTime(&deleted)
+
+	d := DeletedGroupToCloudy(g)
+	assert.Equal(t, "g", d.Group.ID)
+	assert.Equal(t, "Group", d.Group.Name)
+	assert.Equal(t, deleted, d.Deleted)
+	assert.Equal(t, time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC), d.PermanentDeletion())
+}
+
+func TestRestoreConflictError(t *testing.T) {
+	var err error = &RestoreConflictError{ID: "i", UPN: "john.doe@example.com", ConflictingID: "c"}
+	assert.True(t, errors.Is(err, ErrRestoreConflict))
+
+	var conflict *RestoreConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "c", conflict.ConflictingID)
+}
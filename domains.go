@@ -0,0 +1,354 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// DefaultDomainCacheTTL is how long the tenant domains are cached
+const DefaultDomainCacheTTL = 15 * time.Minute
+
+// ErrUnknownDomain is returned when a domain is not a domain of the tenant
+var ErrUnknownDomain = errors.New("domain is not a domain of the tenant")
+
+// ErrUnverifiedDomain is returned when a domain of the tenant is not verified
+var ErrUnverifiedDomain = errors.New("domain is not a verified domain of the tenant")
+
+// DomainError is returned when a user property uses a domain that is unknown or not
+// verified. It unwraps to ErrUnknownDomain or ErrUnverifiedDomain.
+type DomainError struct {
+	Property string
+	Value    string
+	Domain   string
+	Err      error
+}
+
+func (e *DomainError) Error() string {
+	return fmt.Sprintf("%s %s: %s %v", e.Property, e.Value, e.Domain, e.Err)
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.Err
+}
+
+// Domain is a domain of the tenant
+type Domain struct {
+	Name               string
+	IsVerified         bool
+	IsDefault          bool
+	IsInitial          bool
+	IsRoot             bool
+	AuthenticationType string
+	SupportedServices  []string
+}
+
+// DomainDnsRecord is a DNS record to create for a domain. Text is set for TXT records,
+// MailExchange and Preference for MX records and CanonicalName for CNAME records.
+type DomainDnsRecord struct {
+	RecordType       string
+	Label            string
+	TTL              int
+	Text             string
+	MailExchange     string
+	Preference       int
+	CanonicalName    string
+	SupportedService string
+	IsOptional       bool
+}
+
+type MsGraphDomainManager struct {
+	*MsGraph
+
+	cache *domainCache
+}
+
+func NewMsGraphDomainManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphDomainManager, error) {
+	dm := &MsGraphDomainManager{
+		MsGraph: &MsGraph{},
+		cache:   &domainCache{},
+	}
+	err := dm.Configure(cfg)
+
+	return dm, err
+}
+
+// domainManager returns a domain manager that shares the client and domain cache of
+// the user manager
+func (um *MsGraphUserManager) domainManager() *MsGraphDomainManager {
+	return &MsGraphDomainManager{
+		MsGraph: um.MsGraph,
+		cache:   &um.domains,
+	}
+}
+
+// ListDomains returns the domains of the tenant. The domains are cached for the
+// DomainCacheTTL.
+func (dm *MsGraphDomainManager) ListDomains(ctx context.Context) ([]*Domain, error) {
+	if domains, ok := dm.cache.get(); ok {
+		return domains, nil
+	}
+
+	cloudy.Info(ctx, "ListDomains")
+	result, err := dm.Client.Domains().Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListDomains Error: %s", message)
+	}
+
+	rtn := []*Domain{}
+	for _, d := range result.GetValue() {
+		rtn = append(rtn, DomainToCloudy(d))
+	}
+
+	dm.cache.put(rtn, dm.domainCacheTTL())
+	return rtn, nil
+}
+
+// GetDomain returns the domain of the tenant, nil when the tenant does not have it
+func (dm *MsGraphDomainManager) GetDomain(ctx context.Context, name string) (*Domain, error) {
+	domains, err := dm.ListDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findDomain(domains, name), nil
+}
+
+// DefaultDomain returns the default domain of the tenant
+func (dm *MsGraphDomainManager) DefaultDomain(ctx context.Context) (*Domain, error) {
+	domains, err := dm.ListDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range domains {
+		if d.IsDefault {
+			return d, nil
+		}
+	}
+	return nil, cloudy.Error(ctx, "DefaultDomain - the tenant has no default domain")
+}
+
+// AddDomain adds the domain to the tenant. It has to be verified, with the records from
+// GetVerificationDnsRecords, before users can use it.
+func (dm *MsGraphDomainManager) AddDomain(ctx context.Context, name string) (*Domain, error) {
+	cloudy.Info(ctx, "[%s] AddDomain", name)
+
+	body := models.NewDomain()
+	body.SetId(&name)
+
+	result, err := dm.Client.Domains().Post(ctx, body, nil)
+	dm.cache.invalidate()
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] AddDomain Error: %s", name, message)
+	}
+
+	return DomainToCloudy(result), nil
+}
+
+// VerifyDomain asks Graph to check the verification records of the domain
+func (dm *MsGraphDomainManager) VerifyDomain(ctx context.Context, name string) (*Domain, error) {
+	cloudy.Info(ctx, "[%s] VerifyDomain", name)
+
+	result, err := dm.Client.Domains().ByDomainId(name).Verify().Post(ctx, nil)
+	dm.cache.invalidate()
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] VerifyDomain Error: %s", name, message)
+	}
+
+	return DomainToCloudy(result), nil
+}
+
+// GetVerificationDnsRecords returns the DNS records that prove the ownership of the domain
+func (dm *MsGraphDomainManager) GetVerificationDnsRecords(ctx context.Context, name string) ([]*DomainDnsRecord, error) {
+	cloudy.Info(ctx, "[%s] GetVerificationDnsRecords", name)
+
+	result, err := dm.Client.Domains().ByDomainId(name).VerificationDnsRecords().Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] GetVerificationDnsRecords Error: %s", name, message)
+	}
+
+	rtn := []*DomainDnsRecord{}
+	for _, r := range result.GetValue() {
+		rtn = append(rtn, DomainDnsRecordToCloudy(r))
+	}
+	return rtn, nil
+}
+
+// ValidateUserDomains checks the properties of the user, userPrincipalName and mail when
+// none are given, use verified domains of the tenant. Returns a *DomainError when they do not.
+func (dm *MsGraphDomainManager) ValidateUserDomains(ctx context.Context, user *cloudymodels.User, properties ...string) error {
+	domains, err := dm.ListDomains(ctx)
+	if err != nil {
+		return err
+	}
+
+	domainErr := validateUserDomains(domains, user, properties...)
+	if domainErr != nil {
+		cloudy.Warn(ctx, "[%s] ValidateUserDomains %v", user.ID, domainErr)
+		return domainErr
+	}
+	return nil
+}
+
+func (dm *MsGraphDomainManager) domainCacheTTL() time.Duration {
+	if dm.Cfg == nil || dm.Cfg.DomainCacheTTL == 0 {
+		return DefaultDomainCacheTTL
+	}
+	return dm.Cfg.DomainCacheTTL
+}
+
+// validateUserDomains returns the first of the user properties, userPrincipalName and mail
+// when none are given, that does not use a verified domain
+func validateUserDomains(domains []*Domain, user *cloudymodels.User, only ...string) *DomainError {
+	properties := []struct {
+		name  string
+		value string
+	}{
+		{"userPrincipalName", user.UPN},
+		{"mail", user.Email},
+	}
+
+	for _, p := range properties {
+		if p.value == "" || (len(only) > 0 && !containsFold(only, p.name)) {
+			continue
+		}
+
+		name := emailDomain(p.value)
+		d := findDomain(domains, name)
+		if d == nil {
+			return &DomainError{Property: p.name, Value: p.value, Domain: name, Err: ErrUnknownDomain}
+		}
+		if !d.IsVerified {
+			return &DomainError{Property: p.name, Value: p.value, Domain: name, Err: ErrUnverifiedDomain}
+		}
+	}
+
+	return nil
+}
+
+func emailDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(address[at+1:])
+}
+
+func findDomain(domains []*Domain, name string) *Domain {
+	for _, d := range domains {
+		if strings.EqualFold(d.Name, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+func DomainToCloudy(d models.Domainable) *Domain {
+	rtn := &Domain{
+		SupportedServices: d.GetSupportedServices(),
+	}
+	if d.GetId() != nil {
+		rtn.Name = *d.GetId()
+	}
+	if d.GetIsVerified() != nil {
+		rtn.IsVerified = *d.GetIsVerified()
+	}
+	if d.GetIsDefault() != nil {
+		rtn.IsDefault = *d.GetIsDefault()
+	}
+	if d.GetIsInitial() != nil {
+		rtn.IsInitial = *d.GetIsInitial()
+	}
+	if d.GetIsRoot() != nil {
+		rtn.IsRoot = *d.GetIsRoot()
+	}
+	if d.GetAuthenticationType() != nil {
+		rtn.AuthenticationType = *d.GetAuthenticationType()
+	}
+	return rtn
+}
+
+func DomainDnsRecordToCloudy(r models.DomainDnsRecordable) *DomainDnsRecord {
+	rtn := &DomainDnsRecord{}
+	if r.GetRecordType() != nil {
+		rtn.RecordType = *r.GetRecordType()
+	}
+	if r.GetLabel() != nil {
+		rtn.Label = *r.GetLabel()
+	}
+	if r.GetTtl() != nil {
+		rtn.TTL = int(*r.GetTtl())
+	}
+	if r.GetSupportedService() != nil {
+		rtn.SupportedService = *r.GetSupportedService()
+	}
+	if r.GetIsOptional() != nil {
+		rtn.IsOptional = *r.GetIsOptional()
+	}
+
+	switch record := r.(type) {
+	case models.DomainDnsTxtRecordable:
+		if record.GetText() != nil {
+			rtn.Text = *record.GetText()
+		}
+	case models.DomainDnsMxRecordable:
+		if record.GetMailExchange() != nil {
+			rtn.MailExchange = *record.GetMailExchange()
+		}
+		if record.GetPreference() != nil {
+			rtn.Preference = int(*record.GetPreference())
+		}
+	case models.DomainDnsCnameRecordable:
+		if record.GetCanonicalName() != nil {
+			rtn.CanonicalName = *record.GetCanonicalName()
+		}
+	}
+
+	return rtn
+}
+
+// domainCache holds the domains of the tenant. The zero value is ready to use.
+type domainCache struct {
+	mu      sync.Mutex
+	domains []*Domain
+	expires time.Time
+}
+
+func (c *domainCache) get() ([]*Domain, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.domains == nil || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return c.domains, true
+}
+
+func (c *domainCache) put(domains []*Domain, ttl time.Duration) {
+	if ttl < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.domains = domains
+	c.expires = time.Now().Add(ttl)
+}
+
+func (c *domainCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.domains = nil
+}
@@ -0,0 +1,107 @@
+package cloudymsgraph
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUserDomains(t *testing.T) {
+	domains := []*Domain{
+		{Name: "example.com", IsVerified: true, IsDefault: true},
+		{Name: "pending.com"},
+	}
+
+	assert.Nil(t, validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@Example.com", Email: "john.doe@example.com"}))
+	assert.Nil(t, validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@example.com"}))
+
+	err := validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@other.com"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "userPrincipalName", err.Property)
+	assert.Equal(t, "other.com", err.Domain)
+	assert.True(t, errors.Is(err, ErrUnknownDomain))
+
+	err = validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@example.com", Email: "john.doe@pending.com"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "mail", err.Property)
+	assert.True(t, errors.Is(err, ErrUnverifiedDomain))
+
+	err = validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe"})
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrUnknownDomain))
+
+	// only the given properties are checked
+	assert.Nil(t, validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@example.com", Email: "john.doe@gmail.com"}, "userPrincipalName"))
+	err = validateUserDomains(domains, &cloudymodels.User{UPN: "john.doe@example.com", Email: "john.doe@gmail.com"}, "mail")
+	assert.NotNil(t, err)
+	assert.Equal(t, "mail", err.Property)
+
+	assert.True(t, isGuestUserPrincipalName("jane_contoso.com#EXT#@example.com"))
+	assert.False(t, isGuestUserPrincipalName("jane@example.com"))
+}
+
+func TestDomainToCloudy(t *testing.T) {
+	id, auth := "example.com", "Managed"
+	d := models.NewDomain()
+	d.SetId(&id)
+	d.SetIsVerified(&[]bool{true}[0])
+	d.SetIsDefault(&[]bool{true}[0])
+	d.SetAuthenticationType(&auth)
+	d.SetSupportedServices([]string{"Email"})
+
+	domain := DomainToCloudy(d)
+	assert.Equal(t, "example.com", domain.Name)
+	assert.True(t, domain.IsVerified)
+	assert.True(t, domain.IsDefault)
+	assert.False(t, domain.IsInitial)
+	assert.Equal(t, "Managed", domain.AuthenticationType)
+	assert.Equal(t, []string{"Email"}, domain.SupportedServices)
+}
+
+func TestDomainDnsRecordToCloudy(t *testing.T) {
+	recordType, label, text := "Txt", "example.com", "MS=ms12345678"
+	ttl := int32(3600)
+	txt := models.NewDomainDnsTxtRecord()
+	txt.SetRecordType(&recordType)
+	txt.SetLabel(&label)
+	txt.SetTtl(&ttl)
+	txt.SetText(&text)
+
+	record := DomainDnsRecordToCloudy(txt)
+	assert.Equal(t, "Txt", record.RecordType)
+	assert.Equal(t, "example.com", record.Label)
+	assert.Equal(t, 3600, record.TTL)
+	assert.Equal(t, "MS=ms12345678", record.Text)
+
+	exchange, preference := "example-com.mail.protection.outlook.com", int32(32767)
+	mx := models.NewDomainDnsMxRecord()
+	mx.SetMailExchange(&exchange)
+	mx.SetPreference(&preference)
+
+	record = DomainDnsRecordToCloudy(mx)
+	assert.Equal(t, exchange, record.MailExchange)
+	assert.Equal(t, 32767, record.Preference)
+}
+
+func TestDomainCache(t *testing.T) {
+	c := &domainCache{}
+	_, ok := c.get()
+	assert.False(t, ok)
+
+	c.put([]*Domain{{Name: "example.com"}}, time.Minute)
+	domains, ok := c.get()
+	assert.True(t, ok)
+	assert.Len(t, domains, 1)
+
+	c.invalidate()
+	_, ok = c.get()
+	assert.False(t, ok)
+
+	c.put([]*Domain{{Name: "example.com"}}, -1)
+	_, ok = c.get()
+	assert.False(t, ok)
+}
@@ -0,0 +1,736 @@
+package cloudymsgraph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/storage"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/drives"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func init() {
+	storage.ObjectStorageProviders.Register(MsGraphName, &MsGraphDriveStorageManagerFactory{})
+}
+
+// DriveSimpleUploadBytes is the largest file uploaded in a single request, larger files are
+// uploaded with an upload session
+const DriveSimpleUploadBytes = 4 * 1024 * 1024
+
+// driveChunkBytes is the size of the upload session chunks, a multiple of 320 KiB
+const driveChunkBytes = 32 * 320 * 1024
+
+// driveUploadAttempts is the number of times a chunk is sent before the upload fails
+const driveUploadAttempts = 3
+
+// driveItemNotFoundCode is the error code of a missing drive item
+const driveItemNotFoundCode = "itemNotFound"
+
+// driveNameAlreadyExistsCode is the error code of a drive item that already exists
+const driveNameAlreadyExistsCode = "nameAlreadyExists"
+
+var ErrNoDrive = errors.New("no DriveID or DriveSiteID configured")
+var ErrDriveMetadataUnsupported = errors.New("the drive does not support metadata, only SharePoint document libraries do")
+
+// DriveItemMetadata describes a file or folder of a drive. Fields are the SharePoint
+// document library columns of the item.
+type DriveItemMetadata struct {
+	ID          string
+	Name        string
+	Key         string
+	Size        int64
+	IsFolder    bool
+	ContentType string
+	WebURL      string
+	ETag        string
+	Created     time.Time
+	Modified    time.Time
+	Fields      map[string]interface{}
+}
+
+type MsGraphDriveStorageManagerFactory struct {
+	MsGraph
+}
+
+func (f *MsGraphDriveStorageManagerFactory) Create(cfg interface{}) (storage.ObjectStorageManager, error) {
+	return NewMsGraphDriveStorageManager(context.Background(), cfg.(*MsGraphConfig))
+}
+
+func (f *MsGraphDriveStorageManagerFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	cfg := fromEnvironment(env)
+	return cfg, nil
+}
+
+// MsGraphDriveStorageManager stores objects in a drive, a SharePoint document library or
+// OneDrive. The storage areas are the top level folders of the drive.
+type MsGraphDriveStorageManager struct {
+	*MsGraph
+
+	mu      sync.Mutex
+	driveId string
+}
+
+func NewMsGraphDriveStorageManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphDriveStorageManager, error) {
+	sm := &MsGraphDriveStorageManager{
+		MsGraph: &MsGraph{},
+	}
+	err := sm.Configure(cfg)
+
+	return sm, err
+}
+
+// Exists returns true when the top level folder exists
+func (sm *MsGraphDriveStorageManager) Exists(ctx context.Context, key string) (bool, error) {
+	item, err := sm.GetItem(ctx, key)
+	return item != nil, err
+}
+
+// List returns the top level folders of the drive
+func (sm *MsGraphDriveStorageManager) List(ctx context.Context) ([]*storage.StorageArea, error) {
+	rtn := []*storage.StorageArea{}
+	err := sm.listChildren(ctx, "", func(item models.DriveItemable) bool {
+		if item.GetFolder() != nil {
+			rtn = append(rtn, driveItemToStorageArea(item))
+		}
+		return true
+	})
+	return rtn, err
+}
+
+// GetItem returns the top level folder, nil when it does not exist
+func (sm *MsGraphDriveStorageManager) GetItem(ctx context.Context, key string) (*storage.StorageArea, error) {
+	item, err := sm.getDriveItem(ctx, drivePath(key), nil)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	if item.GetFolder() == nil {
+		return nil, nil
+	}
+	return driveItemToStorageArea(item), nil
+}
+
+// Get returns the storage of the top level folder
+func (sm *MsGraphDriveStorageManager) Get(ctx context.Context, key string) (storage.ObjectStorage, error) {
+	return &MsGraphDriveStorage{MsGraphDriveStorageManager: sm, folder: drivePath(key)}, nil
+}
+
+// Create creates the top level folder. Drive folders are never public, openToPublic and
+// the tags are ignored.
+func (sm *MsGraphDriveStorageManager) Create(ctx context.Context, key string, openToPublic bool, tags map[string]string) (storage.ObjectStorage, error) {
+	err := sm.createFolder(ctx, drivePath(key))
+	if err != nil {
+		return nil, err
+	}
+	return sm.Get(ctx, key)
+}
+
+// Delete deletes the top level folder and everything in it
+func (sm *MsGraphDriveStorageManager) Delete(ctx context.Context, key string) error {
+	return sm.deleteDriveItem(ctx, drivePath(key))
+}
+
+// drive returns the request builder of the configured drive, the default drive of the
+// site when only DriveSiteID is configured
+func (sm *MsGraphDriveStorageManager) drive(ctx context.Context) (*drives.DriveItemRequestBuilder, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.driveId == "" {
+		switch {
+		case sm.Cfg != nil && sm.Cfg.DriveID != "":
+			sm.driveId = sm.Cfg.DriveID
+		case sm.Cfg != nil && sm.Cfg.DriveSiteID != "":
+			drive, err := sm.Client.Sites().BySiteId(sm.Cfg.DriveSiteID).Drive().Get(ctx, nil)
+			if err != nil {
+				message := errorMessage(ctx, err)
+				return nil, cloudy.Error(ctx, "[%s] unable to get the site drive: %s", sm.Cfg.DriveSiteID, message)
+			}
+			sm.driveId = cloudy.StringFromP(drive.GetId())
+		default:
+			return nil, cloudy.Error(ctx, "%v", ErrNoDrive)
+		}
+	}
+
+	return sm.Client.Drives().ByDriveId(sm.driveId), nil
+}
+
+func (sm *MsGraphDriveStorageManager) item(ctx context.Context, itemPath string) (*drives.ItemItemsDriveItemItemRequestBuilder, error) {
+	drive, err := sm.drive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return drive.Items().ByDriveItemId(driveItemId(itemPath)), nil
+}
+
+// getDriveItem returns the item at the path, nil when it does not exist
+func (sm *MsGraphDriveStorageManager) getDriveItem(ctx context.Context, itemPath string, params *drives.ItemItemsDriveItemItemRequestBuilderGetQueryParameters) (models.DriveItemable, error) {
+	builder, err := sm.item(ctx, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := builder.Get(ctx, &drives.ItemItemsDriveItemItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: params,
+	})
+	if err != nil {
+		code, message := errorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, driveItemNotFoundCode) || strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Info(ctx, "[%s] GetDriveItem - not found - %s", itemPath, message)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetDriveItem Error: %s", itemPath, message)
+	}
+
+	return item, nil
+}
+
+func (sm *MsGraphDriveStorageManager) deleteDriveItem(ctx context.Context, itemPath string) error {
+	cloudy.Info(ctx, "[%s] DeleteDriveItem", itemPath)
+
+	builder, err := sm.item(ctx, itemPath)
+	if err != nil {
+		return err
+	}
+
+	err = builder.Delete(ctx, nil)
+	if err != nil {
+		code, message := errorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, driveItemNotFoundCode) {
+			return nil
+		}
+
+		return cloudy.Error(ctx, "[%s] DeleteDriveItem Error: %s", itemPath, message)
+	}
+
+	return nil
+}
+
+// listChildren calls fn with each child of the folder, reading every page
+func (sm *MsGraphDriveStorageManager) listChildren(ctx context.Context, folderPath string, fn func(models.DriveItemable) bool) error {
+	builder, err := sm.item(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+
+	result, err := builder.Children().Get(ctx, nil)
+	if err != nil {
+		code, message := errorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, driveItemNotFoundCode) {
+			return nil
+		}
+
+		return cloudy.Error(ctx, "[%s] ListChildren Error: %s", folderPath, message)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.DriveItemable](result, sm.Adapter, models.CreateDriveItemCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return err
+	}
+
+	err = pageIterator.Iterate(ctx, fn)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] ListChildren Error: %s", folderPath, message)
+	}
+
+	return nil
+}
+
+// createFolder creates the folder and its missing parents
+func (sm *MsGraphDriveStorageManager) createFolder(ctx context.Context, folderPath string) error {
+	cloudy.Info(ctx, "[%s] CreateFolder", folderPath)
+
+	parent := ""
+	for _, name := range strings.Split(folderPath, "/") {
+		if name == "" {
+			continue
+		}
+
+		builder, err := sm.item(ctx, parent)
+		if err != nil {
+			return err
+		}
+
+		folder := models.NewDriveItem()
+		folder.SetName(&name)
+		folder.SetFolder(models.NewFolder())
+		folder.SetAdditionalData(map[string]interface{}{
+			"@microsoft.graph.conflictBehavior": "fail",
+		})
+
+		_, err = builder.Children().Post(ctx, folder, nil)
+		if err != nil {
+			code, message := errorCodeAndMessage(ctx, err)
+			if !strings.EqualFold(code, driveNameAlreadyExistsCode) {
+				return cloudy.Error(ctx, "[%s] CreateFolder Error: %s", folderPath, message)
+			}
+		}
+
+		parent = path.Join(parent, name)
+	}
+
+	return nil
+}
+
+// MsGraphDriveStorage stores objects in a folder of a drive. Keys are paths relative to
+// the folder, e.g. reports/2024/summary.pdf
+type MsGraphDriveStorage struct {
+	*MsGraphDriveStorageManager
+
+	folder string
+}
+
+// Upload uploads the file, replacing it when it exists. Files larger than
+// DriveSimpleUploadBytes are streamed in chunks to an upload session, a chunk that fails
+// is resumed from where the session stopped. Only the first chunk is buffered to tell the
+// size of a reader with an unknown size. The tags are set as the document library columns
+// of the file.
+func (ds *MsGraphDriveStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	itemPath := ds.path(key)
+	cloudy.Info(ctx, "[%s] Upload", itemPath)
+
+	size := readerSize(data)
+	if size < 0 {
+		head, err := io.ReadAll(io.LimitReader(data, DriveSimpleUploadBytes+1))
+		if err != nil {
+			return cloudy.Error(ctx, "[%s] Upload Error: %v", itemPath, err)
+		}
+		if len(head) <= DriveSimpleUploadBytes {
+			size = int64(len(head))
+		}
+		data = io.MultiReader(bytes.NewReader(head), data)
+	}
+
+	var err error
+	if size >= 0 && size <= DriveSimpleUploadBytes {
+		err = ds.simpleUpload(ctx, itemPath, data)
+	} else {
+		err = ds.sessionUpload(ctx, itemPath, data)
+	}
+	if err != nil {
+		return cloudy.Error(ctx, "[%s] Upload Error: %s", itemPath, errorMessage(ctx, err))
+	}
+
+	if len(tags) > 0 {
+		return ds.UpdateMetadata(ctx, key, tags)
+	}
+	return nil
+}
+
+func (ds *MsGraphDriveStorage) simpleUpload(ctx context.Context, itemPath string, data io.Reader) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	builder, err := ds.item(ctx, itemPath)
+	if err != nil {
+		return err
+	}
+	_, err = builder.Content().Put(ctx, content, nil)
+	return err
+}
+
+// sessionUpload streams the data to an upload session a chunk at a time. The total size is
+// sent as * until the last chunk is read.
+func (ds *MsGraphDriveStorage) sessionUpload(ctx context.Context, itemPath string, data io.Reader) error {
+	builder, err := ds.item(ctx, itemPath)
+	if err != nil {
+		return err
+	}
+
+	properties := models.NewDriveItemUploadableProperties()
+	properties.SetAdditionalData(map[string]interface{}{
+		"@microsoft.graph.conflictBehavior": "replace",
+	})
+	body := drives.NewItemItemsItemCreateUploadSessionPostRequestBody()
+	body.SetItem(properties)
+
+	session, err := builder.CreateUploadSession().Post(ctx, body, nil)
+	if err != nil {
+		return err
+	}
+	uploadUrl := cloudy.StringFromP(session.GetUploadUrl())
+
+	buffered := bufio.NewReader(data)
+	chunk := make([]byte, driveChunkBytes)
+	for start := int64(0); ; {
+		n, last, err := readDriveChunk(buffered, chunk)
+		if err == nil {
+			total := "*"
+			if last {
+				total = strconv.FormatInt(start+int64(n), 10)
+			}
+			err = ds.uploadChunk(ctx, uploadUrl, chunk[:n], start, total)
+		}
+		if err != nil {
+			ds.cancelUpload(ctx, uploadUrl)
+			return err
+		}
+
+		start += int64(n)
+		if last {
+			return nil
+		}
+	}
+}
+
+// readDriveChunk fills the chunk from the reader and returns the number of bytes read and
+// whether they are the end of the data
+func readDriveChunk(r *bufio.Reader, chunk []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, chunk)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+
+	_, err = r.Peek(1)
+	return n, errors.Is(err, io.EOF), nil
+}
+
+// uploadChunk sends the chunk starting at the offset. When it fails the session is asked
+// which bytes it expects next and the rest of the chunk is sent again. The upload URL is
+// pre-authenticated, the session requests are sent without the Graph token.
+func (ds *MsGraphDriveStorage) uploadChunk(ctx context.Context, uploadUrl string, chunk []byte, start int64, total string) error {
+	sent := int64(0)
+
+	var err error
+	for attempt := 0; attempt < driveUploadAttempts; attempt++ {
+		contentRange := fmt.Sprintf("bytes %d-%d/%s", start+sent, start+int64(len(chunk))-1, total)
+		_, err = sendToUploadSession(ctx, http.MethodPut, uploadUrl, chunk[sent:], contentRange)
+		if err == nil {
+			return nil
+		}
+
+		next, statusErr := ds.nextExpectedByte(ctx, uploadUrl)
+		if statusErr != nil || next < start {
+			return err
+		}
+		if next >= start+int64(len(chunk)) {
+			return nil
+		}
+		sent = next - start
+	}
+
+	return err
+}
+
+// nextExpectedByte returns the first byte the upload session is missing
+func (ds *MsGraphDriveStorage) nextExpectedByte(ctx context.Context, uploadUrl string) (int64, error) {
+	body, err := sendToUploadSession(ctx, http.MethodGet, uploadUrl, nil, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var status struct {
+		NextExpectedRanges []string `json:"nextExpectedRanges"`
+	}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return 0, err
+	}
+	return nextExpectedByte(status.NextExpectedRanges)
+}
+
+func (ds *MsGraphDriveStorage) cancelUpload(ctx context.Context, uploadUrl string) {
+	_, err := sendToUploadSession(ctx, http.MethodDelete, uploadUrl, nil, "")
+	if err != nil {
+		cloudy.Warn(ctx, "Upload - unable to cancel the upload session: %v", err)
+	}
+}
+
+// Exists returns true when the file exists
+func (ds *MsGraphDriveStorage) Exists(ctx context.Context, key string) (bool, error) {
+	item, err := ds.getDriveItem(ctx, ds.path(key), nil)
+	return item != nil, err
+}
+
+// Download returns the content of the file, streamed from its download URL
+func (ds *MsGraphDriveStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	itemPath := ds.path(key)
+	cloudy.Info(ctx, "[%s] Download", itemPath)
+
+	item, err := ds.getDriveItem(ctx, itemPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, cloudy.Error(ctx, "[%s] Download - file not found", itemPath)
+	}
+
+	downloadUrl, _ := item.GetAdditionalData()["@microsoft.graph.downloadUrl"].(*string)
+	if downloadUrl == nil {
+		builder, err := ds.item(ctx, itemPath)
+		if err != nil {
+			return nil, err
+		}
+		content, err := builder.Content().Get(ctx, nil)
+		if err != nil {
+			message := errorMessage(ctx, err)
+			return nil, cloudy.Error(ctx, "[%s] Download Error: %s", itemPath, message)
+		}
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	// the download URL is pre-authenticated and short lived
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *downloadUrl, nil)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "[%s] Download Error: %v", itemPath, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "[%s] Download Error: %v", itemPath, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, cloudy.Error(ctx, "[%s] Download Error: %s", itemPath, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Delete deletes the file or folder
+func (ds *MsGraphDriveStorage) Delete(ctx context.Context, key string) error {
+	return ds.deleteDriveItem(ctx, ds.path(key))
+}
+
+// List returns the files and folders whose key starts with the prefix. Like object
+// storage the prefix is a folder, e.g. reports/, followed by the start of a name.
+func (ds *MsGraphDriveStorage) List(ctx context.Context, prefix string) ([]*storage.StoredObject, []*storage.StoredPrefix, error) {
+	dir, name := splitDrivePrefix(prefix)
+
+	objects := []*storage.StoredObject{}
+	prefixes := []*storage.StoredPrefix{}
+	err := ds.listChildren(ctx, ds.path(dir), func(item models.DriveItemable) bool {
+		itemName := cloudy.StringFromP(item.GetName())
+		if !strings.HasPrefix(itemName, name) {
+			return true
+		}
+
+		key := path.Join(dir, itemName)
+		if item.GetFolder() != nil {
+			prefixes = append(prefixes, &storage.StoredPrefix{Key: key + "/"})
+			return true
+		}
+
+		object := &storage.StoredObject{Key: key}
+		if item.GetSize() != nil {
+			object.Size = *item.GetSize()
+		}
+		objects = append(objects, object)
+		return true
+	})
+
+	return objects, prefixes, err
+}
+
+// UpdateMetadata sets the tags as the document library columns of the file. The columns
+// have to exist in the library.
+func (ds *MsGraphDriveStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	itemPath := ds.path(key)
+	cloudy.Info(ctx, "[%s] UpdateMetadata", itemPath)
+
+	item, err := ds.getDriveItem(ctx, itemPath, &drives.ItemItemsDriveItemItemRequestBuilderGetQueryParameters{
+		Expand: []string{"listItem($select=id)"},
+	})
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return cloudy.Error(ctx, "[%s] UpdateMetadata - file not found", itemPath)
+	}
+	if item.GetListItem() == nil || item.GetListItem().GetId() == nil {
+		cloudy.Warn(ctx, "[%s] UpdateMetadata %v", itemPath, ErrDriveMetadataUnsupported)
+		return ErrDriveMetadataUnsupported
+	}
+
+	fields := models.NewFieldValueSet()
+	values := map[string]interface{}{}
+	for k, v := range tags {
+		values[k] = v
+	}
+	fields.SetAdditionalData(values)
+
+	drive, err := ds.drive(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = drive.List().Items().ByListItemId(*item.GetListItem().GetId()).Fields().Patch(ctx, fields, nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] UpdateMetadata Error: %s", itemPath, message)
+	}
+
+	return nil
+}
+
+// CreateFolder creates the folder and its missing parents
+func (ds *MsGraphDriveStorage) CreateFolder(ctx context.Context, key string) error {
+	return ds.createFolder(ctx, ds.path(key))
+}
+
+// GetMetadata returns the metadata of the file or folder, nil when it does not exist
+func (ds *MsGraphDriveStorage) GetMetadata(ctx context.Context, key string) (*DriveItemMetadata, error) {
+	item, err := ds.getDriveItem(ctx, ds.path(key), &drives.ItemItemsDriveItemItemRequestBuilderGetQueryParameters{
+		Expand: []string{"listItem($expand=fields)"},
+	})
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	rtn := DriveItemToMetadata(item)
+	rtn.Key = drivePath(key)
+	return rtn, nil
+}
+
+// path returns the path of the key under the folder. The key is cleaned first so it cannot
+// leave the folder with ".."
+func (ds *MsGraphDriveStorage) path(key string) string {
+	return drivePath(path.Join(ds.folder, drivePath(key)))
+}
+
+func DriveItemToMetadata(item models.DriveItemable) *DriveItemMetadata {
+	rtn := &DriveItemMetadata{
+		ID:       cloudy.StringFromP(item.GetId()),
+		Name:     cloudy.StringFromP(item.GetName()),
+		IsFolder: item.GetFolder() != nil,
+		WebURL:   cloudy.StringFromP(item.GetWebUrl()),
+		ETag:     cloudy.StringFromP(item.GetETag()),
+	}
+	if item.GetSize() != nil {
+		rtn.Size = *item.GetSize()
+	}
+	if item.GetFile() != nil {
+		rtn.ContentType = cloudy.StringFromP(item.GetFile().GetMimeType())
+	}
+	if item.GetCreatedDateTime() != nil {
+		rtn.Created = *item.GetCreatedDateTime()
+	}
+	if item.GetLastModifiedDateTime() != nil {
+		rtn.Modified = *item.GetLastModifiedDateTime()
+	}
+	if item.GetListItem() != nil && item.GetListItem().GetFields() != nil {
+		rtn.Fields = map[string]interface{}{}
+		for k, v := range item.GetListItem().GetFields().GetAdditionalData() {
+			if !strings.HasPrefix(k, "@odata") {
+				rtn.Fields[k] = derefAny(v)
+			}
+		}
+	}
+	return rtn
+}
+
+func driveItemToStorageArea(item models.DriveItemable) *storage.StorageArea {
+	return &storage.StorageArea{
+		Name: cloudy.StringFromP(item.GetName()),
+		Tags: map[string]string{
+			"id":     cloudy.StringFromP(item.GetId()),
+			"webUrl": cloudy.StringFromP(item.GetWebUrl()),
+		},
+	}
+}
+
+// derefAny returns the value of the pointers the JSON parse nodes return
+func derefAny(v interface{}) interface{} {
+	switch p := v.(type) {
+	case *string:
+		return cloudy.StringFromP(p)
+	case *bool:
+		return p != nil && *p
+	case *float64:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case *int32:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case *int64:
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	return v
+}
+
+// drivePath cleans the key into a path relative to the drive root, "" for the root
+func drivePath(key string) string {
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	return strings.TrimPrefix(cleaned, "/")
+}
+
+// driveItemId addresses the item by its path from the drive root
+func driveItemId(itemPath string) string {
+	if itemPath == "" {
+		return "root"
+	}
+	return "root:/" + itemPath + ":"
+}
+
+// splitDrivePrefix splits a list prefix into its folder and the start of the names
+func splitDrivePrefix(prefix string) (string, string) {
+	prefix = strings.TrimPrefix(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	slash := strings.LastIndex(prefix, "/")
+	if slash < 0 {
+		return "", prefix
+	}
+	return drivePath(prefix[:slash]), prefix[slash+1:]
+}
+
+// nextExpectedByte returns the start of the first range an upload session expects, e.g.
+// 1048576 for ["1048576-", "2097152-3145727"]
+func nextExpectedByte(ranges []string) (int64, error) {
+	if len(ranges) == 0 {
+		return 0, errors.New("the upload session expects no more bytes")
+	}
+	start, _, _ := strings.Cut(ranges[0], "-")
+	return strconv.ParseInt(start, 10, 64)
+}
+
+// readerSize returns the number of bytes left in the reader, -1 when it is unknown
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case *bytes.Reader:
+		return int64(v.Len())
+	case *bytes.Buffer:
+		return int64(v.Len())
+	case *strings.Reader:
+		return int64(v.Len())
+	case io.Seeker:
+		current, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := v.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		_, err = v.Seek(current, io.SeekStart)
+		if err != nil {
+			return -1
+		}
+		return end - current
+	}
+	return -1
+}
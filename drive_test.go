@@ -0,0 +1,150 @@
+package cloudymsgraph
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrivePath(t *testing.T) {
+	assert.Equal(t, "", drivePath(""))
+	assert.Equal(t, "", drivePath("/"))
+	assert.Equal(t, "reports/2024/summary.pdf", drivePath("/reports//2024/./summary.pdf"))
+	assert.Equal(t, "reports/summary.pdf", drivePath("reports\\summary.pdf"))
+	assert.Equal(t, "summary.pdf", drivePath("../../summary.pdf"))
+
+	assert.Equal(t, "root", driveItemId(""))
+	assert.Equal(t, "root:/reports/summary.pdf:", driveItemId("reports/summary.pdf"))
+
+	ds := &MsGraphDriveStorage{folder: "archive"}
+	assert.Equal(t, "archive/reports/summary.pdf", ds.path("/reports/summary.pdf"))
+	assert.Equal(t, "archive", ds.path(""))
+	assert.Equal(t, "archive/payroll/secret.xlsx", ds.path("../payroll/secret.xlsx"))
+	assert.Equal(t, "archive/secret.xlsx", ds.path("reports/../../secret.xlsx"))
+}
+
+func TestSplitDrivePrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		dir    string
+		name   string
+	}{
+		{"", "", ""},
+		{"sum", "", "sum"},
+		{"reports/", "reports", ""},
+		{"/reports/2024/sum", "reports/2024", "sum"},
+	}
+
+	for _, tt := range tests {
+		dir, name := splitDrivePrefix(tt.prefix)
+		assert.Equal(t, tt.dir, dir, tt.prefix)
+		assert.Equal(t, tt.name, name, tt.prefix)
+	}
+}
+
+func TestNextExpectedByte(t *testing.T) {
+	next, err := nextExpectedByte([]string{"1048576-", "2097152-3145727"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1048576), next)
+
+	next, err = nextExpectedByte([]string{"0-327679"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), next)
+
+	_, err = nextExpectedByte(nil)
+	assert.NotNil(t, err)
+}
+
+func TestReadDriveChunk(t *testing.T) {
+	chunk := make([]byte, 4)
+
+	r := bufio.NewReader(io.MultiReader(strings.NewReader("abcd"), strings.NewReader("efgh"), strings.NewReader("ij")))
+	n, last, err := readDriveChunk(r, chunk)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.False(t, last)
+	n, last, _ = readDriveChunk(r, chunk)
+	assert.Equal(t, "efgh", string(chunk[:n]))
+	assert.False(t, last)
+	n, last, _ = readDriveChunk(r, chunk)
+	assert.Equal(t, "ij", string(chunk[:n]))
+	assert.True(t, last)
+
+	// data ending on a chunk boundary is last without an empty chunk
+	r = bufio.NewReader(io.MultiReader(strings.NewReader("abcd")))
+	n, last, _ = readDriveChunk(r, chunk)
+	assert.Equal(t, 4, n)
+	assert.True(t, last)
+}
+
+func TestReaderSize(t *testing.T) {
+	assert.Equal(t, int64(5), readerSize(bytes.NewReader([]byte("hello"))))
+	assert.Equal(t, int64(5), readerSize(strings.NewReader("hello")))
+	assert.Equal(t, int64(0), readerSize(&bytes.Buffer{}))
+	assert.Equal(t, int64(-1), readerSize(io.MultiReader(strings.NewReader("hello"))))
+
+	f, err := os.CreateTemp(t.TempDir(), "drive")
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello world")
+	assert.Nil(t, err)
+	_, err = f.Seek(6, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), readerSize(f))
+
+	// the position is restored
+	pos, _ := f.Seek(0, 1)
+	assert.Equal(t, int64(6), pos)
+}
+
+func TestDriveItemToMetadata(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	size := int64(2048)
+
+	item := models.NewDriveItem()
+	item.SetId(cloudy.StringP("01ABC"))
+	item.SetName(cloudy.StringP("summary.pdf"))
+	item.SetSize(&size)
+	item.SetWebUrl(cloudy.StringP("https://contoso.sharepoint.com/Shared%20Documents/summary.pdf"))
+	item.SetCreatedDateTime(&created)
+	file := models.NewFile()
+	file.SetMimeType(cloudy.StringP("application/pdf"))
+	item.SetFile(file)
+
+	fields := models.NewFieldValueSet()
+	fields.SetAdditionalData(map[string]interface{}{
+		"@odata.etag": cloudy.StringP("\"1\""),
+		"Project":     cloudy.StringP("Apollo"),
+		"Reviewed":    cloudy.BoolP(true),
+	})
+	listItem := models.NewListItem()
+	listItem.SetFields(fields)
+	item.SetListItem(listItem)
+
+	md := DriveItemToMetadata(item)
+	assert.Equal(t, "01ABC", md.ID)
+	assert.Equal(t, "summary.pdf", md.Name)
+	assert.Equal(t, int64(2048), md.Size)
+	assert.False(t, md.IsFolder)
+	assert.Equal(t, "application/pdf", md.ContentType)
+	assert.Equal(t, created, md.Created)
+	assert.Equal(t, map[string]interface{}{"Project": "Apollo", "Reviewed": true}, md.Fields)
+
+	folder := models.NewDriveItem()
+	folder.SetName(cloudy.StringP("reports"))
+	folder.SetFolder(models.NewFolder())
+	md = DriveItemToMetadata(folder)
+	assert.True(t, md.IsFolder)
+	assert.Nil(t, md.Fields)
+
+	area := driveItemToStorageArea(folder)
+	assert.Equal(t, "reports", area.Name)
+}
@@ -0,0 +1,456 @@
+package cloudymsgraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+	"gopkg.in/gomail.v2"
+)
+
+func init() {
+	cloudy.EmailerProviders.Register(MsGraphName, &MsGraphEmailerFactory{})
+}
+
+// MaxInlineAttachmentBytes is the largest attachment sent with the message, larger
+// attachments are uploaded to a draft with an upload session
+const MaxInlineAttachmentBytes = 3 * 1024 * 1024
+
+// attachmentChunkBytes is the size of the upload session chunks, a multiple of 320 KiB
+// below the 4 MB Graph accepts per request
+const attachmentChunkBytes = 10 * 320 * 1024
+
+var ErrNoMailSender = errors.New("no mailbox to send the email from")
+
+// EmailMessage is an email sent through Graph
+type EmailMessage struct {
+	// From is the sender address. Defaults to the MailSender mailbox, sending as another
+	// address requires the send as permission
+	From string
+
+	To      []string
+	CC      []string
+	BCC     []string
+	ReplyTo []string
+
+	Subject string
+	Body    string
+	HTML    bool
+
+	Attachments []*EmailAttachment
+}
+
+// EmailAttachment is a file attached to an email. Inline attachments are referenced from
+// the HTML body by their content id, e.g. <img src="cid:logo">
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Content     []byte
+}
+
+type MsGraphEmailerFactory struct {
+	MsGraph
+}
+
+func (ef *MsGraphEmailerFactory) Create(cfg interface{}) (cloudy.Emailer, error) {
+	return NewMsGraphEmailer(context.Background(), cfg.(*MsGraphConfig))
+}
+
+func (ef *MsGraphEmailerFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	cfg := fromEnvironment(env)
+	return cfg, nil
+}
+
+// MsGraphEmailer sends email with the Graph sendMail API, for tenants where SMTP relay
+// is not available
+type MsGraphEmailer struct {
+	*MsGraph
+}
+
+func NewMsGraphEmailer(ctx context.Context, cfg *MsGraphConfig) (*MsGraphEmailer, error) {
+	em := &MsGraphEmailer{
+		MsGraph: &MsGraph{},
+	}
+	err := em.Configure(cfg)
+
+	return em, err
+}
+
+// Send sends the gomail message, as created by cloudy.Mailer
+func (em *MsGraphEmailer) Send(ctx context.Context, message *gomail.Message) error {
+	msg, err := EmailFromGoMail(message)
+	if err != nil {
+		return cloudy.Error(ctx, "Send Error: %v", err)
+	}
+	return em.SendEmail(ctx, msg)
+}
+
+// SendEmail sends the message from the MailSender mailbox. Messages with attachments larger
+// than MaxInlineAttachmentBytes, or larger together, are created as a draft, their
+// attachments uploaded and the draft sent.
+func (em *MsGraphEmailer) SendEmail(ctx context.Context, msg *EmailMessage) error {
+	mailbox := em.mailbox(msg)
+	if mailbox == "" {
+		cloudy.Warn(ctx, "SendEmail %v", ErrNoMailSender)
+		return ErrNoMailSender
+	}
+	cloudy.Info(ctx, "[%s] SendEmail %s", mailbox, msg.Subject)
+
+	if !needsDraft(msg.Attachments) {
+		body := users.NewItemSendMailPostRequestBody()
+		body.SetMessage(EmailToGraph(msg, true))
+		body.SetSaveToSentItems(cloudy.BoolP(true))
+
+		err := em.Client.Users().ByUserId(mailbox).SendMail().Post(ctx, body, nil)
+		if err != nil {
+			message := errorMessage(ctx, err)
+			return cloudy.Error(ctx, "[%s] SendEmail Error: %s", mailbox, message)
+		}
+		return nil
+	}
+
+	return em.sendDraft(ctx, mailbox, msg)
+}
+
+// sendDraft creates the message as a draft, adds the attachments one at a time and sends it
+func (em *MsGraphEmailer) sendDraft(ctx context.Context, mailbox string, msg *EmailMessage) error {
+	messages := em.Client.Users().ByUserId(mailbox).Messages()
+
+	draft, err := messages.Post(ctx, EmailToGraph(msg, false), nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SendEmail - unable to create the draft: %s", mailbox, message)
+	}
+	draftId := cloudy.StringFromP(draft.GetId())
+
+	for _, a := range msg.Attachments {
+		if len(a.Content) > MaxInlineAttachmentBytes {
+			err = em.uploadAttachment(ctx, mailbox, draftId, a)
+		} else {
+			_, err = messages.ByMessageId(draftId).Attachments().Post(ctx, attachmentToGraph(a), nil)
+		}
+		if err != nil {
+			message := errorMessage(ctx, err)
+			em.deleteDraft(ctx, mailbox, draftId)
+			return cloudy.Error(ctx, "[%s] SendEmail - unable to attach %s: %s", mailbox, a.Name, message)
+		}
+	}
+
+	err = messages.ByMessageId(draftId).Send().Post(ctx, nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		em.deleteDraft(ctx, mailbox, draftId)
+		return cloudy.Error(ctx, "[%s] SendEmail Error: %s", mailbox, message)
+	}
+
+	return nil
+}
+
+// uploadAttachment uploads the attachment to the draft with an upload session, a chunk
+// at a time and in order as Outlook requires. The chunks are sent to the pre-authenticated
+// upload URL without the Graph token.
+func (em *MsGraphEmailer) uploadAttachment(ctx context.Context, mailbox string, draftId string, a *EmailAttachment) error {
+	size := int64(len(a.Content))
+	attachmentType := models.FILE_ATTACHMENTTYPE
+
+	item := models.NewAttachmentItem()
+	item.SetAttachmentType(&attachmentType)
+	item.SetName(&a.Name)
+	item.SetSize(&size)
+	item.SetContentType(cloudy.StringP(attachmentContentType(a)))
+	if a.Inline {
+		item.SetIsInline(&a.Inline)
+		item.SetContentId(&a.ContentID)
+	}
+
+	body := users.NewItemMessagesItemAttachmentsCreateUploadSessionPostRequestBody()
+	body.SetAttachmentItem(item)
+
+	session, err := em.Client.Users().ByUserId(mailbox).Messages().ByMessageId(draftId).Attachments().CreateUploadSession().Post(ctx, body, nil)
+	if err != nil {
+		return err
+	}
+	uploadUrl := cloudy.StringFromP(session.GetUploadUrl())
+
+	for _, r := range uploadRanges(size, attachmentChunkBytes) {
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", r[0], r[1], size)
+		_, err = sendToUploadSession(ctx, http.MethodPut, uploadUrl, a.Content[r[0]:r[1]+1], contentRange)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (em *MsGraphEmailer) deleteDraft(ctx context.Context, mailbox string, draftId string) {
+	err := em.Client.Users().ByUserId(mailbox).Messages().ByMessageId(draftId).Delete(ctx, nil)
+	if err != nil {
+		message := errorMessage(ctx, err)
+		cloudy.Warn(ctx, "[%s] SendEmail - unable to delete the draft %s: %s", mailbox, draftId, message)
+	}
+}
+
+// mailbox returns the configured MailSender, or the address of the sender
+func (em *MsGraphEmailer) mailbox(msg *EmailMessage) string {
+	if em.Cfg != nil && em.Cfg.MailSender != "" {
+		return em.Cfg.MailSender
+	}
+	if msg.From == "" {
+		return ""
+	}
+	return cloudy.StringFromP(recipientToGraph(msg.From).GetEmailAddress().GetAddress())
+}
+
+// needsDraft returns true when the attachments are too large to send with the message
+func needsDraft(attachments []*EmailAttachment) bool {
+	total := 0
+	for _, a := range attachments {
+		total += len(a.Content)
+	}
+	return total > MaxInlineAttachmentBytes
+}
+
+// uploadRanges splits the size into the inclusive byte ranges of the chunks
+func uploadRanges(size int64, chunk int64) [][2]int64 {
+	rtn := [][2]int64{}
+	for start := int64(0); start < size; start += chunk {
+		end := start + chunk - 1
+		if end >= size {
+			end = size - 1
+		}
+		rtn = append(rtn, [2]int64{start, end})
+	}
+	return rtn
+}
+
+// EmailToGraph converts the message, with its attachments when withAttachments is set
+func EmailToGraph(msg *EmailMessage, withAttachments bool) models.Messageable {
+	rtn := models.NewMessage()
+	rtn.SetSubject(&msg.Subject)
+
+	bodyType := models.TEXT_BODYTYPE
+	if msg.HTML {
+		bodyType = models.HTML_BODYTYPE
+	}
+	body := models.NewItemBody()
+	body.SetContentType(&bodyType)
+	body.SetContent(&msg.Body)
+	rtn.SetBody(body)
+
+	if msg.From != "" {
+		rtn.SetFrom(recipientToGraph(msg.From))
+	}
+	rtn.SetToRecipients(recipientsToGraph(msg.To))
+	if len(msg.CC) > 0 {
+		rtn.SetCcRecipients(recipientsToGraph(msg.CC))
+	}
+	if len(msg.BCC) > 0 {
+		rtn.SetBccRecipients(recipientsToGraph(msg.BCC))
+	}
+	if len(msg.ReplyTo) > 0 {
+		rtn.SetReplyTo(recipientsToGraph(msg.ReplyTo))
+	}
+
+	if withAttachments && len(msg.Attachments) > 0 {
+		attachments := []models.Attachmentable{}
+		for _, a := range msg.Attachments {
+			attachments = append(attachments, attachmentToGraph(a))
+		}
+		rtn.SetAttachments(attachments)
+		rtn.SetHasAttachments(cloudy.BoolP(true))
+	}
+
+	return rtn
+}
+
+func attachmentToGraph(a *EmailAttachment) models.Attachmentable {
+	rtn := models.NewFileAttachment()
+	rtn.SetName(&a.Name)
+	rtn.SetContentType(cloudy.StringP(attachmentContentType(a)))
+	rtn.SetContentBytes(a.Content)
+	if a.Inline {
+		rtn.SetIsInline(&a.Inline)
+		rtn.SetContentId(&a.ContentID)
+	}
+	return rtn
+}
+
+func attachmentContentType(a *EmailAttachment) string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if t := mime.TypeByExtension(extensionOf(a.Name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func extensionOf(name string) string {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return ""
+	}
+	return name[dot:]
+}
+
+func recipientsToGraph(addresses []string) []models.Recipientable {
+	rtn := []models.Recipientable{}
+	for _, address := range addresses {
+		rtn = append(rtn, recipientToGraph(address))
+	}
+	return rtn
+}
+
+// recipientToGraph converts an address, with or without a name ("Jane <jane@example.com>")
+func recipientToGraph(address string) models.Recipientable {
+	email := models.NewEmailAddress()
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		email.SetAddress(cloudy.StringP(strings.TrimSpace(address)))
+	} else {
+		email.SetAddress(&parsed.Address)
+		if parsed.Name != "" {
+			email.SetName(&parsed.Name)
+		}
+	}
+
+	rtn := models.NewRecipient()
+	rtn.SetEmailAddress(email)
+	return rtn
+}
+
+// EmailFromGoMail reads the recipients from the headers of the gomail message and the
+// bodies and attachments from its MIME content
+func EmailFromGoMail(message *gomail.Message) (*EmailMessage, error) {
+	rtn := &EmailMessage{
+		To:      goMailHeader(message, "To"),
+		CC:      goMailHeader(message, "Cc"),
+		BCC:     goMailHeader(message, "Bcc"),
+		ReplyTo: goMailHeader(message, "Reply-To"),
+	}
+	if from := goMailHeader(message, "From"); len(from) > 0 {
+		rtn.From = from[0]
+	}
+	if subject := goMailHeader(message, "Subject"); len(subject) > 0 {
+		rtn.Subject = subject[0]
+	}
+
+	buf := &bytes.Buffer{}
+	_, err := message.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := mail.ReadMessage(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var text, html *string
+	err = readMimePart(textproto.MIMEHeader(parsed.Header), parsed.Body, func(header textproto.MIMEHeader, mediaType string, content []byte) {
+		disposition, params, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		name := params["filename"]
+		if name == "" && disposition == "" {
+			switch mediaType {
+			case "text/html":
+				if html == nil {
+					html = cloudy.StringP(string(content))
+				}
+				return
+			case "text/plain", "text/text":
+				if text == nil {
+					text = cloudy.StringP(string(content))
+				}
+				return
+			}
+		}
+
+		rtn.Attachments = append(rtn.Attachments, &EmailAttachment{
+			Name:        name,
+			ContentType: mediaType,
+			ContentID:   strings.Trim(header.Get("Content-ID"), "<>"),
+			Inline:      disposition == "inline",
+			Content:     content,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if html != nil {
+		rtn.Body = *html
+		rtn.HTML = true
+	} else if text != nil {
+		rtn.Body = *text
+	}
+	return rtn, nil
+}
+
+// goMailHeader returns the values of the header, decoding the non ASCII values gomail
+// encodes when they are set
+func goMailHeader(message *gomail.Message, field string) []string {
+	decoder := &mime.WordDecoder{}
+	rtn := []string{}
+	for _, v := range message.GetHeader(field) {
+		decoded, err := decoder.DecodeHeader(v)
+		if err != nil {
+			decoded = v
+		}
+		rtn = append(rtn, decoded)
+	}
+	return rtn
+}
+
+// readMimePart calls fn with each leaf part of the MIME content, decoded
+func readMimePart(header textproto.MIMEHeader, body io.Reader, fn func(header textproto.MIMEHeader, mediaType string, content []byte)) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = readMimePart(part.Header, part, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	fn(header, mediaType, content)
+	return nil
+}
@@ -0,0 +1,126 @@
+package cloudymsgraph
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/gomail.v2"
+)
+
+func TestEmailFromGoMail(t *testing.T) {
+	report := bytes.Repeat([]byte{0, 1, 2, 250, 251, 252}, 1000)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "Notifications <noreply@example.com>")
+	m.SetHeader("To", "jane@example.com", "john@example.com")
+	m.SetHeader("Cc", "cc@example.com")
+	m.SetHeader("Bcc", "audit@example.com")
+	m.SetHeader("Reply-To", "support@example.com")
+	m.SetHeader("Subject", "Your account is ready – welcome")
+	m.SetBody("text/plain", "Welcome")
+	m.AddAlternative("text/html", "<p>Welcome é</p>")
+	m.Attach("report.bin", gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(report)
+		return err
+	}))
+
+	msg, err := EmailFromGoMail(m)
+	assert.Nil(t, err)
+	assert.Equal(t, "Notifications <noreply@example.com>", msg.From)
+	assert.Equal(t, []string{"jane@example.com", "john@example.com"}, msg.To)
+	assert.Equal(t, []string{"cc@example.com"}, msg.CC)
+	assert.Equal(t, []string{"audit@example.com"}, msg.BCC)
+	assert.Equal(t, []string{"support@example.com"}, msg.ReplyTo)
+	assert.Equal(t, "Your account is ready – welcome", msg.Subject)
+	assert.True(t, msg.HTML)
+	assert.Equal(t, "<p>Welcome é</p>", msg.Body)
+	assert.Len(t, msg.Attachments, 1)
+	assert.Equal(t, "report.bin", msg.Attachments[0].Name)
+	assert.Equal(t, report, msg.Attachments[0].Content)
+
+	// cloudy.Mailer sets plain text bodies as text/text
+	m = gomail.NewMessage()
+	m.SetHeader("To", "jane@example.com")
+	m.SetBody("text/text", "Plain")
+	msg, err = EmailFromGoMail(m)
+	assert.Nil(t, err)
+	assert.False(t, msg.HTML)
+	assert.Equal(t, "Plain", msg.Body)
+	assert.Empty(t, msg.Attachments)
+}
+
+func TestEmailToGraph(t *testing.T) {
+	msg := &EmailMessage{
+		From:    "Notifications <noreply@example.com>",
+		To:      []string{"jane@example.com"},
+		BCC:     []string{"audit@example.com"},
+		ReplyTo: []string{"support@example.com"},
+		Subject: "Hello",
+		Body:    "<p>Hello</p>",
+		HTML:    true,
+		Attachments: []*EmailAttachment{
+			{Name: "logo.png", ContentID: "logo", Inline: true, Content: []byte{1}},
+		},
+	}
+
+	m := EmailToGraph(msg, true)
+	assert.Equal(t, "Hello", *m.GetSubject())
+	assert.Equal(t, models.HTML_BODYTYPE, *m.GetBody().GetContentType())
+	assert.Equal(t, "noreply@example.com", *m.GetFrom().GetEmailAddress().GetAddress())
+	assert.Equal(t, "Notifications", *m.GetFrom().GetEmailAddress().GetName())
+	assert.Equal(t, "jane@example.com", *m.GetToRecipients()[0].GetEmailAddress().GetAddress())
+	assert.Nil(t, m.GetCcRecipients())
+	assert.Equal(t, "audit@example.com", *m.GetBccRecipients()[0].GetEmailAddress().GetAddress())
+	assert.Equal(t, "support@example.com", *m.GetReplyTo()[0].GetEmailAddress().GetAddress())
+
+	attachment := m.GetAttachments()[0].(models.FileAttachmentable)
+	assert.Equal(t, "image/png", *attachment.GetContentType())
+	assert.Equal(t, "logo", *attachment.GetContentId())
+	assert.True(t, *attachment.GetIsInline())
+
+	assert.Nil(t, EmailToGraph(msg, false).GetAttachments())
+}
+
+func TestNeedsDraft(t *testing.T) {
+	small := &EmailAttachment{Content: make([]byte, MaxInlineAttachmentBytes/2)}
+	assert.False(t, needsDraft(nil))
+	assert.False(t, needsDraft([]*EmailAttachment{small, small}))
+	assert.True(t, needsDraft([]*EmailAttachment{small, small, small}))
+	assert.True(t, needsDraft([]*EmailAttachment{{Content: make([]byte, MaxInlineAttachmentBytes+1)}}))
+}
+
+func TestUploadRanges(t *testing.T) {
+	assert.Equal(t, [][2]int64{}, uploadRanges(0, 10))
+	assert.Equal(t, [][2]int64{{0, 9}}, uploadRanges(10, 10))
+	assert.Equal(t, [][2]int64{{0, 9}, {10, 19}, {20, 24}}, uploadRanges(25, 10))
+}
+
+func TestSendToUploadSession(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "bytes 0-2/6", r.Header.Get("Content-Range"))
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"nextExpectedRanges":["3-"]}`))
+	}))
+	defer server.Close()
+
+	body, err := sendToUploadSession(context.Background(), http.MethodPut, server.URL, []byte("abc"), "bytes 0-2/6")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), received)
+	assert.Equal(t, `{"nextExpectedRanges":["3-"]}`, string(body))
+
+	_, err = sendToUploadSession(context.Background(), http.MethodDelete, server.URL, nil, "")
+	assert.NotNil(t, err)
+}
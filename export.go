@@ -0,0 +1,462 @@
+package cloudymsgraph
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// ExportFormat is the format of a user export
+type ExportFormat string
+
+const (
+	ExportFormatCSV       ExportFormat = "csv"
+	ExportFormatJSONLines ExportFormat = "jsonl"
+	ExportFormatXLSX      ExportFormat = "xlsx"
+)
+
+const (
+	// ExportColumnLicenses lists the names of the licenses assigned to the user
+	ExportColumnLicenses = "licenses"
+
+	// ExportColumnGroups lists the names of the groups the user is a member of
+	ExportColumnGroups = "groups"
+)
+
+// exportPageSize is the number of users read per page
+const exportPageSize = int32(999)
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+// ExportOptions controls a user export
+type ExportOptions struct {
+	Format ExportFormat
+
+	// Columns are the Graph user properties (e.g. givenName), cloudy User fields (e.g.
+	// FirstName), user attributes, custom security attributes, ExportColumnLicenses and
+	// ExportColumnGroups to export. Defaults to the mapped user properties and the custom
+	// security attributes.
+	Columns []string
+
+	// Filter is the $filter of the users to export, all users when empty
+	Filter string
+}
+
+// ExportUsers writes the users to w, page by page, and returns the number of users
+// written. Licenses are named from the subscribed SKUs and the groups of each user, including
+// nested groups, are read with the user, only when their columns are requested.
+func (um *MsGraphUserManager) ExportUsers(ctx context.Context, w io.Writer, opts *ExportOptions) (int, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = um.defaultExportColumns()
+	}
+	cloudy.Info(ctx, "ExportUsers %s %v", opts.Format, columns)
+
+	writer, err := newExportWriter(w, opts.Format, columns)
+	if err != nil {
+		return 0, cloudy.Error(ctx, "ExportUsers Error: %v", err)
+	}
+	// the writer is closed on failure too so the output is well formed up to the failure
+	closed := false
+	defer func() {
+		if !closed {
+			writer.Close()
+		}
+	}()
+
+	withLicenses := containsFold(columns, ExportColumnLicenses)
+	licenseNames := map[string]string{}
+	if withLicenses {
+		lm := &MsGraphLicenseManager{MsGraph: um.MsGraph}
+		licenses, err := lm.ListLicenses(ctx)
+		if err != nil {
+			return 0, cloudy.Error(ctx, "ExportUsers - unable to list the licenses: %v", err)
+		}
+		for _, l := range licenses {
+			licenseNames[strings.ToLower(l.SKU)] = l.Name
+		}
+	}
+	withGroups := containsFold(columns, ExportColumnGroups)
+
+	fields := um.userSelectFields(ctx, nil, um.exportSelectFields(columns)...)
+	top := exportPageSize
+	params := &users.UsersRequestBuilderGetQueryParameters{
+		Select: fields,
+		Top:    &top,
+	}
+	if opts.Filter != "" {
+		params.Filter = &opts.Filter
+	}
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	result, err := um.Client.Users().Get(ctx,
+		&users.UsersRequestBuilderGetRequestConfiguration{
+			Headers:         headers,
+			QueryParameters: params,
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return 0, cloudy.Error(ctx, "ExportUsers Error: %s", message)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, um.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var rowErr error
+	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
+		user, attributes := um.userToCloudyWithSelect(ctx, pageItem, fields)
+
+		extra := map[string]interface{}{}
+		if withLicenses {
+			names := []string{}
+			for _, l := range pageItem.GetAssignedLicenses() {
+				if l.GetSkuId() == nil {
+					continue
+				}
+				sku := l.GetSkuId().String()
+				if name, ok := licenseNames[strings.ToLower(sku)]; ok {
+					names = append(names, name)
+				} else {
+					names = append(names, sku)
+				}
+			}
+			extra[ExportColumnLicenses] = names
+		}
+		if withGroups {
+			names, err := um.exportGroupNames(ctx, user.ID)
+			if err != nil {
+				rowErr = err
+				return false
+			}
+			extra[ExportColumnGroups] = names
+		}
+
+		rowErr = writer.Row(exportRow(columns, user, attributes, extra))
+		if rowErr != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err == nil {
+		err = rowErr
+	}
+	if err != nil {
+		return count, cloudy.Error(ctx, "ExportUsers Error after %d users: %v", count, err)
+	}
+
+	closed = true
+	err = writer.Close()
+	if err != nil {
+		return count, cloudy.Error(ctx, "ExportUsers Error: %v", err)
+	}
+
+	cloudy.Info(ctx, "ExportUsers %d users", count)
+	return count, nil
+}
+
+// exportGroupNames returns the names of the groups the user is a member of, directly or
+// through nested groups. Only the names are selected and nothing is kept between users.
+func (um *MsGraphUserManager) exportGroupNames(ctx context.Context, uid string) ([]string, error) {
+	top := exportPageSize
+	result, err := um.Client.Users().ByUserId(uid).TransitiveMemberOf().GraphGroup().Get(ctx,
+		&users.ItemTransitiveMemberOfGraphGroupRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.ItemTransitiveMemberOfGraphGroupRequestBuilderGetQueryParameters{
+				Select: []string{"displayName"},
+				Top:    &top,
+			},
+		})
+	if err != nil {
+		return nil, fmt.Errorf("[%s] unable to list the groups: %s", uid, errorMessage(ctx, err))
+	}
+
+	rtn := []string{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.Groupable](result, um.Adapter, models.CreateGroupCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+	err = pageIterator.Iterate(ctx, func(group models.Groupable) bool {
+		rtn = append(rtn, cloudy.StringFromP(group.GetDisplayName()))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[%s] unable to list the groups: %s", uid, errorMessage(ctx, err))
+	}
+
+	return rtn, nil
+}
+
+// defaultExportColumns are the mapped user properties, except the opt in ones, and the
+// custom security attributes
+func (um *MsGraphUserManager) defaultExportColumns() []string {
+	columns := DefaultUserFieldMappings.defaultProperties()
+	for _, m := range um.customSecurityAttributes() {
+		if m.Field != "" {
+			columns = append(columns, m.Field)
+		} else {
+			columns = append(columns, m.key())
+		}
+	}
+	return columns
+}
+
+// exportSelectFields returns the columns that are not read by default and are selected
+// by name, e.g. onPremisesSamAccountName
+func (um *MsGraphUserManager) exportSelectFields(columns []string) []string {
+	known := []string{ExportColumnLicenses, ExportColumnGroups}
+	for _, m := range um.customSecurityAttributes() {
+		known = append(known, m.key())
+	}
+
+	userType := reflect.TypeOf(cloudymodels.User{})
+	rtn := []string{}
+	for _, column := range columns {
+		if containsFold(known, column) || DefaultUserFieldMappings.find(column) != nil {
+			continue
+		}
+		if _, ok := userType.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, column) }); ok {
+			continue
+		}
+		rtn = append(rtn, column)
+	}
+	return rtn
+}
+
+// exportRow returns the values of the columns, read from the extra values, the mapped
+// properties, the user fields and the attributes in that order
+func exportRow(columns []string, user *cloudymodels.User, attributes map[string]interface{}, extra map[string]interface{}) []interface{} {
+	userType := reflect.TypeOf(*user)
+
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if value, ok := lookupFold(extra, column); ok {
+			row[i] = value
+			continue
+		}
+
+		if m := DefaultUserFieldMappings.find(column); m != nil {
+			value, _ := m.fromCloudy(user, attributes)
+			row[i] = value
+			continue
+		}
+
+		if f, ok := userType.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, column) }); ok {
+			row[i] = userField(user, f.Name)
+			continue
+		}
+
+		if value, ok := lookupFold(attributes, column); ok {
+			row[i] = value
+			continue
+		}
+
+		row[i] = ""
+	}
+	return row
+}
+
+func lookupFold(values map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := values[key]; ok {
+		return value, true
+	}
+	for k, value := range values {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// exportString formats a value for the CSV and XLSX cells. Lists are joined with "; "
+func exportString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, "; ")
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, exportString(item))
+		}
+		return strings.Join(values, "; ")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}:
+		content, _ := json.Marshal(v)
+		return string(content)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// exportWriter writes the rows of an export in a format
+type exportWriter interface {
+	Row(values []interface{}) error
+	Close() error
+}
+
+func newExportWriter(w io.Writer, format ExportFormat, columns []string) (exportWriter, error) {
+	switch format {
+	case ExportFormatCSV, "":
+		return newCSVExportWriter(w, columns)
+	case ExportFormatJSONLines:
+		return &jsonLinesExportWriter{encoder: json.NewEncoder(w), columns: columns}, nil
+	case ExportFormatXLSX:
+		return newXLSXExportWriter(w, columns)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownExportFormat, format)
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer, columns []string) (*csvExportWriter, error) {
+	writer := csv.NewWriter(w)
+	err := writer.Write(columns)
+	return &csvExportWriter{writer: writer}, err
+}
+
+func (c *csvExportWriter) Row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = exportString(v)
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvExportWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonLinesExportWriter writes an object per user, keyed by column
+type jsonLinesExportWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (j *jsonLinesExportWriter) Row(values []interface{}) error {
+	obj := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		obj[j.columns[i]] = v
+	}
+	return j.encoder.Encode(obj)
+}
+
+func (j *jsonLinesExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter streams a workbook with a single sheet. Cells are written as inline
+// strings so nothing is kept in memory between rows.
+type xlsxExportWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+var xlsxStaticParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Users" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newXLSXExportWriter(w io.Writer, columns []string) (*xlsxExportWriter, error) {
+	x := &xlsxExportWriter{zip: zip.NewWriter(w)}
+
+	for _, part := range xlsxStaticParts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x.sheet = sheet
+
+	_, err = io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return x, x.Row(header)
+}
+
+func (x *xlsxExportWriter) Row(values []interface{}) error {
+	x.row++
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `<row r="%d">`, x.row)
+	for i, v := range values {
+		fmt.Fprintf(b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">`, xlsxColumn(i), x.row)
+		_ = xml.EscapeText(b, []byte(exportString(v)))
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	_, err := io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	if err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// xlsxColumn returns the letters of the zero based column, A to Z then AA and so on
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
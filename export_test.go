@@ -0,0 +1,88 @@
+package cloudymsgraph
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportRow(t *testing.T) {
+	user := &cloudymodels.User{ID: "i", UPN: "john.doe@example.com", FirstName: "John", Enabled: true, AccountType: "Contractor"}
+	attributes := map[string]interface{}{"city": "Rome", "Sponsor": "jane.doe", "onPremisesSamAccountName": "jdoe"}
+	extra := map[string]interface{}{ExportColumnGroups: []string{"a", "b"}}
+
+	row := exportRow([]string{"userPrincipalName", "givenName", "accountEnabled", "AccountType", "city", "sponsor", "onPremisesSamAccountName", "groups", "missing"},
+		user, attributes, extra)
+	assert.Equal(t, []interface{}{"john.doe@example.com", "John", "true", "Contractor", "Rome", "jane.doe", "jdoe", []string{"a", "b"}, ""}, row)
+}
+
+func TestExportSelectFields(t *testing.T) {
+	um := &MsGraphUserManager{MsGraph: &MsGraph{}}
+	assert.Equal(t, []string{"onPremisesSamAccountName"},
+		um.exportSelectFields([]string{"givenName", "FirstName", "Sponsor", "licenses", "groups", "onPremisesSamAccountName"}))
+
+	columns := um.defaultExportColumns()
+	assert.Contains(t, columns, "userPrincipalName")
+	assert.Contains(t, columns, "AccountType")
+	assert.Contains(t, columns, "Sponsor")
+	assert.Empty(t, um.exportSelectFields(columns))
+}
+
+func TestExportWriters(t *testing.T) {
+	columns := []string{"userPrincipalName", "groups"}
+	rows := [][]interface{}{
+		{"john.doe@example.com", []string{"a", "b"}},
+		{"jane.doe@example.com", []string{}},
+	}
+
+	write := func(format ExportFormat) []byte {
+		buf := &bytes.Buffer{}
+		w, err := newExportWriter(buf, format, columns)
+		assert.NoError(t, err)
+		for _, row := range rows {
+			assert.NoError(t, w.Row(row))
+		}
+		assert.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	assert.Equal(t, "userPrincipalName,groups\njohn.doe@example.com,a; b\njane.doe@example.com,\n", string(write(ExportFormatCSV)))
+	assert.Equal(t, `{"groups":["a","b"],"userPrincipalName":"john.doe@example.com"}`+"\n"+
+		`{"groups":[],"userPrincipalName":"jane.doe@example.com"}`+"\n", string(write(ExportFormatJSONLines)))
+
+	content := write(ExportFormatXLSX)
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	names := []string{}
+	var sheet string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, err := f.Open()
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(r)
+			sheet = string(data)
+		}
+	}
+	assert.Equal(t, []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"}, names)
+	assert.True(t, strings.HasSuffix(sheet, `<row r="3"><c r="A3" t="inlineStr"><is><t xml:space="preserve">jane.doe@example.com</t></is></c><c r="B3" t="inlineStr"><is><t xml:space="preserve"></t></is></c></row></sheetData></worksheet>`))
+	assert.Contains(t, sheet, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">a; b</t></is></c>`)
+
+	_, err = newExportWriter(&bytes.Buffer{}, "pdf", columns)
+	assert.ErrorIs(t, err, ErrUnknownExportFormat)
+}
+
+func TestXLSXColumn(t *testing.T) {
+	assert.Equal(t, "A", xlsxColumn(0))
+	assert.Equal(t, "Z", xlsxColumn(25))
+	assert.Equal(t, "AA", xlsxColumn(26))
+	assert.Equal(t, "AZ", xlsxColumn(51))
+	assert.Equal(t, "BA", xlsxColumn(52))
+	assert.Equal(t, "ZZ", xlsxColumn(701))
+	assert.Equal(t, "AAA", xlsxColumn(702))
+}
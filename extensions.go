@@ -0,0 +1,583 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/directoryobjects"
+	"github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// ExtensionDataType is the data type of a directory extension property
+// SEE : https://learn.microsoft.com/en-us/graph/extensibility-overview#directory-microsoft-entra-id-extensions
+type ExtensionDataType string
+
+const (
+	ExtensionBinary       ExtensionDataType = "Binary"
+	ExtensionBoolean      ExtensionDataType = "Boolean"
+	ExtensionDateTime     ExtensionDataType = "DateTime"
+	ExtensionInteger      ExtensionDataType = "Integer"
+	ExtensionLargeInteger ExtensionDataType = "LargeInteger"
+	ExtensionString       ExtensionDataType = "String"
+)
+
+const (
+	ExtensionTargetUser  = "User"
+	ExtensionTargetGroup = "Group"
+)
+
+// ExtensionProperty is a directory extension property registered on an application
+type ExtensionProperty struct {
+	ID string
+
+	// Name is the full name, extension_{appId}_{name}
+	Name           string
+	AppDisplayName string
+	DataType       ExtensionDataType
+	MultiValued    bool
+	TargetObjects  []string
+}
+
+// ExtensionPropertyName is the full name of the directory extension property of the
+// application, extension_{appId without dashes}_{name}. Full names are returned as is.
+func ExtensionPropertyName(appId string, name string) string {
+	if strings.HasPrefix(name, "extension_") {
+		return name
+	}
+	return "extension_" + strings.ReplaceAll(appId, "-", "") + "_" + name
+}
+
+// extensionName expands a short extension name with the configured ExtensionAppID
+// extensionShortName returns the name an extension property is registered with, the name
+// without the extension_{appId}_ prefix. Returns "" when the name has the prefix of another
+// application.
+func extensionShortName(appId string, name string) string {
+	if !strings.HasPrefix(name, "extension_") {
+		return name
+	}
+	prefix := "extension_" + strings.ReplaceAll(appId, "-", "") + "_"
+	if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+		return ""
+	}
+	return name[len(prefix):]
+}
+
+func (graph *MsGraph) extensionName(name string) string {
+	if graph.Cfg == nil || graph.Cfg.ExtensionAppID == "" {
+		return name
+	}
+	return ExtensionPropertyName(graph.Cfg.ExtensionAppID, name)
+}
+
+func (graph *MsGraph) extensionNames(names []string) []string {
+	rtn := []string{}
+	for _, name := range names {
+		rtn = append(rtn, graph.extensionName(name))
+	}
+	return rtn
+}
+
+// ExtensionValues holds directory extension or open extension values keyed by name
+type ExtensionValues map[string]interface{}
+
+func (v ExtensionValues) String(name string) (string, bool) {
+	return toString(v[name])
+}
+
+func (v ExtensionValues) Int(name string) (int64, bool) {
+	switch i := v[name].(type) {
+	case int:
+		return int64(i), true
+	case int64:
+		return i, true
+	case float64:
+		return int64(i), true
+	case string:
+		n, err := strconv.ParseInt(i, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func (v ExtensionValues) Bool(name string) (bool, bool) {
+	return toBool(v[name])
+}
+
+// Time reads a DateTime extension, returned by Graph as an ISO 8601 string
+func (v ExtensionValues) Time(name string) (time.Time, bool) {
+	switch t := v[name].(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// Strings reads a multi valued extension
+func (v ExtensionValues) Strings(name string) ([]string, bool) {
+	items := toInterfaceSlice(v[name])
+	if items == nil {
+		return nil, false
+	}
+
+	rtn := []string{}
+	for _, item := range items {
+		s, ok := toString(item)
+		if !ok {
+			return nil, false
+		}
+		rtn = append(rtn, s)
+	}
+	return rtn, true
+}
+
+func (v ExtensionValues) SetString(name string, value string) {
+	v[name] = value
+}
+
+func (v ExtensionValues) SetInt(name string, value int64) {
+	v[name] = value
+}
+
+func (v ExtensionValues) SetBool(name string, value bool) {
+	v[name] = value
+}
+
+func (v ExtensionValues) SetTime(name string, value time.Time) {
+	v[name] = value.UTC().Format(time.RFC3339)
+}
+
+func (v ExtensionValues) SetStrings(name string, value []string) {
+	v[name] = value
+}
+
+// Clear removes the value when written
+func (v ExtensionValues) Clear(name string) {
+	v[name] = nil
+}
+
+// MsGraphExtensionManager registers directory extension properties on applications
+type MsGraphExtensionManager struct {
+	*MsGraph
+}
+
+func NewMsGraphExtensionManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphExtensionManager, error) {
+	em := &MsGraphExtensionManager{
+		MsGraph: &MsGraph{},
+	}
+	err := em.Configure(cfg)
+
+	return em, err
+}
+
+// ListExtensionProperties lists the directory extension properties registered on the
+// application with the app id. An empty app id uses the configured ExtensionAppID
+func (em *MsGraphExtensionManager) ListExtensionProperties(ctx context.Context, appId string) ([]*ExtensionProperty, error) {
+	objectId, err := em.applicationObjectId(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := em.Client.Applications().ByApplicationId(objectId).ExtensionProperties().Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] ListExtensionProperties Error: %s", appId, message)
+	}
+
+	rtn := []*ExtensionProperty{}
+	for _, p := range result.GetValue() {
+		rtn = append(rtn, ExtensionPropertyToCloudy(p))
+	}
+	return rtn, nil
+}
+
+// ListAvailableExtensionProperties lists all the directory extension properties registered in the tenant
+func (em *MsGraphExtensionManager) ListAvailableExtensionProperties(ctx context.Context) ([]*ExtensionProperty, error) {
+	body := directoryobjects.NewGetAvailableExtensionPropertiesPostRequestBody()
+	body.SetIsSyncedFromOnPremises(cloudy.BoolP(false))
+
+	result, err := em.Client.DirectoryObjects().GetAvailableExtensionProperties().PostAsGetAvailableExtensionPropertiesPostResponse(ctx, body, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListAvailableExtensionProperties Error: %s", message)
+	}
+
+	rtn := []*ExtensionProperty{}
+	for _, p := range result.GetValue() {
+		rtn = append(rtn, ExtensionPropertyToCloudy(p))
+	}
+	return rtn, nil
+}
+
+// RegisterExtensionProperty registers the extension property on the application with the
+// app id, using the short name of the property, a full extension_{appId}_ name is shortened.
+// Registering a property that already exists returns the existing property, an error is
+// returned if its data type differs.
+func (em *MsGraphExtensionManager) RegisterExtensionProperty(ctx context.Context, appId string, property *ExtensionProperty) (*ExtensionProperty, error) {
+	if appId == "" && em.Cfg != nil {
+		appId = em.Cfg.ExtensionAppID
+	}
+	name := ExtensionPropertyName(appId, property.Name)
+	short := extensionShortName(appId, property.Name)
+	if short == "" {
+		return nil, cloudy.Error(ctx, "[%s] RegisterExtensionProperty - not an extension property of %s", name, appId)
+	}
+
+	existing, err := em.ListExtensionProperties(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range existing {
+		if !strings.EqualFold(p.Name, name) {
+			continue
+		}
+
+		if p.DataType != property.DataType || p.MultiValued != property.MultiValued {
+			return nil, cloudy.Error(ctx, "[%s] RegisterExtensionProperty - already registered as %s", name, p.DataType)
+		}
+		return p, nil
+	}
+
+	objectId, err := em.applicationObjectId(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudy.Info(ctx, "[%s] RegisterExtensionProperty", name)
+
+	// Graph adds the extension_{appId}_ prefix to the registered name
+	body := *property
+	body.Name = short
+	created, err := em.Client.Applications().ByApplicationId(objectId).ExtensionProperties().Post(ctx, ExtensionPropertyToAzure(&body), nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] RegisterExtensionProperty Error: %s", name, message)
+	}
+
+	return ExtensionPropertyToCloudy(created), nil
+}
+
+func (em *MsGraphExtensionManager) applicationObjectId(ctx context.Context, appId string) (string, error) {
+	if appId == "" && em.Cfg != nil {
+		appId = em.Cfg.ExtensionAppID
+	}
+	if appId == "" {
+		return "", cloudy.Error(ctx, "no application id for the extension properties")
+	}
+
+	app, err := em.Client.ApplicationsWithAppId(&appId).Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return "", cloudy.Error(ctx, "[%s] application lookup Error: %s", appId, message)
+	}
+
+	return cloudy.StringFromP(app.GetId()), nil
+}
+
+func ExtensionPropertyToAzure(property *ExtensionProperty) *models.ExtensionProperty {
+	p := models.NewExtensionProperty()
+	p.SetName(cloudy.StringP(property.Name))
+	p.SetDataType(cloudy.StringP(string(property.DataType)))
+	p.SetIsMultiValued(cloudy.BoolP(property.MultiValued))
+
+	targets := property.TargetObjects
+	if len(targets) == 0 {
+		targets = []string{ExtensionTargetUser}
+	}
+	p.SetTargetObjects(targets)
+
+	return p
+}
+
+func ExtensionPropertyToCloudy(p models.ExtensionPropertyable) *ExtensionProperty {
+	return &ExtensionProperty{
+		ID:             cloudy.StringFromP(p.GetId()),
+		Name:           cloudy.StringFromP(p.GetName()),
+		AppDisplayName: cloudy.StringFromP(p.GetAppDisplayName()),
+		DataType:       ExtensionDataType(cloudy.StringFromP(p.GetDataType())),
+		MultiValued:    cloudy.BoolFromP(p.GetIsMultiValued()),
+		TargetObjects:  p.GetTargetObjects(),
+	}
+}
+
+// GetUserExtensions reads the directory extension properties of a user. Short names are
+// expanded with the configured ExtensionAppID. Properties without a value are not returned.
+func (um *MsGraphUserManager) GetUserExtensions(ctx context.Context, uid string, names ...string) (ExtensionValues, error) {
+	names = um.extensionNames(names)
+
+	result, err := um.Client.Users().ByUserId(uid).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+				Select: append([]string{"id"}, names...),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] GetUserExtensions Error: %s", uid, message)
+	}
+
+	return extensionValues(result.GetAdditionalData(), names), nil
+}
+
+// SetUserExtensions writes the directory extension properties of a user. Nil values are cleared
+func (um *MsGraphUserManager) SetUserExtensions(ctx context.Context, uid string, values ExtensionValues) error {
+	u := models.NewUser()
+	u.SetAdditionalData(um.extensionData(values))
+
+	_, err := um.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SetUserExtensions Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// GetUserOpenExtension reads the open extension of a user. Returns nil if the user does not have it
+func (um *MsGraphUserManager) GetUserOpenExtension(ctx context.Context, uid string, name string) (ExtensionValues, error) {
+	return getOpenExtension(ctx, um.userOpenExtensions(uid), uid, name)
+}
+
+// SetUserOpenExtension creates or updates the open extension of a user
+func (um *MsGraphUserManager) SetUserOpenExtension(ctx context.Context, uid string, name string, values ExtensionValues) error {
+	return setOpenExtension(ctx, um.userOpenExtensions(uid), uid, name, values)
+}
+
+func (um *MsGraphUserManager) DeleteUserOpenExtension(ctx context.Context, uid string, name string) error {
+	return deleteOpenExtension(ctx, um.userOpenExtensions(uid), uid, name)
+}
+
+// GetGroupExtensions reads the directory extension properties of a group. Short names are
+// expanded with the configured ExtensionAppID. Properties without a value are not returned.
+func (gm *MsGraphGroupManager) GetGroupExtensions(ctx context.Context, grpId string, names ...string) (ExtensionValues, error) {
+	names = gm.extensionNames(names)
+
+	result, err := gm.Client.Groups().ByGroupId(grpId).Get(ctx,
+		&groups.GroupItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &groups.GroupItemRequestBuilderGetQueryParameters{
+				Select: append([]string{"id"}, names...),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] GetGroupExtensions Error: %s", grpId, message)
+	}
+
+	return extensionValues(result.GetAdditionalData(), names), nil
+}
+
+// SetGroupExtensions writes the directory extension properties of a group. Nil values are cleared
+func (gm *MsGraphGroupManager) SetGroupExtensions(ctx context.Context, grpId string, values ExtensionValues) error {
+	g := models.NewGroup()
+	g.SetAdditionalData(gm.extensionData(values))
+
+	_, err := gm.Client.Groups().ByGroupId(grpId).Patch(ctx, g, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SetGroupExtensions Error: %s", grpId, message)
+	}
+
+	return nil
+}
+
+// GetGroupOpenExtension reads the open extension of a group. Returns nil if the group does not have it
+func (gm *MsGraphGroupManager) GetGroupOpenExtension(ctx context.Context, grpId string, name string) (ExtensionValues, error) {
+	return getOpenExtension(ctx, gm.groupOpenExtensions(grpId), grpId, name)
+}
+
+// SetGroupOpenExtension creates or updates the open extension of a group
+func (gm *MsGraphGroupManager) SetGroupOpenExtension(ctx context.Context, grpId string, name string, values ExtensionValues) error {
+	return setOpenExtension(ctx, gm.groupOpenExtensions(grpId), grpId, name, values)
+}
+
+func (gm *MsGraphGroupManager) DeleteGroupOpenExtension(ctx context.Context, grpId string, name string) error {
+	return deleteOpenExtension(ctx, gm.groupOpenExtensions(grpId), grpId, name)
+}
+
+// extensionData expands the names of the values for writing
+func (graph *MsGraph) extensionData(values ExtensionValues) map[string]interface{} {
+	data := make(map[string]interface{})
+	for name, value := range values {
+		data[graph.extensionName(name)] = value
+	}
+	return data
+}
+
+// extensionValues reads the named values out of the additional data
+func extensionValues(data map[string]interface{}, names []string) ExtensionValues {
+	rtn := ExtensionValues{}
+	for key, value := range data {
+		if !containsFold(names, key) || value == nil {
+			continue
+		}
+		rtn[key] = plainJSONValue(value)
+	}
+	return rtn
+}
+
+// openExtensions are the open extension requests of a user or group
+type openExtensions struct {
+	get    func(ctx context.Context, name string) (models.Extensionable, error)
+	post   func(ctx context.Context, ext models.Extensionable) error
+	patch  func(ctx context.Context, name string, ext models.Extensionable) error
+	delete func(ctx context.Context, name string) error
+}
+
+func (graph *MsGraph) userOpenExtensions(uid string) *openExtensions {
+	builder := graph.Client.Users().ByUserId(uid).Extensions()
+	return &openExtensions{
+		get: func(ctx context.Context, name string) (models.Extensionable, error) {
+			return builder.ByExtensionId(name).Get(ctx, nil)
+		},
+		post: func(ctx context.Context, ext models.Extensionable) error {
+			_, err := builder.Post(ctx, ext, nil)
+			return err
+		},
+		patch: func(ctx context.Context, name string, ext models.Extensionable) error {
+			_, err := builder.ByExtensionId(name).Patch(ctx, ext, nil)
+			return err
+		},
+		delete: func(ctx context.Context, name string) error {
+			return builder.ByExtensionId(name).Delete(ctx, nil)
+		},
+	}
+}
+
+func (graph *MsGraph) groupOpenExtensions(grpId string) *openExtensions {
+	builder := graph.Client.Groups().ByGroupId(grpId).Extensions()
+	return &openExtensions{
+		get: func(ctx context.Context, name string) (models.Extensionable, error) {
+			return builder.ByExtensionId(name).Get(ctx, nil)
+		},
+		post: func(ctx context.Context, ext models.Extensionable) error {
+			_, err := builder.Post(ctx, ext, nil)
+			return err
+		},
+		patch: func(ctx context.Context, name string, ext models.Extensionable) error {
+			_, err := builder.ByExtensionId(name).Patch(ctx, ext, nil)
+			return err
+		},
+		delete: func(ctx context.Context, name string) error {
+			return builder.ByExtensionId(name).Delete(ctx, nil)
+		},
+	}
+}
+
+func getOpenExtension(ctx context.Context, extensions *openExtensions, id string, name string) (ExtensionValues, error) {
+	result, err := extensions.get(ctx, name)
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			return nil, nil
+		}
+		return nil, cloudy.Error(ctx, "[%s] GetOpenExtension %s Error: %s", id, name, message)
+	}
+
+	return OpenExtensionToCloudy(result), nil
+}
+
+func setOpenExtension(ctx context.Context, extensions *openExtensions, id string, name string, values ExtensionValues) error {
+	existing, err := getOpenExtension(ctx, extensions, id, name)
+	if err != nil {
+		return err
+	}
+
+	ext := OpenExtensionToAzure(name, values)
+	if existing == nil {
+		err = extensions.post(ctx, ext)
+	} else {
+		err = extensions.patch(ctx, name, ext)
+	}
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SetOpenExtension %s Error: %s", id, name, message)
+	}
+
+	return nil
+}
+
+func deleteOpenExtension(ctx context.Context, extensions *openExtensions, id string, name string) error {
+	err := extensions.delete(ctx, name)
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			return nil
+		}
+		return cloudy.Error(ctx, "[%s] DeleteOpenExtension %s Error: %s", id, name, message)
+	}
+	return nil
+}
+
+func OpenExtensionToAzure(name string, values ExtensionValues) *models.OpenTypeExtension {
+	ext := models.NewOpenTypeExtension()
+	ext.SetExtensionName(&name)
+
+	data := make(map[string]interface{})
+	for key, value := range values {
+		data[key] = value
+	}
+	ext.SetAdditionalData(data)
+
+	return ext
+}
+
+// OpenExtensionToCloudy returns the free form values of the open extension
+func OpenExtensionToCloudy(ext models.Extensionable) ExtensionValues {
+	rtn := ExtensionValues{}
+	for key, value := range ext.GetAdditionalData() {
+		if key == "extensionName" || strings.HasPrefix(key, "@odata") {
+			continue
+		}
+		rtn[key] = plainJSONValue(value)
+	}
+	return rtn
+}
+
+// plainJSONValue dereferences the values parsed by kiota into plain JSON values
+func plainJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *string:
+		if v != nil {
+			return *v
+		}
+	case *bool:
+		if v != nil {
+			return *v
+		}
+	case *int32:
+		if v != nil {
+			return int64(*v)
+		}
+	case *int64:
+		if v != nil {
+			return *v
+		}
+	case *float64:
+		if v != nil {
+			return *v
+		}
+	case []interface{}:
+		rtn := []interface{}{}
+		for _, item := range v {
+			rtn = append(rtn, plainJSONValue(item))
+		}
+		return rtn
+	case map[string]interface{}:
+		rtn := make(map[string]interface{})
+		for key, item := range v {
+			rtn[key] = plainJSONValue(item)
+		}
+		return rtn
+	default:
+		return value
+	}
+	return nil
+}
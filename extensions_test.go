@@ -0,0 +1,99 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionPropertyName(t *testing.T) {
+	appId := "2f1c9a5e-7b1d-4a8e-9c3f-0d6e5b4a3c21"
+	assert.Equal(t, "extension_2f1c9a5e7b1d4a8e9c3f0d6e5b4a3c21_costCenter", ExtensionPropertyName(appId, "costCenter"))
+	assert.Equal(t, "extension_abc_costCenter", ExtensionPropertyName(appId, "extension_abc_costCenter"))
+
+	assert.Equal(t, "costCenter", extensionShortName(appId, "costCenter"))
+	assert.Equal(t, "costCenter", extensionShortName(appId, "extension_2f1c9a5e7b1d4a8e9c3f0d6e5b4a3c21_costCenter"))
+	assert.Equal(t, "", extensionShortName(appId, "extension_abc_costCenter"))
+
+	graph := &MsGraph{Cfg: &MsGraphConfig{ExtensionAppID: appId, ExtensionProperties: []string{"costCenter"}}}
+	assert.Contains(t, graph.userSelectFields(context.Background(), nil), "extension_2f1c9a5e7b1d4a8e9c3f0d6e5b4a3c21_costCenter")
+}
+
+func TestExtensionValues(t *testing.T) {
+	count := int64(42)
+	score := float64(7)
+	data := map[string]interface{}{
+		"extension_abc_costCenter": cloudy.StringP("CC-100"),
+		"extension_abc_level":      &count,
+		"extension_abc_score":      &score,
+		"extension_abc_active":     cloudy.BoolP(true),
+		"extension_abc_since":      cloudy.StringP("2024-01-15T00:00:00Z"),
+		"extension_abc_sites":      []interface{}{cloudy.StringP("Rome"), cloudy.StringP("Dayton")},
+		"extension_abc_other":      cloudy.StringP("not requested"),
+	}
+
+	values := extensionValues(data, []string{
+		"extension_abc_costCenter", "extension_abc_level", "extension_abc_score",
+		"extension_abc_active", "extension_abc_since", "extension_abc_sites",
+	})
+	assert.Len(t, values, 6)
+
+	s, ok := values.String("extension_abc_costCenter")
+	assert.True(t, ok)
+	assert.Equal(t, "CC-100", s)
+
+	i, ok := values.Int("extension_abc_level")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), i)
+
+	i, ok = values.Int("extension_abc_score")
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), i)
+
+	b, ok := values.Bool("extension_abc_active")
+	assert.True(t, ok)
+	assert.True(t, b)
+
+	since, ok := values.Time("extension_abc_since")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), since)
+
+	sites, ok := values.Strings("extension_abc_sites")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Rome", "Dayton"}, sites)
+
+	_, ok = values.String("extension_abc_missing")
+	assert.False(t, ok)
+
+	update := ExtensionValues{}
+	update.SetTime("since", since)
+	update.Clear("costCenter")
+
+	graph := &MsGraph{Cfg: &MsGraphConfig{ExtensionAppID: "abc"}}
+	assert.Equal(t, map[string]interface{}{
+		"extension_abc_costCenter": nil,
+		"extension_abc_since":      "2024-01-15T00:00:00Z",
+	}, graph.extensionData(update))
+}
+
+func TestOpenExtensionModel(t *testing.T) {
+	ext := OpenExtensionToAzure("com.example.profile", ExtensionValues{"theme": "dark", "pinned": []string{"a", "b"}})
+	assert.Equal(t, "com.example.profile", *ext.GetExtensionName())
+
+	// As parsed from Graph
+	ext.SetAdditionalData(map[string]interface{}{
+		"extensionName": cloudy.StringP("com.example.profile"),
+		"@odata.type":   cloudy.StringP("#microsoft.graph.openTypeExtension"),
+		"theme":         cloudy.StringP("dark"),
+		"layout":        map[string]interface{}{"columns": cloudy.StringP("2")},
+	})
+
+	values := OpenExtensionToCloudy(ext)
+	assert.Equal(t, ExtensionValues{
+		"theme":  "dark",
+		"layout": map[string]interface{}{"columns": "2"},
+	}, values)
+}
@@ -196,7 +196,7 @@ func (gm *MsGraphGroupManager) GetGroupMembers(ctx context.Context, grpId string
 	for _, dirObj := range dirObjects {
 		switch data := dirObj.(type) {
 		case graphmodels.Userable:
-			rtn = append(rtn, UserToCloudy(data))
+			rtn = append(rtn, gm.userToCloudy(data))
 			// default:
 			// 	cloudy.Info(ctx, "Non-User directory object: %T", dirObj)
 		}
@@ -0,0 +1,281 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// InvitationState is the externalUserState of a guest
+type InvitationState string
+
+const (
+	InvitationStatePending  InvitationState = "PendingAcceptance"
+	InvitationStateAccepted InvitationState = "Accepted"
+)
+
+const (
+	UserTypeGuest  = "Guest"
+	UserTypeMember = "Member"
+)
+
+var ErrInvitationAccepted = errors.New("the guest has already accepted the invitation")
+var ErrNotGuest = errors.New("the user is not a guest")
+
+// Guest is a user invited from outside the tenant
+type Guest struct {
+	User *cloudymodels.User
+
+	// State of the invitation, empty for guests that were not invited
+	State InvitationState
+
+	// StateChanged is when the state of the invitation last changed
+	StateChanged time.Time
+
+	// Created is when the guest was invited
+	Created time.Time
+
+	// Email the guest was invited with and its domain
+	Email  string
+	Domain string
+
+	// UserType is Guest, or Member for an invited user converted to a member
+	UserType string
+}
+
+// ListGuestsOptions filters the guests
+type ListGuestsOptions struct {
+	// State only lists the guests in the state
+	State InvitationState
+
+	// Domain only lists the guests invited with an email of the domain
+	Domain string
+
+	// UserType only lists the invited users of the type, UserTypeGuest or UserTypeMember.
+	// Empty lists the guests and the invited users converted to members
+	UserType string
+}
+
+// guestSelectFields are the guest properties read along with the user properties
+var guestSelectFields = []string{"userType", "externalUserState", "externalUserStateChangeDateTime", "createdDateTime", "otherMails"}
+
+// ListGuests returns the invited users of the tenant, including the ones converted to
+// members, reading every page
+func (im *MsGraphInviteManager) ListGuests(ctx context.Context, opts *ListGuestsOptions) ([]*Guest, error) {
+	if opts == nil {
+		opts = &ListGuestsOptions{}
+	}
+
+	filter := guestFilter(opts)
+	cloudy.Info(ctx, "ListGuests %s", filter)
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	count := true
+
+	result, err := im.Client.Users().Get(ctx,
+		&users.UsersRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.UsersRequestBuilderGetQueryParameters{
+				Filter: &filter,
+				Count:  &count,
+				Select: im.userSelectFields(ctx, nil, guestSelectFields...),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListGuests Error: %s", message)
+	}
+
+	rtn := []*Guest{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, im.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
+		rtn = append(rtn, im.guestToCloudy(pageItem))
+		return true
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "ListGuests Error: %s", message)
+	}
+
+	return rtn, nil
+}
+
+// GetGuest returns the guest, nil when the user does not exist
+func (im *MsGraphInviteManager) GetGuest(ctx context.Context, uid string) (*Guest, error) {
+	result, err := im.Client.Users().ByUserId(uid).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+				Select: im.userSelectFields(ctx, nil, guestSelectFields...),
+			},
+		})
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Info(ctx, "[%s] GetGuest - ResourceNotFound - %s", uid, message)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetGuest Error: %s", uid, message)
+	}
+
+	return im.guestToCloudy(result), nil
+}
+
+// ResendInvitation sends the invitation email again to a guest that has not accepted it
+func (im *MsGraphInviteManager) ResendInvitation(ctx context.Context, uid string, inviteRedirectUrl string) error {
+	cloudy.Info(ctx, "[%s] ResendInvitation", uid)
+
+	guest, err := im.requireGuest(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if guest.State == InvitationStateAccepted {
+		cloudy.Warn(ctx, "[%s] ResendInvitation - %v", uid, ErrInvitationAccepted)
+		return ErrInvitationAccepted
+	}
+
+	body := guestInvitation(guest, inviteRedirectUrl)
+	_, err = im.Client.Invitations().Post(ctx, body, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] ResendInvitation Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// ResetRedemption resets the redemption of the guest, so they accept a new invitation with
+// a different identity, e.g. when their email moved to another provider. The invitation
+// email is sent to the email of the guest.
+func (im *MsGraphInviteManager) ResetRedemption(ctx context.Context, uid string, inviteRedirectUrl string) error {
+	cloudy.Info(ctx, "[%s] ResetRedemption", uid)
+
+	guest, err := im.requireGuest(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	body := guestInvitation(guest, inviteRedirectUrl)
+	body.SetResetRedemption(cloudy.BoolP(true))
+	_, err = im.Client.Invitations().Post(ctx, body, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] ResetRedemption Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// ConvertToMember makes the invited user a member of the tenant. They keep signing in with
+// their external identity.
+func (im *MsGraphInviteManager) ConvertToMember(ctx context.Context, uid string) error {
+	return im.setUserType(ctx, uid, UserTypeMember)
+}
+
+// ConvertToGuest makes the user a guest of the tenant
+func (im *MsGraphInviteManager) ConvertToGuest(ctx context.Context, uid string) error {
+	return im.setUserType(ctx, uid, UserTypeGuest)
+}
+
+func (im *MsGraphInviteManager) setUserType(ctx context.Context, uid string, userType string) error {
+	cloudy.Info(ctx, "[%s] ConvertTo%s", uid, userType)
+
+	u := models.NewUser()
+	u.SetUserType(&userType)
+	_, err := im.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] ConvertTo%s Error: %s", uid, userType, message)
+	}
+
+	return nil
+}
+
+// requireGuest returns the guest, an error when the user does not exist or was not invited
+func (im *MsGraphInviteManager) requireGuest(ctx context.Context, uid string) (*Guest, error) {
+	guest, err := im.GetGuest(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if guest == nil {
+		return nil, cloudy.Error(ctx, "[%s] guest not found", uid)
+	}
+	if guest.State == "" {
+		cloudy.Warn(ctx, "[%s] %v", uid, ErrNotGuest)
+		return nil, ErrNotGuest
+	}
+	return guest, nil
+}
+
+func (im *MsGraphInviteManager) guestToCloudy(user models.Userable) *Guest {
+	rtn := &Guest{
+		User:     im.userToCloudy(user),
+		State:    InvitationState(cloudy.StringFromP(user.GetExternalUserState())),
+		UserType: cloudy.StringFromP(user.GetUserType()),
+	}
+	if user.GetExternalUserStateChangeDateTime() != nil {
+		rtn.StateChanged = *user.GetExternalUserStateChangeDateTime()
+	}
+	if user.GetCreatedDateTime() != nil {
+		rtn.Created = *user.GetCreatedDateTime()
+	}
+
+	rtn.Email = guestEmail(rtn.User, user.GetOtherMails())
+	rtn.Domain = emailDomain(rtn.Email)
+	return rtn
+}
+
+// guestEmail returns the email the guest was invited with
+func guestEmail(user *cloudymodels.User, otherMails []string) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	if len(otherMails) > 0 {
+		return otherMails[0]
+	}
+	return ""
+}
+
+// guestInvitation returns the invitation of the existing guest
+func guestInvitation(guest *Guest, inviteRedirectUrl string) models.Invitationable {
+	invitedUser := models.NewUser()
+	invitedUser.SetId(&guest.User.ID)
+
+	body := invitationBody(guest.Email, guest.User.DisplayName, &InvitationOptions{
+		SendEmail:   true,
+		RedirectUrl: inviteRedirectUrl,
+		UserType:    guest.UserType,
+	})
+	body.SetInvitedUser(invitedUser)
+	return body
+}
+
+// guestFilter returns the $filter of the guests matching the options
+func guestFilter(opts *ListGuestsOptions) string {
+	clauses := []string{"externalUserState ne null"}
+	if opts.State != "" {
+		clauses = []string{fmt.Sprintf("externalUserState eq '%s'", odataString(string(opts.State)))}
+	}
+	if opts.UserType != "" {
+		clauses = append(clauses, fmt.Sprintf("userType eq '%s'", odataString(opts.UserType)))
+	}
+	if opts.Domain != "" {
+		clauses = append(clauses, fmt.Sprintf("endswith(mail,'@%s')", odataString(strings.ToLower(opts.Domain))))
+	}
+	return strings.Join(clauses, " and ")
+}
@@ -0,0 +1,52 @@
+package cloudymsgraph
+
+import (
+	"testing"
+	"time"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuestFilter(t *testing.T) {
+	// invited users converted to members are listed too
+	assert.Equal(t, "externalUserState ne null", guestFilter(&ListGuestsOptions{}))
+	assert.Equal(t, "externalUserState eq 'PendingAcceptance' and endswith(mail,'@partner.com')",
+		guestFilter(&ListGuestsOptions{State: InvitationStatePending, Domain: "Partner.com"}))
+	assert.Equal(t, "externalUserState ne null and userType eq 'Member'", guestFilter(&ListGuestsOptions{UserType: UserTypeMember}))
+}
+
+func TestGuestToCloudy(t *testing.T) {
+	id, state, userType := "g", "PendingAcceptance", "Guest"
+	changed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	u := models.NewUser()
+	u.SetId(&id)
+	u.SetExternalUserState(&state)
+	u.SetExternalUserStateChangeDateTime(&changed)
+	u.SetUserType(&userType)
+	u.SetOtherMails([]string{"jane@Partner.com"})
+
+	im := &MsGraphInviteManager{MsGraph: &MsGraph{}}
+	guest := im.guestToCloudy(u)
+	assert.Equal(t, "g", guest.User.ID)
+	assert.Equal(t, InvitationStatePending, guest.State)
+	assert.Equal(t, changed, guest.StateChanged)
+	assert.Equal(t, "jane@Partner.com", guest.Email)
+	assert.Equal(t, "partner.com", guest.Domain)
+	assert.Equal(t, UserTypeGuest, guest.UserType)
+
+	mail := "jane@other.com"
+	u.SetMail(&mail)
+	assert.Equal(t, "other.com", im.guestToCloudy(u).Domain)
+}
+
+func TestGuestInvitation(t *testing.T) {
+	guest := &Guest{User: &cloudymodels.User{ID: "g", DisplayName: "Jane"}, Email: "jane@partner.com"}
+	body := guestInvitation(guest, "https://example.com")
+	assert.Equal(t, "g", *body.GetInvitedUser().GetId())
+	assert.Equal(t, "jane@partner.com", *body.GetInvitedUserEmailAddress())
+	assert.Equal(t, "https://example.com", *body.GetInviteRedirectUrl())
+	assert.True(t, *body.GetSendInvitationMessage())
+}
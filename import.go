@@ -0,0 +1,533 @@
+package cloudymsgraph
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+)
+
+// ImportFormat is the format of a bulk import file
+type ImportFormat string
+
+const (
+	ImportFormatCSV       ImportFormat = "csv"
+	ImportFormatJSONLines ImportFormat = "jsonl"
+)
+
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+)
+
+const (
+	ImportStatusCreated = "created"
+	ImportStatusUpdated = "updated"
+	ImportStatusDryRun  = "dry-run"
+	ImportStatusInvalid = "invalid"
+	ImportStatusFailed  = "failed"
+)
+
+// DefaultImportConcurrency is the number of rows imported at once
+const DefaultImportConcurrency = 4
+
+const importGeneratedPasswordLength = 16
+
+var ErrUnknownImportFormat = errors.New("unknown import format")
+
+// ImportRow is a row of an import file. Values are keyed by column name, a column is
+// either a Graph user property (e.g. givenName), a field of the cloudy User (e.g.
+// FirstName) or the name of an entry in the user attributes map.
+type ImportRow struct {
+	Line   int
+	Values map[string]interface{}
+}
+
+// ImportOptions controls a bulk import
+type ImportOptions struct {
+	Format ImportFormat
+
+	// DryRun validates the rows, including their domains, and reports what would be done
+	// and the changes of the updates without changing anything
+	DryRun bool
+
+	// UpdateExisting updates the users that already exist instead of reporting them as invalid.
+	// Only the columns in the row are changed.
+	UpdateExisting bool
+
+	// AllowedDomains are the domains user principal names may use. Empty allows any domain
+	AllowedDomains []string
+
+	// AllowedValues restricts the values of custom security attributes, keyed by
+	// {attributeSet}.{attribute}
+	AllowedValues map[string][]string
+
+	// Concurrency is the number of rows processed at once. Defaults to DefaultImportConcurrency
+	Concurrency int
+
+	// GeneratePasswords sets a generated password, changed at the next sign in, for
+	// created users that have no password in the file
+	GeneratePasswords bool
+
+	// IncludePasswords writes the generated passwords to the results
+	IncludePasswords bool
+}
+
+// ImportResult is the outcome of a row
+type ImportResult struct {
+	Line     int
+	UPN      string
+	Action   string
+	Status   string
+	ID       string
+	Password string
+	Errors   []string
+
+	// Changes are the properties an update changes, or would change in a dry run
+	Changes []*UserChange
+}
+
+// ImportReport summarizes a bulk import. Results are ordered by line.
+type ImportReport struct {
+	DryRun  bool
+	Total   int
+	Created int
+	Updated int
+	Invalid int
+	Failed  int
+	Results []*ImportResult
+}
+
+// ImportUsers reads the users from the file, validates every row and creates or updates
+// them. Rows are processed concurrently. Rows that are not valid are reported and skipped,
+// an error is only returned when the file cannot be read.
+func (um *MsGraphUserManager) ImportUsers(ctx context.Context, r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	rows, err := ReadImportRows(r, opts.Format)
+	if err != nil {
+		return nil, cloudy.Error(ctx, "ImportUsers - unable to read the file: %v", err)
+	}
+	cloudy.Info(ctx, "ImportUsers %d rows, dry run: %v", len(rows), opts.DryRun)
+
+	mappings := um.customSecurityAttributes()
+	results := make([]*ImportResult, len(rows))
+
+	// Duplicates in the file are found before any row is processed
+	seen := map[string]int{}
+	for i, row := range rows {
+		user, attributes := importUser(row.Values, nil)
+		results[i] = &ImportResult{Line: row.Line, UPN: user.UPN}
+		results[i].Errors = validateImportUser(user, attributes, mappings, opts)
+
+		upn := strings.ToLower(user.UPN)
+		if upn == "" {
+			continue
+		}
+		if line, ok := seen[upn]; ok {
+			results[i].Errors = append(results[i].Errors, fmt.Sprintf("duplicate of line %d", line))
+		} else {
+			seen[upn] = row.Line
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultImportConcurrency
+	}
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, concurrency)
+	for i, row := range rows {
+		if len(results[i].Errors) > 0 {
+			results[i].Status = ImportStatusInvalid
+			continue
+		}
+
+		// Rows not started when the context is done are reported as failed
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Status = ImportStatusFailed
+			results[i].Errors = append(results[i].Errors, ctx.Err().Error())
+			continue
+		}
+
+		wg.Add(1)
+		go func(row *ImportRow, result *ImportResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			um.importRow(ctx, row, result, opts)
+		}(row, results[i])
+	}
+	wg.Wait()
+
+	report := &ImportReport{
+		DryRun:  opts.DryRun,
+		Total:   len(rows),
+		Results: results,
+	}
+	for _, result := range results {
+		switch result.Status {
+		case ImportStatusCreated:
+			report.Created++
+		case ImportStatusUpdated:
+			report.Updated++
+		case ImportStatusInvalid:
+			report.Invalid++
+		case ImportStatusFailed:
+			report.Failed++
+		}
+	}
+
+	cloudy.Info(ctx, "ImportUsers %d rows: %d created, %d updated, %d invalid, %d failed",
+		report.Total, report.Created, report.Updated, report.Invalid, report.Failed)
+	return report, nil
+}
+
+// importRow checks the user against the tenant and creates or updates it
+func (um *MsGraphUserManager) importRow(ctx context.Context, row *ImportRow, result *ImportResult, opts *ImportOptions) {
+	existing, existingAttributes, err := um.GetUserWithAttributes(ctx, result.UPN)
+	if err != nil {
+		result.Status = ImportStatusFailed
+		result.Errors = append(result.Errors, err.Error())
+		return
+	}
+
+	if existing != nil {
+		if !opts.UpdateExisting {
+			result.Status = ImportStatusInvalid
+			result.Errors = append(result.Errors, fmt.Sprintf("user already exists: %s", existing.ID))
+			return
+		}
+
+		result.Action = ImportActionUpdate
+		result.ID = existing.ID
+
+		// The row is applied over the current user so that missing columns are kept
+		base := *existing
+		user, attributes := importUser(row.Values, &base)
+		user.ID = existing.ID
+
+		if opts.DryRun {
+			_, changes, err := um.userUpdatePatch(ctx, user, attributes, existing, existingAttributes)
+			if err != nil {
+				result.Status = importErrorStatus(err)
+				result.Errors = append(result.Errors, err.Error())
+				return
+			}
+			result.Changes = changes
+			result.Status = ImportStatusDryRun
+			return
+		}
+
+		changes, err := um.updateUserWithChanges(ctx, user, attributes, existing, existingAttributes)
+		result.Changes = changes
+		if err != nil {
+			result.Status = importErrorStatus(err)
+			result.Errors = append(result.Errors, err.Error())
+			return
+		}
+
+		result.Status = ImportStatusUpdated
+		return
+	}
+
+	result.Action = ImportActionCreate
+	user, attributes := importUser(row.Values, nil)
+	missing := missingImportFields(user, attributes)
+	if len(missing) > 0 {
+		result.Status = ImportStatusInvalid
+		result.Errors = append(result.Errors, missing...)
+		return
+	}
+
+	if opts.DryRun {
+		err = um.validateUserDomains(ctx, user)
+		if err != nil {
+			result.Status = importErrorStatus(err)
+			result.Errors = append(result.Errors, err.Error())
+			return
+		}
+		result.Status = ImportStatusDryRun
+		return
+	}
+
+	if user.Password == "" && opts.GeneratePasswords {
+		user.Password = cloudy.GeneratePassword(importGeneratedPasswordLength, 2, 2, 2)
+		user.MustChangePassword = true
+		if opts.IncludePasswords {
+			result.Password = user.Password
+		}
+	}
+
+	created, _, err := um.NewUserWithAttributes(ctx, user, attributes)
+	if err != nil {
+		result.Status = importErrorStatus(err)
+		result.Errors = append(result.Errors, err.Error())
+		return
+	}
+
+	result.ID = created.ID
+	result.Status = ImportStatusCreated
+}
+
+// importErrorStatus returns the status of a row that failed, invalid when the user uses a
+// domain of the tenant that is unknown or not verified
+func importErrorStatus(err error) string {
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		return ImportStatusInvalid
+	}
+	return ImportStatusFailed
+}
+
+// validateImportUser checks the user principal name identifying the row, its domain and the
+// custom security attribute values. The other required fields are only checked for the rows
+// that create a user, see missingImportFields.
+func validateImportUser(user *cloudymodels.User, attributes map[string]interface{}, mappings CustomSecurityAttributeMappings, opts *ImportOptions) []string {
+	errs := []string{}
+
+	if strings.TrimSpace(user.UPN) == "" {
+		errs = append(errs, "userPrincipalName is required")
+	} else {
+		at := strings.LastIndex(user.UPN, "@")
+		if at <= 0 || at == len(user.UPN)-1 {
+			errs = append(errs, fmt.Sprintf("invalid userPrincipalName %s", user.UPN))
+		} else if len(opts.AllowedDomains) > 0 && !containsFold(opts.AllowedDomains, user.UPN[at+1:]) {
+			errs = append(errs, fmt.Sprintf("domain %s is not allowed", user.UPN[at+1:]))
+		}
+	}
+
+	for _, m := range mappings {
+		name := m.AttributeSet + "." + m.Attribute
+
+		var value interface{}
+		var ok bool
+		if m.Field != "" {
+			field := userField(user, m.Field)
+			if field == "" {
+				continue
+			}
+			value, ok = m.fromField(field)
+		} else {
+			raw, exists := attributes[m.key()]
+			if !exists || raw == nil || raw == "" {
+				continue
+			}
+			value, ok = m.fromValue(raw)
+		}
+
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s is not a valid %s", name, m.Type))
+			continue
+		}
+
+		allowed := opts.AllowedValues[name]
+		if len(allowed) == 0 {
+			continue
+		}
+		for _, v := range strings.Split(m.toField(value), ",") {
+			if !containsFold(allowed, v) {
+				errs = append(errs, fmt.Sprintf("%s value %s is not allowed", name, v))
+			}
+		}
+	}
+
+	return errs
+}
+
+// missingImportFields returns an error for each required field the new user is missing
+func missingImportFields(user *cloudymodels.User, attributes map[string]interface{}) []string {
+	errs := []string{}
+	for _, m := range DefaultUserFieldMappings {
+		if !m.Required {
+			continue
+		}
+		value, _ := m.fromCloudy(user, attributes)
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Sprintf("%s is required", m.Property))
+		}
+	}
+	return errs
+}
+
+// importUser applies the row values to the user, a new user when nil, and returns the user
+// and the values that go in the attributes map
+func importUser(values map[string]interface{}, user *cloudymodels.User) (*cloudymodels.User, map[string]interface{}) {
+	if user == nil {
+		user = &cloudymodels.User{}
+	}
+	attributes := map[string]interface{}{}
+
+	userType := reflect.TypeOf(*user)
+	for column, raw := range values {
+		if m := DefaultUserFieldMappings.find(column); m != nil {
+			if m.ReadOnly {
+				continue
+			}
+			if m.Field != "" {
+				setUserField(user, m.Field, importString(raw))
+			} else {
+				attributes[m.Property] = importString(raw)
+			}
+			continue
+		}
+
+		if f, ok := userType.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, column) }); ok {
+			if f.Name != "ID" {
+				setUserField(user, f.Name, importString(raw))
+			}
+			continue
+		}
+
+		attributes[column] = raw
+	}
+
+	return user, attributes
+}
+
+// find returns the mapping of the property, nil when it is not mapped
+func (mappings UserFieldMappings) find(property string) *UserFieldMapping {
+	for _, m := range mappings {
+		if strings.EqualFold(m.Property, property) {
+			return m
+		}
+	}
+	return nil
+}
+
+func importString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// ReadImportRows reads the rows of a CSV file, with a header row, or a JSON Lines file.
+// Empty CSV cells and blank lines are skipped.
+func ReadImportRows(r io.Reader, format ImportFormat) ([]*ImportRow, error) {
+	switch format {
+	case ImportFormatCSV, "":
+		return readImportCSV(r)
+	case ImportFormatJSONLines:
+		return readImportJSONLines(r)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownImportFormat, format)
+}
+
+func readImportCSV(r io.Reader) ([]*ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []*ImportRow{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	rows := []*ImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := &ImportRow{Line: line, Values: map[string]interface{}{}}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i < len(header) && header[i] != "" && value != "" {
+				row.Values[header[i]] = value
+			}
+		}
+		if len(row.Values) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+func readImportJSONLines(r io.Reader) ([]*ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []*ImportRow{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		values := map[string]interface{}{}
+		err := json.Unmarshal([]byte(text), &values)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, &ImportRow{Line: line, Values: values})
+	}
+
+	return rows, scanner.Err()
+}
+
+// WriteImportResults writes the results as CSV, one row per line of the import file
+func WriteImportResults(w io.Writer, results []*ImportResult) error {
+	sorted := append([]*ImportResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Line < sorted[j].Line })
+
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"line", "userPrincipalName", "action", "status", "id", "password", "errors"})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range sorted {
+		err = writer.Write([]string{
+			strconv.Itoa(result.Line),
+			result.UPN,
+			result.Action,
+			result.Status,
+			result.ID,
+			result.Password,
+			strings.Join(result.Errors, "; "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,123 @@
+package cloudymsgraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadImportRows(t *testing.T) {
+	csvFile := "\ufeffuserPrincipalName,DisplayName,givenName,city,Sponsor\n" +
+		"john.doe@example.com,John Doe,John,Rome,jane.doe\n" +
+		",,,,\n" +
+		"jane.doe@example.com, Jane Doe ,,,\n"
+
+	rows, err := ReadImportRows(strings.NewReader(csvFile), ImportFormatCSV)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, map[string]interface{}{
+		"userPrincipalName": "john.doe@example.com",
+		"DisplayName":       "John Doe",
+		"givenName":         "John",
+		"city":              "Rome",
+		"Sponsor":           "jane.doe",
+	}, rows[0].Values)
+	assert.Equal(t, 4, rows[1].Line)
+	assert.Equal(t, map[string]interface{}{
+		"userPrincipalName": "jane.doe@example.com",
+		"DisplayName":       "Jane Doe",
+	}, rows[1].Values)
+
+	jsonFile := `{"userPrincipalName":"john.doe@example.com","Enabled":true}
+
+{"userPrincipalName":"jane.doe@example.com","Clearance":3}
+`
+	rows, err = ReadImportRows(strings.NewReader(jsonFile), ImportFormatJSONLines)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, 3, rows[1].Line)
+	assert.Equal(t, float64(3), rows[1].Values["Clearance"])
+
+	_, err = ReadImportRows(strings.NewReader("{"), ImportFormatJSONLines)
+	assert.Error(t, err)
+
+	_, err = ReadImportRows(strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownImportFormat)
+}
+
+func TestImportUser(t *testing.T) {
+	values := map[string]interface{}{
+		"userPrincipalName": "john.doe@example.com",
+		"DisplayName":       "John Doe",
+		"surname":           "Doe",
+		"enabled":           true,
+		"city":              "Rome",
+		"Sponsor":           "jane.doe",
+		"id":                "ignored",
+		"ID":                "ignored",
+	}
+
+	user, attributes := importUser(values, nil)
+	assert.Equal(t, &cloudymodels.User{
+		UPN:         "john.doe@example.com",
+		DisplayName: "John Doe",
+		LastName:    "Doe",
+		Enabled:     true,
+	}, user)
+	assert.Equal(t, map[string]interface{}{"city": "Rome", "Sponsor": "jane.doe"}, attributes)
+
+	// Values are applied over an existing user
+	existing := &cloudymodels.User{ID: "i", UPN: "john.doe@example.com", FirstName: "John", LastName: "Smith"}
+	user, _ = importUser(map[string]interface{}{"surname": "Doe"}, existing)
+	assert.Equal(t, "i", user.ID)
+	assert.Equal(t, "John", user.FirstName)
+	assert.Equal(t, "Doe", user.LastName)
+}
+
+func TestValidateImportUser(t *testing.T) {
+	mappings := CustomSecurityAttributeMappings{
+		{Field: "AccountType", AttributeSet: "cloudy", Attribute: "AccountType", Type: CustomSecurityAttributeString},
+		{AttributeSet: "cloudy", Attribute: "Clearance", Type: CustomSecurityAttributeInteger},
+	}
+	opts := &ImportOptions{
+		AllowedDomains: []string{"example.com"},
+		AllowedValues:  map[string][]string{"cloudy.AccountType": {"Employee", "Contractor"}},
+	}
+
+	user := &cloudymodels.User{UPN: "john.doe@example.com", DisplayName: "John Doe", AccountType: "contractor"}
+	assert.Empty(t, validateImportUser(user, map[string]interface{}{"Clearance": float64(3)}, mappings, opts))
+
+	user = &cloudymodels.User{UPN: "john.doe@other.com", AccountType: "Vendor"}
+	errs := validateImportUser(user, map[string]interface{}{"Clearance": "high"}, mappings, opts)
+	assert.Equal(t, []string{
+		"domain other.com is not allowed",
+		"cloudy.AccountType value Vendor is not allowed",
+		"cloudy.Clearance is not a valid Integer",
+	}, errs)
+
+	errs = validateImportUser(&cloudymodels.User{UPN: "john.doe", DisplayName: "John Doe"}, nil, mappings, &ImportOptions{})
+	assert.Equal(t, []string{"invalid userPrincipalName john.doe"}, errs)
+
+	// rows updating a user only need the user principal name
+	errs = validateImportUser(&cloudymodels.User{JobTitle: "Engineer"}, nil, mappings, &ImportOptions{})
+	assert.Equal(t, []string{"userPrincipalName is required"}, errs)
+
+	assert.Equal(t, []string{"displayName is required"}, missingImportFields(user, nil))
+	assert.Empty(t, missingImportFields(&cloudymodels.User{UPN: "john.doe@example.com", DisplayName: "John Doe"}, nil))
+}
+
+func TestWriteImportResults(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteImportResults(buf, []*ImportResult{
+		{Line: 3, UPN: "jane.doe@example.com", Status: ImportStatusInvalid, Errors: []string{"a", "b"}},
+		{Line: 2, UPN: "john.doe@example.com", Action: ImportActionCreate, Status: ImportStatusCreated, ID: "i", Password: "p"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "line,userPrincipalName,action,status,id,password,errors\n"+
+		"2,john.doe@example.com,create,created,i,p,\n"+
+		"3,jane.doe@example.com,,invalid,,,a; b\n", buf.String())
+}
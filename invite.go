@@ -2,10 +2,14 @@ package cloudymsgraph
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/appliedres/cloudy"
 	cloudymodels "github.com/appliedres/cloudy/models"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
 	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
 func init() {
@@ -38,12 +42,206 @@ type MsGraphInviteManager struct {
 	*MsGraph
 }
 
+// InvitationOptions controls an invitation
+type InvitationOptions struct {
+	// SendEmail sends the invitation email, otherwise the redeem URL has to be shared
+	SendEmail bool
+
+	// RedirectUrl is where the user lands after redeeming the invitation
+	RedirectUrl string
+
+	// MessageBody is added to the invitation email
+	MessageBody string
+
+	// CcRecipients are copied on the invitation email
+	CcRecipients []string
+
+	// MessageLanguage of the invitation email, e.g. en-US. Defaults to en-US
+	MessageLanguage string
+
+	// UserType of the invited user, UserTypeGuest or UserTypeMember. Defaults to UserTypeGuest
+	UserType string
+}
+
+// Invitation is the result of inviting a user
+type Invitation struct {
+	// UserID is the object id of the invited user
+	UserID string
+
+	Email     string
+	UserType  string
+	RedeemURL string
+
+	// Status is PendingAcceptance, or Completed when the user already redeemed an invitation
+	Status string
+
+	// Existing is true when the email already belonged to a guest, who was invited again
+	// when pending
+	Existing bool
+
+	// Skipped is true when the guest already accepted an invitation and no new one was sent.
+	// Only the UserType of the options is applied to them
+	Skipped bool
+}
+
+// invitationStatusCompleted is the status of an invitation that was redeemed
+const invitationStatusCompleted = "Completed"
+
+// CreateInvitation invites the user by their email, see Invite. Existing guests are searched
+// with an eventual consistency query, which needs the User.Read.All permission.
 func (im *MsGraphInviteManager) CreateInvitation(ctx context.Context, user *cloudymodels.User, emailInvite bool, inviteRedirectUrl string) error {
-	requestBody := graphmodels.NewInvitation()
-	requestBody.SetInvitedUserEmailAddress(&user.Email)
-	requestBody.SetInvitedUserDisplayName(&user.DisplayName)
-	requestBody.SetSendInvitationMessage(&emailInvite)
-	requestBody.SetInviteRedirectUrl(&inviteRedirectUrl)
-	_, err := im.Client.Invitations().Post(ctx, requestBody, nil)
+	_, err := im.Invite(ctx, user, &InvitationOptions{
+		SendEmail:   emailInvite,
+		RedirectUrl: inviteRedirectUrl,
+	})
 	return err
 }
+
+// Invite invites the user by their email and returns the invitation. When the email
+// already belongs to a guest no new user is created: a pending guest is invited again and
+// an accepted guest is not, only their UserType is changed when the options ask for another
+// one and the invitation is returned with Skipped set. Existing guests are searched with an
+// eventual consistency query, which needs the User.Read.All permission.
+func (im *MsGraphInviteManager) Invite(ctx context.Context, user *cloudymodels.User, opts *InvitationOptions) (*Invitation, error) {
+	if opts == nil {
+		opts = &InvitationOptions{}
+	}
+	cloudy.Info(ctx, "[%s] Invite", user.Email)
+
+	existing, err := im.findGuest(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	body := invitationBody(user.Email, user.DisplayName, opts)
+	if existing != nil {
+		if existing.State == InvitationStateAccepted {
+			cloudy.Warn(ctx, "[%s] Invite - already accepted by %s, no invitation sent", user.Email, existing.User.ID)
+
+			userType := existing.UserType
+			if opts.UserType != "" {
+				desired := cloudy.StringFromP(body.GetInvitedUserType())
+				if !strings.EqualFold(desired, userType) {
+					err = im.setUserType(ctx, existing.User.ID, desired)
+					if err != nil {
+						return nil, err
+					}
+					userType = desired
+				}
+			}
+
+			return &Invitation{
+				UserID:   existing.User.ID,
+				Email:    user.Email,
+				UserType: userType,
+				Status:   invitationStatusCompleted,
+				Existing: true,
+				Skipped:  true,
+			}, nil
+		}
+
+		cloudy.Info(ctx, "[%s] Invite - inviting the pending guest %s again", user.Email, existing.User.ID)
+		invitedUser := graphmodels.NewUser()
+		invitedUser.SetId(&existing.User.ID)
+		body.SetInvitedUser(invitedUser)
+	}
+
+	result, err := im.Client.Invitations().Post(ctx, body, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] Invite Error: %s", user.Email, message)
+	}
+
+	rtn := InvitationToCloudy(result)
+	rtn.Existing = existing != nil
+	return rtn, nil
+}
+
+// findGuest returns the invited user with the email, nil when there is none
+func (im *MsGraphInviteManager) findGuest(ctx context.Context, email string) (*Guest, error) {
+	if email == "" {
+		return nil, nil
+	}
+
+	filter := fmt.Sprintf("mail eq '%[1]s' or otherMails/any(m:m eq '%[1]s')", odataString(email))
+	count := true
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	result, err := im.Client.Users().Get(ctx,
+		&users.UsersRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.UsersRequestBuilderGetQueryParameters{
+				Filter: &filter,
+				Count:  &count,
+				Select: im.userSelectFields(ctx, nil, guestSelectFields...),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] Invite - unable to search the guests: %s", email, message)
+	}
+
+	for _, u := range result.GetValue() {
+		guest := im.guestToCloudy(u)
+		if guest.State != "" {
+			return guest, nil
+		}
+	}
+	return nil, nil
+}
+
+// invitationBody returns the invitation of the email with the options
+func invitationBody(email string, displayName string, opts *InvitationOptions) graphmodels.Invitationable {
+	body := graphmodels.NewInvitation()
+	body.SetInvitedUserEmailAddress(&email)
+	if displayName != "" {
+		body.SetInvitedUserDisplayName(&displayName)
+	}
+	body.SetSendInvitationMessage(&opts.SendEmail)
+	body.SetInviteRedirectUrl(&opts.RedirectUrl)
+
+	userType := UserTypeGuest
+	if strings.EqualFold(opts.UserType, UserTypeMember) {
+		userType = UserTypeMember
+	}
+	body.SetInvitedUserType(&userType)
+
+	if opts.MessageBody != "" || opts.MessageLanguage != "" || len(opts.CcRecipients) > 0 {
+		info := graphmodels.NewInvitedUserMessageInfo()
+		if opts.MessageBody != "" {
+			info.SetCustomizedMessageBody(&opts.MessageBody)
+		}
+		if opts.MessageLanguage != "" {
+			info.SetMessageLanguage(&opts.MessageLanguage)
+		}
+		recipients := []graphmodels.Recipientable{}
+		for _, cc := range opts.CcRecipients {
+			address := graphmodels.NewEmailAddress()
+			address.SetAddress(cloudy.StringP(cc))
+			recipient := graphmodels.NewRecipient()
+			recipient.SetEmailAddress(address)
+			recipients = append(recipients, recipient)
+		}
+		if len(recipients) > 0 {
+			info.SetCcRecipients(recipients)
+		}
+		body.SetInvitedUserMessageInfo(info)
+	}
+
+	return body
+}
+
+func InvitationToCloudy(invitation graphmodels.Invitationable) *Invitation {
+	rtn := &Invitation{
+		Email:     cloudy.StringFromP(invitation.GetInvitedUserEmailAddress()),
+		UserType:  cloudy.StringFromP(invitation.GetInvitedUserType()),
+		RedeemURL: cloudy.StringFromP(invitation.GetInviteRedeemUrl()),
+		Status:    cloudy.StringFromP(invitation.GetStatus()),
+	}
+	if invitation.GetInvitedUser() != nil {
+		rtn.UserID = cloudy.StringFromP(invitation.GetInvitedUser().GetId())
+	}
+	return rtn
+}
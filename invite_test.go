@@ -7,6 +7,7 @@ import (
 
 	"github.com/appliedres/cloudy"
 	"github.com/appliedres/cloudy/testutil"
+	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +35,40 @@ func TestInviteManager(t *testing.T) {
 	im.CreateInvitation(ctx, inviteUser, true, url)
 
 }
+
+func TestInvitationBody(t *testing.T) {
+	body := invitationBody("jane@partner.com", "Jane", &InvitationOptions{
+		SendEmail:       true,
+		RedirectUrl:     "https://example.com",
+		MessageBody:     "Welcome",
+		CcRecipients:    []string{"sponsor@example.com"},
+		MessageLanguage: "fr-FR",
+		UserType:        "member",
+	})
+	assert.Equal(t, "jane@partner.com", *body.GetInvitedUserEmailAddress())
+	assert.Equal(t, "Jane", *body.GetInvitedUserDisplayName())
+	assert.True(t, *body.GetSendInvitationMessage())
+	assert.Equal(t, UserTypeMember, *body.GetInvitedUserType())
+	assert.Equal(t, "Welcome", *body.GetInvitedUserMessageInfo().GetCustomizedMessageBody())
+	assert.Equal(t, "fr-FR", *body.GetInvitedUserMessageInfo().GetMessageLanguage())
+	assert.Equal(t, "sponsor@example.com", *body.GetInvitedUserMessageInfo().GetCcRecipients()[0].GetEmailAddress().GetAddress())
+
+	body = invitationBody("jane@partner.com", "", &InvitationOptions{})
+	assert.Nil(t, body.GetInvitedUserDisplayName())
+	assert.False(t, *body.GetSendInvitationMessage())
+	assert.Equal(t, UserTypeGuest, *body.GetInvitedUserType())
+	assert.Nil(t, body.GetInvitedUserMessageInfo())
+}
+
+func TestInvitationToCloudy(t *testing.T) {
+	id, email, url, status := "u", "jane@partner.com", "https://login.microsoftonline.com/redeem", "PendingAcceptance"
+	user := graphmodels.NewUser()
+	user.SetId(&id)
+	invitation := graphmodels.NewInvitation()
+	invitation.SetInvitedUser(user)
+	invitation.SetInvitedUserEmailAddress(&email)
+	invitation.SetInviteRedeemUrl(&url)
+	invitation.SetStatus(&status)
+
+	assert.Equal(t, &Invitation{UserID: id, Email: email, RedeemURL: url, Status: status}, InvitationToCloudy(invitation))
+}
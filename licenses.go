@@ -132,7 +132,7 @@ func (lm *MsGraphLicenseManager) GetUserAssigned(ctx context.Context, uid string
 // SEE : https://docs.microsoft.com/en-us/graph/query-parameters#filter-parameter
 func (lm *MsGraphLicenseManager) GetAssigned(ctx context.Context, licenseSku string) ([]*cloudymodels.User, error) {
 	filter := fmt.Sprintf("assignedLicenses/any(s:s/skuId eq %v)", licenseSku)
-	fields := DefaultUserSelectFields
+	fields := lm.userSelectFields(ctx, nil)
 
 	result, err := lm.Client.Users().Get(ctx,
 		&users.UsersRequestBuilderGetRequestConfiguration{
@@ -152,7 +152,7 @@ func (lm *MsGraphLicenseManager) GetAssigned(ctx context.Context, licenseSku str
 	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
-		rtn = append(rtn, UserToCloudy(pageItem))
+		rtn = append(rtn, lm.userToCloudy(pageItem))
 		return true
 	})
 	if err != nil {
@@ -0,0 +1,218 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// DefaultManagementChainDepth is the number of managers returned by GetManagementChain
+// when no depth is given
+const DefaultManagementChainDepth = 10
+
+// GetManager returns the manager of the user, nil when the user has no manager
+func (um *MsGraphUserManager) GetManager(ctx context.Context, uid string) (*cloudymodels.User, error) {
+	cloudy.Info(ctx, "[%s] GetManager", uid)
+
+	result, err := um.Client.Users().ByUserId(uid).Manager().Get(ctx,
+		&users.ItemManagerRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.ItemManagerRequestBuilderGetQueryParameters{
+				Select: um.userSelectFields(ctx, nil),
+			},
+		})
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Info(ctx, "[%s] GetManager - ResourceNotFound - %s", uid, message)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetManager - error: %v", uid, message)
+	}
+
+	manager, ok := result.(models.Userable)
+	if !ok {
+		cloudy.Warn(ctx, "[%s] GetManager - the manager is not a user", uid)
+		return nil, nil
+	}
+
+	return um.userToCloudy(manager), nil
+}
+
+// SetManager sets the manager of the user
+func (um *MsGraphUserManager) SetManager(ctx context.Context, uid string, managerId string) error {
+	cloudy.Info(ctx, "[%s] SetManager %s", uid, managerId)
+
+	if strings.EqualFold(uid, managerId) {
+		return cloudy.Error(ctx, "[%s] SetManager - a user cannot be their own manager", uid)
+	}
+
+	requestBody := models.NewReferenceUpdate()
+	odataId := fmt.Sprintf("%s/users/%s", strings.TrimSuffix(um.Cfg.APIBase, "/"), managerId)
+	requestBody.SetOdataId(&odataId)
+
+	err := um.Client.Users().ByUserId(uid).Manager().Ref().Put(ctx, requestBody, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] SetManager Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// RemoveManager removes the manager of the user. Nothing is done when the user has no manager.
+func (um *MsGraphUserManager) RemoveManager(ctx context.Context, uid string) error {
+	cloudy.Info(ctx, "[%s] RemoveManager", uid)
+
+	err := um.Client.Users().ByUserId(uid).Manager().Ref().Delete(ctx, nil)
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Info(ctx, "[%s] RemoveManager - ResourceNotFound - %s", uid, message)
+			return nil
+		}
+
+		return cloudy.Error(ctx, "[%s] RemoveManager Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// ListDirectReports returns the users that report to the user, reading every page
+func (um *MsGraphUserManager) ListDirectReports(ctx context.Context, uid string) ([]*cloudymodels.User, error) {
+	cloudy.Info(ctx, "[%s] ListDirectReports", uid)
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	result, err := um.Client.Users().ByUserId(uid).DirectReports().GraphUser().Get(ctx,
+		&users.ItemDirectReportsGraphUserRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.ItemDirectReportsGraphUserRequestBuilderGetQueryParameters{
+				Select: um.userSelectFields(ctx, nil),
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] ListDirectReports Error: %s", uid, message)
+	}
+
+	rtn := []*cloudymodels.User{}
+	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, um.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
+		rtn = append(rtn, um.userToCloudy(pageItem))
+		return true
+	})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] ListDirectReports Error: %s", uid, message)
+	}
+
+	return rtn, nil
+}
+
+// GetManagementChain returns the managers of the user, starting with the direct manager,
+// up to maxDepth managers (DefaultManagementChainDepth when not positive). The chain is
+// read in a single request with $expand=manager($levels=max) and falls back to reading
+// each manager in turn when the expansion is not supported. The chain stops at the first
+// manager already in it.
+func (um *MsGraphUserManager) GetManagementChain(ctx context.Context, uid string, maxDepth int) ([]*cloudymodels.User, error) {
+	cloudy.Info(ctx, "[%s] GetManagementChain", uid)
+
+	if maxDepth <= 0 {
+		maxDepth = DefaultManagementChainDepth
+	}
+
+	fields := strings.Join(um.userSelectFields(ctx, nil), ",")
+	query := url.Values{}
+	query.Set("$expand", fmt.Sprintf("manager($levels=max;$select=%s)", fields))
+	query.Set("$select", "id")
+	query.Set("$count", "true")
+	rawUrl := fmt.Sprintf("%s/users/%s?%s", strings.TrimSuffix(um.Cfg.APIBase, "/"), url.PathEscape(uid), query.Encode())
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	result, err := um.Client.Users().ByUserId(uid).WithUrl(rawUrl).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+		})
+	if err == nil {
+		rtn := []*cloudymodels.User{}
+		for _, manager := range managementChain(result, maxDepth) {
+			rtn = append(rtn, um.userToCloudy(manager))
+		}
+		return rtn, nil
+	}
+
+	code, message := GetErrorCodeAndMessage(ctx, err)
+	if strings.EqualFold(code, ResourceNotFoundCode) {
+		cloudy.Info(ctx, "[%s] GetManagementChain - ResourceNotFound - %s", uid, message)
+		return nil, nil
+	}
+	cloudy.Warn(ctx, "[%s] GetManagementChain - unable to expand the managers, reading them one at a time: %s", uid, message)
+
+	rtn := []*cloudymodels.User{}
+	visited := map[string]bool{strings.ToLower(uid): true}
+	current := uid
+	for len(rtn) < maxDepth {
+		manager, err := um.GetManager(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		if manager == nil || visited[strings.ToLower(manager.ID)] {
+			break
+		}
+
+		visited[strings.ToLower(manager.ID)] = true
+		rtn = append(rtn, manager)
+		current = manager.ID
+	}
+
+	return rtn, nil
+}
+
+// managementChain walks the expanded managers of the user, up to maxDepth managers. The
+// walk stops at the first manager that is not a user or is already in the chain.
+func managementChain(user models.Userable, maxDepth int) []models.Userable {
+	rtn := []models.Userable{}
+	visited := map[string]bool{}
+	if user.GetId() != nil {
+		visited[strings.ToLower(*user.GetId())] = true
+	}
+
+	current := user
+	for len(rtn) < maxDepth {
+		manager, ok := current.GetManager().(models.Userable)
+		if !ok || manager == nil {
+			break
+		}
+
+		if manager.GetId() != nil {
+			id := strings.ToLower(*manager.GetId())
+			if visited[id] {
+				break
+			}
+			visited[id] = true
+		}
+
+		rtn = append(rtn, manager)
+		current = manager
+	}
+
+	return rtn
+}
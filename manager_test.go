@@ -0,0 +1,46 @@
+package cloudymsgraph
+
+import (
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testManagerUser(id string) *models.User {
+	u := models.NewUser()
+	u.SetId(&id)
+	return u
+}
+
+func managerIds(chain []models.Userable) []string {
+	ids := []string{}
+	for _, u := range chain {
+		ids = append(ids, *u.GetId())
+	}
+	return ids
+}
+
+func TestManagementChain(t *testing.T) {
+	user := testManagerUser("user")
+	a := testManagerUser("a")
+	b := testManagerUser("b")
+	c := testManagerUser("c")
+	user.SetManager(a)
+	a.SetManager(b)
+	b.SetManager(c)
+
+	assert.Equal(t, []string{"a", "b", "c"}, managerIds(managementChain(user, 10)))
+	assert.Equal(t, []string{"a", "b"}, managerIds(managementChain(user, 2)))
+	assert.Equal(t, []string{}, managerIds(managementChain(c, 10)))
+
+	// Cycles stop at the first repeated manager
+	c.SetManager(a)
+	assert.Equal(t, []string{"a", "b", "c"}, managerIds(managementChain(user, 10)))
+	c.SetManager(user)
+	assert.Equal(t, []string{"a", "b", "c"}, managerIds(managementChain(user, 10)))
+
+	// Managers that are not users end the chain
+	b.SetManager(models.NewOrgContact())
+	assert.Equal(t, []string{"a", "b"}, managerIds(managementChain(user, 10)))
+}
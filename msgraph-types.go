@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
@@ -44,6 +45,59 @@ type MsGraphConfig struct {
 	Region       string
 	APIBase      string
 	SelectFields []string
+
+	// RevokeSessionsOnDisable revokes the refresh tokens and session cookies
+	// of a user when they are disabled or deleted
+	RevokeSessionsOnDisable bool
+
+	// CertificateBindingPolicy controls which certificate user ids are bound when
+	// binding a user from a certificate. Defaults to DefaultCertificateBindingPolicy
+	CertificateBindingPolicy *CertificateBindingPolicy
+
+	// CustomSecurityAttributes maps custom security attributes to the user. Defaults
+	// to DefaultCustomSecurityAttributes
+	CustomSecurityAttributes CustomSecurityAttributeMappings
+
+	// DisableStreetAddressFallback stops reading the legacy base64 encoded attributes
+	// from streetAddress when a user has no custom security attributes. Turn this on
+	// once MigrateStreetAddressAttributes has been run
+	DisableStreetAddressFallback bool
+
+	// ExtensionAppID is the app id of the application that owns the directory extension
+	// properties. Short extension names are expanded to extension_{appId}_{name}
+	ExtensionAppID string
+
+	// ExtensionProperties are the directory extension properties selected with every user
+	// read. Their values are returned in the user attributes map
+	ExtensionProperties []string
+
+	// ProfilePhotoCacheTTL is how long a cached profile photo is returned before its ETag
+	// is checked again. Defaults to DefaultProfilePhotoCacheTTL, negative turns off the cache
+	ProfilePhotoCacheTTL time.Duration
+
+	// UsernamePattern builds the user names generated by GenerateUserName. Defaults to
+	// DefaultUsernamePattern
+	UsernamePattern string
+
+	// DomainCacheTTL is how long the tenant domains are cached. Defaults to
+	// DefaultDomainCacheTTL, negative turns off the cache
+	DomainCacheTTL time.Duration
+
+	// DisableDomainValidation stops checking the user principal name and mail of users
+	// use verified domains before they are created or updated. The check reads the tenant
+	// domains, which needs the Domain.Read.All permission.
+	DisableDomainValidation bool
+
+	// MailSender is the mailbox, user principal name or id, that email is sent from.
+	// Defaults to the From address of each message
+	MailSender string
+
+	// DriveSiteID is the SharePoint site whose default document library is used for
+	// storage, when DriveID is not set
+	DriveSiteID string
+
+	// DriveID is the drive, a document library or OneDrive, used for storage
+	DriveID string
 }
 
 func (azConfig *MsGraphConfig) SetInstanceName(name string) error {
@@ -78,7 +132,7 @@ func (azUM *MsGraph) Configure(azConfig *MsGraphConfig) error {
 		azConfig.SetInstance(&AzurePublic)
 	}
 	if azConfig.SelectFields == nil {
-		azConfig.SelectFields = DefaultUserSelectFields
+		azConfig.SelectFields = append([]string{}, DefaultUserSelectFields...)
 	}
 
 	scopes := []string{"https://graph.microsoft.us/.default"}
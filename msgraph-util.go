@@ -1,9 +1,16 @@
 package cloudymsgraph
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
 
 	"github.com/appliedres/cloudy"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
 	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
 )
 
@@ -11,6 +18,15 @@ const BadRequest = "Request_BadRequest"
 const ResourceNotFoundCode = "Request_ResourceNotFound"
 const ImageNotFoundCode = "ImageNotFound"
 
+// ErrRevokeSessionsFailed is returned when a user was updated but their sign in
+// sessions could not be revoked. The update itself is not rolled back.
+var ErrRevokeSessionsFailed = errors.New("revoke sign in sessions failed")
+
+// odataErrorMapping maps the errors of the requests sent directly to the adapter
+var odataErrorMapping = abstractions.ErrorMappings{
+	"XXX": odataerrors.CreateODataErrorFromDiscriminatorValue,
+}
+
 func GetErrorCodeAndMessage(ctx context.Context, err error) (string, string) {
 	oDataErr, ok := err.(*odataerrors.ODataError)
 
@@ -23,3 +39,53 @@ func GetErrorCodeAndMessage(ctx context.Context, err error) (string, string) {
 
 	return *errEscaped.GetCode(), *errEscaped.GetMessage()
 }
+
+// errorMessage returns the message of an OData error, or the error itself when it is not one,
+// e.g. an io or url error
+func errorMessage(ctx context.Context, err error) string {
+	_, message := errorCodeAndMessage(ctx, err)
+	return message
+}
+
+// errorCodeAndMessage returns the code and message of an OData error, no code and the
+// error itself when it is not one
+func errorCodeAndMessage(ctx context.Context, err error) (string, string) {
+	var oDataErr *odataerrors.ODataError
+	if errors.As(err, &oDataErr) {
+		return GetErrorCodeAndMessage(ctx, oDataErr)
+	}
+	return "", err.Error()
+}
+
+// sendToUploadSession sends a request to the upload URL of an upload session and returns
+// the response body. The URL is pre-authenticated and not a Graph host, so the request is
+// sent without the Graph bearer token of the adapter.
+func sendToUploadSession(ctx context.Context, method string, uploadUrl string, content []byte, contentRange string) ([]byte, error) {
+	var body io.Reader
+	if content != nil {
+		body = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uploadUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rtn, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("upload session %s %s: %s", method, resp.Status, strings.TrimSpace(string(rtn)))
+	}
+	return rtn, nil
+}
@@ -0,0 +1,195 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"time"
+
+	"github.com/appliedres/cloudy"
+)
+
+// PendingInvitationAction is what the tracker does with a pending invitation
+type PendingInvitationAction string
+
+const (
+	PendingInvitationNone    PendingInvitationAction = "none"
+	PendingInvitationRemind  PendingInvitationAction = "remind"
+	PendingInvitationDisable PendingInvitationAction = "disable"
+	PendingInvitationDelete  PendingInvitationAction = "delete"
+)
+
+// DefaultPendingInvitationReminders are when reminders are sent, after the guest is invited
+var DefaultPendingInvitationReminders = []time.Duration{3 * 24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour}
+
+// DefaultPendingInvitationExpiry is when a pending invitation expires, after the guest is invited
+const DefaultPendingInvitationExpiry = 30 * 24 * time.Hour
+
+// DefaultPendingInvitationInterval is how often ProcessPendingInvitations is expected to run
+const DefaultPendingInvitationInterval = 24 * time.Hour
+
+// PendingInvitationOptions controls the processing of the pending invitations
+type PendingInvitationOptions struct {
+	// Reminders are when the invitation is sent again, after the guest was invited.
+	// Defaults to DefaultPendingInvitationReminders, empty non nil sends no reminders.
+	Reminders []time.Duration
+
+	// Expiry is when the invitation expires, after the guest was invited. Defaults to
+	// DefaultPendingInvitationExpiry
+	Expiry time.Duration
+
+	// Interval is how often the pending invitations are processed. A reminder is sent by the
+	// run within Interval after it is due, so runs have to be Interval apart for each
+	// reminder to be sent once. Defaults to DefaultPendingInvitationInterval
+	Interval time.Duration
+
+	// ExpiryAction is done to the guests whose invitation expired, PendingInvitationDisable
+	// or PendingInvitationDelete. Defaults to PendingInvitationDisable
+	ExpiryAction PendingInvitationAction
+
+	// RedirectUrl of the reminders
+	RedirectUrl string
+
+	// Domain only processes the guests invited with an email of the domain
+	Domain string
+
+	// DryRun reports the actions without doing them
+	DryRun bool
+}
+
+// PendingInvitation is a guest that has not accepted their invitation
+type PendingInvitation struct {
+	Guest *Guest
+
+	// Age is the time since the guest was invited
+	Age time.Duration
+
+	Action PendingInvitationAction
+
+	// Error is set when the action failed
+	Error string
+}
+
+// PendingInvitationReport is the result of processing the pending invitations
+type PendingInvitationReport struct {
+	Invitations []*PendingInvitation
+	DryRun      bool
+	Reminded    int
+	Disabled    int
+	Deleted     int
+	Failed      int
+}
+
+// ListPendingInvitations returns the guests that have not accepted their invitation, with
+// their age
+func (im *MsGraphInviteManager) ListPendingInvitations(ctx context.Context, domain string) ([]*PendingInvitation, error) {
+	guests, err := im.ListGuests(ctx, &ListGuestsOptions{State: InvitationStatePending, Domain: domain})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rtn := []*PendingInvitation{}
+	for _, g := range guests {
+		rtn = append(rtn, &PendingInvitation{
+			Guest:  g,
+			Age:    now.Sub(g.Created),
+			Action: PendingInvitationNone,
+		})
+	}
+	return rtn, nil
+}
+
+// ProcessPendingInvitations sends the reminders that are due and disables or deletes the
+// guests whose invitation expired. Meant to run on a schedule every opts.Interval, a daily
+// one by default: a reminder is sent by the run within the interval after it is due.
+func (im *MsGraphInviteManager) ProcessPendingInvitations(ctx context.Context, opts *PendingInvitationOptions) (*PendingInvitationReport, error) {
+	if opts == nil {
+		opts = &PendingInvitationOptions{}
+	}
+	cloudy.Info(ctx, "ProcessPendingInvitations dryRun=%v", opts.DryRun)
+
+	pending, err := im.ListPendingInvitations(ctx, opts.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	um := &MsGraphUserManager{MsGraph: im.MsGraph}
+	report := &PendingInvitationReport{DryRun: opts.DryRun}
+	now := time.Now()
+
+	for _, p := range pending {
+		p.Action = pendingInvitationAction(p.Guest, opts, now)
+		report.Invitations = append(report.Invitations, p)
+		if p.Action == PendingInvitationNone || opts.DryRun {
+			continue
+		}
+
+		switch p.Action {
+		case PendingInvitationRemind:
+			err = im.ResendInvitation(ctx, p.Guest.User.ID, opts.RedirectUrl)
+		case PendingInvitationDisable:
+			err = um.Disable(ctx, p.Guest.User.ID)
+		case PendingInvitationDelete:
+			err = um.DeleteUser(ctx, p.Guest.User.ID)
+		}
+		if err != nil {
+			cloudy.Warn(ctx, "[%s] ProcessPendingInvitations %s Error: %v", p.Guest.User.ID, p.Action, err)
+			p.Error = err.Error()
+			report.Failed++
+			continue
+		}
+
+		switch p.Action {
+		case PendingInvitationRemind:
+			report.Reminded++
+		case PendingInvitationDisable:
+			report.Disabled++
+		case PendingInvitationDelete:
+			report.Deleted++
+		}
+	}
+
+	cloudy.Info(ctx, "ProcessPendingInvitations %d pending, %d reminded, %d disabled, %d deleted, %d failed",
+		len(pending), report.Reminded, report.Disabled, report.Deleted, report.Failed)
+	return report, nil
+}
+
+// pendingInvitationAction returns what to do with the pending invitation of the guest. Graph
+// does not record when an invitation was sent again, so a reminder is due for the interval
+// after the invitation reaches the reminder age.
+func pendingInvitationAction(guest *Guest, opts *PendingInvitationOptions, now time.Time) PendingInvitationAction {
+	if guest.Created.IsZero() {
+		return PendingInvitationNone
+	}
+	age := now.Sub(guest.Created)
+
+	expiry := opts.Expiry
+	if expiry <= 0 {
+		expiry = DefaultPendingInvitationExpiry
+	}
+	if age >= expiry {
+		if opts.ExpiryAction == PendingInvitationDelete {
+			return PendingInvitationDelete
+		}
+		if !guest.User.Enabled {
+			return PendingInvitationNone
+		}
+		return PendingInvitationDisable
+	}
+
+	reminders := opts.Reminders
+	if reminders == nil {
+		reminders = DefaultPendingInvitationReminders
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultPendingInvitationInterval
+	}
+	for _, r := range reminders {
+		if age >= r && age < r+interval {
+			return PendingInvitationRemind
+		}
+	}
+
+	return PendingInvitationNone
+}
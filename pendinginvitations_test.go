@@ -0,0 +1,44 @@
+package cloudymsgraph
+
+import (
+	"testing"
+	"time"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingInvitationAction(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	guest := func(age time.Duration, enabled bool) *Guest {
+		return &Guest{
+			User:         &cloudymodels.User{ID: "g", Enabled: enabled},
+			Created:      now.Add(-age),
+			StateChanged: now.Add(-age),
+		}
+	}
+	opts := &PendingInvitationOptions{}
+
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(day, true), opts, now))
+	assert.Equal(t, PendingInvitationRemind, pendingInvitationAction(guest(3*day+time.Hour, true), opts, now))
+	// reminded on day 3, the state change time is not updated by the reminder
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(4*day+time.Hour, true), opts, now))
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(5*day, true), opts, now))
+	assert.Equal(t, PendingInvitationRemind, pendingInvitationAction(guest(7*day, true), opts, now))
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(8*day, true), opts, now))
+	assert.Equal(t, PendingInvitationDisable, pendingInvitationAction(guest(30*day, true), opts, now))
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(30*day, false), opts, now))
+	assert.Equal(t, PendingInvitationDelete, pendingInvitationAction(guest(30*day, false),
+		&PendingInvitationOptions{ExpiryAction: PendingInvitationDelete}, now))
+
+	weekly := &PendingInvitationOptions{Interval: 7 * day}
+	assert.Equal(t, PendingInvitationRemind, pendingInvitationAction(guest(5*day, true), weekly, now))
+	assert.Equal(t, PendingInvitationRemind, pendingInvitationAction(guest(13*day, true), weekly, now))
+
+	noReminders := &PendingInvitationOptions{Reminders: []time.Duration{}, Expiry: 10 * day}
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(guest(8*day, true), noReminders, now))
+	assert.Equal(t, PendingInvitationDisable, pendingInvitationAction(guest(10*day, true), noReminders, now))
+
+	assert.Equal(t, PendingInvitationNone, pendingInvitationAction(&Guest{User: &cloudymodels.User{}}, opts, now))
+}
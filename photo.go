@@ -0,0 +1,429 @@
+package cloudymsgraph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// ProfilePhotoSize is one of the sizes Graph stores a profile photo in
+type ProfilePhotoSize string
+
+const (
+	// ProfilePhotoSizeOriginal is the photo as uploaded
+	ProfilePhotoSizeOriginal ProfilePhotoSize = ""
+	ProfilePhotoSize48       ProfilePhotoSize = "48x48"
+	ProfilePhotoSize64       ProfilePhotoSize = "64x64"
+	ProfilePhotoSize96       ProfilePhotoSize = "96x96"
+	ProfilePhotoSize120      ProfilePhotoSize = "120x120"
+	ProfilePhotoSize240      ProfilePhotoSize = "240x240"
+	ProfilePhotoSize360      ProfilePhotoSize = "360x360"
+	ProfilePhotoSize432      ProfilePhotoSize = "432x432"
+	ProfilePhotoSize504      ProfilePhotoSize = "504x504"
+	ProfilePhotoSize648      ProfilePhotoSize = "648x648"
+)
+
+// ProfilePhotoSizes are the sizes that can be requested, smallest first
+var ProfilePhotoSizes = []ProfilePhotoSize{
+	ProfilePhotoSize48,
+	ProfilePhotoSize64,
+	ProfilePhotoSize96,
+	ProfilePhotoSize120,
+	ProfilePhotoSize240,
+	ProfilePhotoSize360,
+	ProfilePhotoSize432,
+	ProfilePhotoSize504,
+	ProfilePhotoSize648,
+}
+
+// MaxProfilePhotoBytes is the largest photo Graph accepts
+const MaxProfilePhotoBytes = 4 * 1024 * 1024
+
+// MaxProfilePhotoDimension is the largest side of a photo that is resized to fit
+// in MaxProfilePhotoBytes, the largest size Graph serves
+const MaxProfilePhotoDimension = 648
+
+// DefaultProfilePhotoCacheTTL is how long a cached photo is returned before its ETag is checked
+const DefaultProfilePhotoCacheTTL = 5 * time.Minute
+
+var ErrInvalidProfilePhotoSize = errors.New("invalid profile photo size")
+var ErrUnsupportedProfilePhoto = errors.New("profile photos must be JPEG or PNG images")
+var ErrProfilePhotoTooLarge = errors.New("profile photo is too large")
+
+// ProfilePhoto is a profile photo and its metadata. Content is empty when only
+// the metadata was read.
+type ProfilePhoto struct {
+	Size        ProfilePhotoSize
+	Width       int
+	Height      int
+	ContentType string
+	ETag        string
+	Content     []byte
+}
+
+// IsValid returns true for the original size and the sizes in ProfilePhotoSizes
+func (size ProfilePhotoSize) IsValid() bool {
+	if size == ProfilePhotoSizeOriginal {
+		return true
+	}
+	for _, s := range ProfilePhotoSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadProfilePicture validates the picture, resizing it when it is too large, and
+// sets it as the photo of the user
+func (um *MsGraphUserManager) UploadProfilePicture(ctx context.Context, uid string, picture []byte) error {
+	cloudy.Info(ctx, "[%s] UploadProfilePicture", uid)
+
+	content, contentType, err := PrepareProfilePhoto(picture)
+	if err != nil {
+		return cloudy.Error(ctx, "[%s] UploadProfilePicture Error: %v", uid, err)
+	}
+
+	builder := um.Client.Users().ByUserId(uid).Photo().Content()
+	requestInfo, err := builder.ToPutRequestInformation(ctx, content, nil)
+	if err != nil {
+		return err
+	}
+	requestInfo.Headers.Remove("Content-Type")
+	requestInfo.Headers.Add("Content-Type", contentType)
+
+	_, err = um.Adapter.SendPrimitive(ctx, requestInfo, "[]byte", odataErrorMapping)
+	um.photos.invalidate(um.profilePhotoCacheUser(uid))
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] UploadProfilePicture Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// GetProfilePicture returns the original photo of the user, nil when the user has no photo
+func (um *MsGraphUserManager) GetProfilePicture(ctx context.Context, uid string) ([]byte, error) {
+	photo, err := um.GetProfilePhoto(ctx, uid, ProfilePhotoSizeOriginal)
+	if err != nil || photo == nil {
+		return nil, err
+	}
+	return photo.Content, nil
+}
+
+// GetProfilePhoto returns the photo of the user in the size, nil when the user has no
+// photo. Photos are cached by uid, once a cached photo is older than the
+// ProfilePhotoCacheTTL its ETag is checked and the content is only read again when it changed.
+func (um *MsGraphUserManager) GetProfilePhoto(ctx context.Context, uid string, size ProfilePhotoSize) (*ProfilePhoto, error) {
+	cloudy.Info(ctx, "[%s] GetProfilePhoto %s", uid, size)
+
+	if !size.IsValid() {
+		return nil, cloudy.Error(ctx, "[%s] GetProfilePhoto Error: %v %s", uid, ErrInvalidProfilePhotoSize, size)
+	}
+
+	ttl := um.profilePhotoCacheTTL()
+	key := um.profilePhotoCacheUser(uid)
+	cached, fresh := um.photos.get(key, size, ttl)
+	if fresh {
+		return cached, nil
+	}
+
+	metadata, err := um.GetProfilePhotoMetadata(ctx, uid, size)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		um.photos.invalidate(key)
+		return nil, nil
+	}
+
+	if cached != nil && cached.ETag != "" && cached.ETag == metadata.ETag {
+		um.photos.put(key, cached, ttl)
+		return cached, nil
+	}
+
+	var content []byte
+	if size == ProfilePhotoSizeOriginal {
+		content, err = um.Client.Users().ByUserId(uid).Photo().Content().Get(ctx, nil)
+	} else {
+		content, err = um.Client.Users().ByUserId(uid).Photos().ByProfilePhotoId(string(size)).Content().Get(ctx, nil)
+	}
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if isPhotoNotFound(code) {
+			cloudy.Warn(ctx, "[%s] GetProfilePhoto - %s - %s", uid, code, message)
+			um.photos.invalidate(key)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetProfilePhoto Error: %s", uid, message)
+	}
+
+	metadata.Content = content
+	if metadata.ContentType == "" {
+		metadata.ContentType = http.DetectContentType(content)
+	}
+	um.photos.put(key, metadata, ttl)
+
+	return metadata, nil
+}
+
+// GetProfilePhotoMetadata returns the dimensions, content type and ETag of the photo of
+// the user in the size, nil when the user has no photo
+func (um *MsGraphUserManager) GetProfilePhotoMetadata(ctx context.Context, uid string, size ProfilePhotoSize) (*ProfilePhoto, error) {
+	if !size.IsValid() {
+		return nil, cloudy.Error(ctx, "[%s] GetProfilePhotoMetadata Error: %v %s", uid, ErrInvalidProfilePhotoSize, size)
+	}
+
+	var result models.ProfilePhotoable
+	var err error
+	if size == ProfilePhotoSizeOriginal {
+		result, err = um.Client.Users().ByUserId(uid).Photo().Get(ctx, nil)
+	} else {
+		result, err = um.Client.Users().ByUserId(uid).Photos().ByProfilePhotoId(string(size)).Get(ctx, nil)
+	}
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if isPhotoNotFound(code) {
+			cloudy.Info(ctx, "[%s] GetProfilePhotoMetadata - %s - %s", uid, code, message)
+			return nil, nil
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] GetProfilePhotoMetadata Error: %s", uid, message)
+	}
+
+	return ProfilePhotoToCloudy(result, size), nil
+}
+
+// DeleteProfilePhoto removes the photo of the user
+func (um *MsGraphUserManager) DeleteProfilePhoto(ctx context.Context, uid string) error {
+	cloudy.Info(ctx, "[%s] DeleteProfilePhoto", uid)
+
+	// The SDK has no delete for the photo, send it to the same url as the content
+	requestInfo, err := um.Client.Users().ByUserId(uid).Photo().Content().ToGetRequestInformation(ctx, nil)
+	if err != nil {
+		return err
+	}
+	requestInfo.Method = abstractions.DELETE
+
+	err = um.Adapter.SendNoContent(ctx, requestInfo, odataErrorMapping)
+	um.photos.invalidate(um.profilePhotoCacheUser(uid))
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if isPhotoNotFound(code) {
+			cloudy.Info(ctx, "[%s] DeleteProfilePhoto - %s - %s", uid, code, message)
+			return nil
+		}
+
+		return cloudy.Error(ctx, "[%s] DeleteProfilePhoto Error: %s", uid, message)
+	}
+
+	return nil
+}
+
+// profilePhotoCacheUser returns the key the photos of the user are cached under, the uid as
+// given. A user read by object id and by user principal name is cached twice, when one is
+// changed the other is refreshed once its ETag is checked. Returns "" when the cache is off.
+func (um *MsGraphUserManager) profilePhotoCacheUser(uid string) string {
+	if um.profilePhotoCacheTTL() < 0 {
+		return ""
+	}
+	return strings.ToLower(uid)
+}
+
+func (um *MsGraphUserManager) profilePhotoCacheTTL() time.Duration {
+	if um.Cfg == nil || um.Cfg.ProfilePhotoCacheTTL == 0 {
+		return DefaultProfilePhotoCacheTTL
+	}
+	return um.Cfg.ProfilePhotoCacheTTL
+}
+
+// PrepareProfilePhoto checks the picture is a JPEG or PNG image and returns it with its
+// content type. Pictures larger than MaxProfilePhotoBytes are scaled down to fit in
+// MaxProfilePhotoDimension.
+func PrepareProfilePhoto(picture []byte) ([]byte, string, error) {
+	contentType := http.DetectContentType(picture)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedProfilePhoto, contentType)
+	}
+
+	if len(picture) <= MaxProfilePhotoBytes {
+		_, _, err := image.DecodeConfig(bytes.NewReader(picture))
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedProfilePhoto, err)
+		}
+		return picture, contentType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(picture))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedProfilePhoto, err)
+	}
+
+	resized := resizeImage(img, MaxProfilePhotoDimension)
+	buf := &bytes.Buffer{}
+	if contentType == "image/png" {
+		err = png.Encode(buf, resized)
+	} else {
+		err = jpeg.Encode(buf, resized, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if buf.Len() > MaxProfilePhotoBytes {
+		return nil, "", fmt.Errorf("%w: %d bytes", ErrProfilePhotoTooLarge, buf.Len())
+	}
+
+	return buf.Bytes(), contentType, nil
+}
+
+// resizeImage scales the image down, averaging the source pixels, so that neither
+// side is larger than max. Smaller images are returned as is.
+func resizeImage(img image.Image, max int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+
+	dw, dh := max, h*max/w
+	if h > w {
+		dw, dh = w*max/h, max
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+(x+1)*w/dw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// ProfilePhotoToCloudy converts the photo metadata, the content is not included
+func ProfilePhotoToCloudy(photo models.ProfilePhotoable, size ProfilePhotoSize) *ProfilePhoto {
+	rtn := &ProfilePhoto{
+		Size: size,
+	}
+	if photo.GetWidth() != nil {
+		rtn.Width = int(*photo.GetWidth())
+	}
+	if photo.GetHeight() != nil {
+		rtn.Height = int(*photo.GetHeight())
+	}
+	rtn.ContentType, _ = toString(photo.GetAdditionalData()["@odata.mediaContentType"])
+	rtn.ETag, _ = toString(photo.GetAdditionalData()["@odata.mediaEtag"])
+	return rtn
+}
+
+func isPhotoNotFound(code string) bool {
+	return strings.EqualFold(code, ImageNotFoundCode) || strings.EqualFold(code, ResourceNotFoundCode)
+}
+
+// profilePhotoCache holds the photos read by the user manager, keyed by user and size.
+// Callers get a copy of the cached photos. The zero value is ready to use.
+type profilePhotoCache struct {
+	mu      sync.Mutex
+	entries map[string]*profilePhotoCacheEntry
+}
+
+type profilePhotoCacheEntry struct {
+	photo   *ProfilePhoto
+	expires time.Time
+}
+
+// get returns a copy of the cached photo and whether it is still fresh. Nothing is
+// cached when the ttl is negative or the user is "".
+func (c *profilePhotoCache) get(uid string, size ProfilePhotoSize, ttl time.Duration) (*ProfilePhoto, bool) {
+	if ttl < 0 || uid == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[profilePhotoCacheKey(uid, size)]
+	if !ok {
+		return nil, false
+	}
+	return entry.photo.clone(), time.Now().Before(entry.expires)
+}
+
+func (c *profilePhotoCache) put(uid string, photo *ProfilePhoto, ttl time.Duration) {
+	if ttl < 0 || uid == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*profilePhotoCacheEntry)
+	}
+	c.entries[profilePhotoCacheKey(uid, photo.Size)] = &profilePhotoCacheEntry{
+		photo:   photo.clone(),
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// invalidate removes every size of the photo of the user
+func (c *profilePhotoCache) invalidate(uid string) {
+	if uid == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := strings.ToLower(uid) + "/"
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (photo *ProfilePhoto) clone() *ProfilePhoto {
+	rtn := *photo
+	rtn.Content = append([]byte(nil), photo.Content...)
+	return &rtn
+}
+
+func profilePhotoCacheKey(uid string, size ProfilePhotoSize) string {
+	return strings.ToLower(uid) + "/" + string(size)
+}
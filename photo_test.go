@@ -0,0 +1,125 @@
+package cloudymsgraph
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPNG(t *testing.T, w int, h int, noise bool) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	r := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if noise {
+				img.Set(x, y, color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255})
+			} else {
+				img.Set(x, y, color.RGBA{200, 100, 50, 255})
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func TestPrepareProfilePhoto(t *testing.T) {
+	small := testPNG(t, 100, 80, false)
+	content, contentType, err := PrepareProfilePhoto(small)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, small, content)
+
+	_, _, err = PrepareProfilePhoto([]byte("GIF89a not a photo"))
+	assert.ErrorIs(t, err, ErrUnsupportedProfilePhoto)
+
+	_, _, err = PrepareProfilePhoto([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0})
+	assert.ErrorIs(t, err, ErrUnsupportedProfilePhoto)
+
+	large := testPNG(t, 1400, 1100, true)
+	assert.Greater(t, len(large), MaxProfilePhotoBytes)
+	content, contentType, err = PrepareProfilePhoto(large)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.LessOrEqual(t, len(content), MaxProfilePhotoBytes)
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, MaxProfilePhotoDimension, cfg.Width)
+	assert.Equal(t, 1100*MaxProfilePhotoDimension/1400, cfg.Height)
+}
+
+func TestProfilePhotoSize(t *testing.T) {
+	assert.True(t, ProfilePhotoSizeOriginal.IsValid())
+	assert.True(t, ProfilePhotoSize48.IsValid())
+	assert.True(t, ProfilePhotoSize648.IsValid())
+	assert.False(t, ProfilePhotoSize("50x50").IsValid())
+}
+
+func TestProfilePhotoToCloudy(t *testing.T) {
+	photo := models.NewProfilePhoto()
+	width, height := int32(48), int32(48)
+	photo.SetWidth(&width)
+	photo.SetHeight(&height)
+	contentType, etag := "image/jpeg", "W/\"abc\""
+	photo.SetAdditionalData(map[string]interface{}{
+		"@odata.mediaContentType": &contentType,
+		"@odata.mediaEtag":        &etag,
+	})
+
+	assert.Equal(t, &ProfilePhoto{
+		Size:        ProfilePhotoSize48,
+		Width:       48,
+		Height:      48,
+		ContentType: "image/jpeg",
+		ETag:        "W/\"abc\"",
+	}, ProfilePhotoToCloudy(photo, ProfilePhotoSize48))
+}
+
+func TestProfilePhotoCache(t *testing.T) {
+	cache := &profilePhotoCache{}
+	photo := &ProfilePhoto{Size: ProfilePhotoSize48, ETag: "1", Content: []byte{1}}
+
+	cached, fresh := cache.get("User", ProfilePhotoSize48, time.Minute)
+	assert.Nil(t, cached)
+	assert.False(t, fresh)
+
+	cache.put("User", photo, time.Minute)
+	cached, fresh = cache.get("user", ProfilePhotoSize48, time.Minute)
+	assert.Equal(t, photo, cached)
+	assert.True(t, fresh)
+
+	cached, _ = cache.get("user", ProfilePhotoSizeOriginal, time.Minute)
+	assert.Nil(t, cached)
+
+	// Stale entries are returned so their ETag can be checked
+	cache.put("user", photo, -time.Minute)
+	cache.put("other", photo, 0)
+	cached, fresh = cache.get("other", ProfilePhotoSize48, time.Minute)
+	assert.Equal(t, photo, cached)
+	assert.False(t, fresh)
+
+	cache.invalidate("USER")
+	cached, _ = cache.get("user", ProfilePhotoSize48, time.Minute)
+	assert.Nil(t, cached)
+	cached, _ = cache.get("other", ProfilePhotoSize48, time.Minute)
+	assert.NotNil(t, cached)
+
+	// Callers get a copy of the content
+	cached.Content[0] = 9
+	cached, _ = cache.get("other", ProfilePhotoSize48, time.Minute)
+	assert.Equal(t, []byte{1}, cached.Content)
+
+	// Users that cannot be resolved are not cached
+	cache.put("", photo, time.Minute)
+	cached, _ = cache.get("", ProfilePhotoSize48, time.Minute)
+	assert.Nil(t, cached)
+}
@@ -0,0 +1,344 @@
+package cloudymsgraph
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// Before custom security attributes could be written, the attributes of a user were
+// stored as base64 encoded JSON in streetAddress. MigrateStreetAddressAttributes moves
+// them into real custom security attributes.
+
+var ErrNotLegacyStreetAddress = errors.New("street address is not a legacy attribute payload")
+var ErrMalformedLegacyStreetAddress = errors.New("malformed legacy attribute payload")
+
+type StreetAddressMigrationStatus string
+
+const (
+	StreetAddressMigrated  StreetAddressMigrationStatus = "migrated"
+	StreetAddressDryRun    StreetAddressMigrationStatus = "dryrun"
+	StreetAddressMalformed StreetAddressMigrationStatus = "malformed"
+	StreetAddressFailed    StreetAddressMigrationStatus = "failed"
+
+	// StreetAddressPartial is a user whose mapped attributes were written but whose
+	// streetAddress was kept, because some of the decoded values have no mapping
+	StreetAddressPartial StreetAddressMigrationStatus = "partial"
+)
+
+type StreetAddressMigrationOptions struct {
+	// DryRun reports what would be migrated without changing any user
+	DryRun bool
+
+	// Checkpoint resumes an interrupted run from the Checkpoint of its report
+	Checkpoint string
+
+	// MaxPages stops the run after this many pages of users, 0 for no limit
+	MaxPages int
+
+	// RestoreStreetAddress returns the real street address of a user. When nil or
+	// empty the streetAddress of the user is cleared
+	RestoreStreetAddress func(user *cloudymodels.User) string
+
+	// DiscardUnmapped clears or restores streetAddress even when some decoded values have
+	// no configured mapping, those values are lost. Otherwise streetAddress is kept for
+	// those users and they are reported as StreetAddressPartial
+	DiscardUnmapped bool
+
+	// OnResult is called for each user with a legacy payload as it is handled
+	OnResult func(result *StreetAddressMigrationResult)
+}
+
+type StreetAddressMigrationResult struct {
+	ID     string
+	UPN    string
+	Status StreetAddressMigrationStatus
+
+	// Attributes are the values decoded from the payload
+	Attributes map[string]interface{}
+
+	// Unmapped are the decoded attributes with a value that no configured mapping writes
+	Unmapped []string
+
+	Error error
+}
+
+type StreetAddressMigrationReport struct {
+	DryRun    bool
+	Scanned   int
+	Migrated  int
+	Malformed int
+	Partial   int
+	Failed    int
+	Results   []*StreetAddressMigrationResult
+
+	// Checkpoint is set when the run stopped before all users were scanned. Pass it
+	// back in the options to resume
+	Checkpoint string
+}
+
+func (report *StreetAddressMigrationReport) add(result *StreetAddressMigrationResult) {
+	switch result.Status {
+	case StreetAddressMigrated, StreetAddressDryRun:
+		report.Migrated++
+	case StreetAddressMalformed:
+		report.Malformed++
+	case StreetAddressPartial:
+		report.Partial++
+	case StreetAddressFailed:
+		report.Failed++
+	}
+	report.Results = append(report.Results, result)
+}
+
+// DecodeLegacyStreetAddress decodes the attributes stored in streetAddress. Returns
+// ErrNotLegacyStreetAddress when the value is a real street address and
+// ErrMalformedLegacyStreetAddress when the payload cannot be read.
+func DecodeLegacyStreetAddress(value string) (*UserCustomSecurityAttributes, error) {
+	decoded, err := b64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(string(decoded)), "{") {
+		return nil, ErrNotLegacyStreetAddress
+	}
+
+	csa := &UserCustomSecurityAttributes{}
+	err = json.Unmarshal(decoded, csa)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedLegacyStreetAddress, err)
+	}
+
+	return csa, nil
+}
+
+// fields returns the decoded values keyed by the cloudy User field they belong to and
+// the remaining values keyed by attribute name
+func (csa *UserCustomSecurityAttributes) fields() (map[string]string, map[string]string) {
+	fields := map[string]string{
+		"AccountType":    csa.AccountType,
+		"Citizenship":    csa.Citizenship,
+		"ContractNumber": csa.ContractNumber,
+		"ContractDate":   csa.ContractExpirationDate,
+		"ProgramRole":    csa.ProgramRole,
+	}
+	extra := map[string]string{
+		"Justification": csa.Justification,
+		"Sponsor":       csa.Sponsor,
+		"StatusReason":  csa.StatusReason,
+	}
+	return fields, extra
+}
+
+// MigrateStreetAddressAttributes scans all users and moves the attributes stored in
+// streetAddress into custom security attributes, using the configured mappings. Values
+// already held in custom security attributes are kept. Migrated users no longer carry
+// a payload so the migration can safely be run again. Users with values that have no
+// mapping keep their payload and are reported as StreetAddressPartial, see DiscardUnmapped.
+func (um *MsGraphUserManager) MigrateStreetAddressAttributes(ctx context.Context, opts *StreetAddressMigrationOptions) (*StreetAddressMigrationReport, error) {
+	if opts == nil {
+		opts = &StreetAddressMigrationOptions{}
+	}
+
+	report := &StreetAddressMigrationReport{
+		DryRun: opts.DryRun,
+	}
+
+	link := opts.Checkpoint
+	for pages := 1; ; pages++ {
+		var result models.UserCollectionResponseable
+		var err error
+
+		if link == "" {
+			result, err = um.Client.Users().Get(ctx,
+				&users.UsersRequestBuilderGetRequestConfiguration{
+					QueryParameters: &users.UsersRequestBuilderGetQueryParameters{
+						Select: []string{"id", "userPrincipalName", "streetAddress", "customSecurityAttributes"},
+					},
+				})
+		} else {
+			result, err = um.Client.Users().WithUrl(link).Get(ctx, nil)
+		}
+		if err != nil {
+			report.Checkpoint = link
+			_, message := GetErrorCodeAndMessage(ctx, err)
+			return report, cloudy.Error(ctx, "MigrateStreetAddressAttributes Error: %s", message)
+		}
+
+		for _, azUser := range result.GetValue() {
+			report.Scanned++
+
+			migrated := um.migrateStreetAddress(ctx, azUser, opts)
+			if migrated == nil {
+				continue
+			}
+
+			report.add(migrated)
+			if opts.OnResult != nil {
+				opts.OnResult(migrated)
+			}
+		}
+
+		if result.GetOdataNextLink() == nil {
+			report.Checkpoint = ""
+			break
+		}
+		link = *result.GetOdataNextLink()
+
+		if ctx.Err() != nil {
+			report.Checkpoint = link
+			return report, ctx.Err()
+		}
+
+		if opts.MaxPages > 0 && pages >= opts.MaxPages {
+			report.Checkpoint = link
+			break
+		}
+	}
+
+	cloudy.Info(ctx, "MigrateStreetAddressAttributes scanned %d, migrated %d, partial %d, malformed %d, failed %d",
+		report.Scanned, report.Migrated, report.Partial, report.Malformed, report.Failed)
+
+	return report, nil
+}
+
+// migrateStreetAddress migrates a single user. Returns nil when the user has no payload
+func (um *MsGraphUserManager) migrateStreetAddress(ctx context.Context, azUser models.Userable, opts *StreetAddressMigrationOptions) *StreetAddressMigrationResult {
+	if azUser.GetStreetAddress() == nil {
+		return nil
+	}
+
+	result := &StreetAddressMigrationResult{
+		ID:  cloudy.StringFromP(azUser.GetId()),
+		UPN: cloudy.StringFromP(azUser.GetUserPrincipalName()),
+	}
+
+	csa, err := DecodeLegacyStreetAddress(*azUser.GetStreetAddress())
+	if errors.Is(err, ErrNotLegacyStreetAddress) {
+		return nil
+	}
+	if err != nil {
+		cloudy.Warn(ctx, "[%s] MigrateStreetAddressAttributes - %v", result.ID, err)
+		result.Status = StreetAddressMalformed
+		result.Error = err
+		return result
+	}
+
+	patch, attributes, unmapped := legacyStreetAddressPatch(azUser, csa, um.customSecurityAttributes(), opts.RestoreStreetAddress, opts.DiscardUnmapped)
+	result.Attributes = attributes
+	result.Unmapped = unmapped
+
+	status := StreetAddressMigrated
+	if len(unmapped) > 0 && !opts.DiscardUnmapped {
+		cloudy.Warn(ctx, "[%s] MigrateStreetAddressAttributes - streetAddress kept, no mapping for %s", result.ID, strings.Join(unmapped, ", "))
+		status = StreetAddressPartial
+		result.Error = fmt.Errorf("streetAddress kept, no mapping for %s", strings.Join(unmapped, ", "))
+	}
+
+	if opts.DryRun {
+		result.Status = StreetAddressDryRun
+		if status == StreetAddressPartial {
+			result.Status = status
+		}
+		return result
+	}
+
+	_, err = um.Client.Users().ByUserId(result.ID).Patch(ctx, patch, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		result.Status = StreetAddressFailed
+		result.Error = cloudy.Error(ctx, "[%s] MigrateStreetAddressAttributes Error: %s", result.ID, message)
+		return result
+	}
+
+	result.Status = status
+	return result
+}
+
+// legacyStreetAddressPatch builds the patch that writes the decoded attributes and restores
+// or clears streetAddress. streetAddress is left alone when some attributes are not written,
+// unless discardUnmapped is set. Returns the decoded attributes and the ones not written.
+func legacyStreetAddressPatch(azUser models.Userable, csa *UserCustomSecurityAttributes, mappings CustomSecurityAttributeMappings,
+	restore func(user *cloudymodels.User) string, discardUnmapped bool) (*models.User, map[string]interface{}, []string) {
+
+	user, extra := userToCloudy(azUser, mappings, false)
+	attributes := map[string]interface{}{}
+	unmapped := []string{}
+
+	fields, extraFields := csa.fields()
+	for field, value := range fields {
+		if value == "" {
+			continue
+		}
+		attributes[field] = value
+
+		if mappings.field(field) == nil {
+			unmapped = append(unmapped, field)
+		} else if cloudy.GetFieldString(user, field) == "" {
+			cloudy.SetFieldString(user, field, value)
+		}
+	}
+	for name, value := range extraFields {
+		if value == "" {
+			continue
+		}
+		attributes[name] = value
+
+		if mappings.extra(name) == nil {
+			unmapped = append(unmapped, name)
+		} else if _, exists := extra[name]; !exists {
+			extra[name] = value
+		}
+	}
+
+	sort.Strings(unmapped)
+
+	patch := models.NewUser()
+
+	customSecurityAttributes := mappings.ToAzure(user, extra)
+	if customSecurityAttributes != nil {
+		patch.SetCustomSecurityAttributes(customSecurityAttributes)
+	}
+
+	if len(unmapped) > 0 && !discardUnmapped {
+		return patch, attributes, unmapped
+	}
+
+	streetAddress := ""
+	if restore != nil {
+		streetAddress = restore(user)
+	}
+	if streetAddress != "" {
+		patch.SetStreetAddress(&streetAddress)
+	} else {
+		setNull(patch, "streetAddress")
+	}
+
+	return patch, attributes, unmapped
+}
+
+// field finds the mapping of a cloudy User field
+func (mappings CustomSecurityAttributeMappings) field(field string) *CustomSecurityAttributeMapping {
+	for _, m := range mappings {
+		if m.Field == field {
+			return m
+		}
+	}
+	return nil
+}
+
+// extra finds the mapping of an extra attribute
+func (mappings CustomSecurityAttributeMappings) extra(name string) *CustomSecurityAttributeMapping {
+	for _, m := range mappings {
+		if m.Field == "" && m.key() == name {
+			return m
+		}
+	}
+	return nil
+}
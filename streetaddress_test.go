@@ -0,0 +1,96 @@
+package cloudymsgraph
+
+import (
+	b64 "encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLegacyStreetAddress(t *testing.T) {
+	payload := b64.StdEncoding.EncodeToString([]byte(`{"AccountType":"Contractor","ContractNumber":"FA8750-20-C-0001","Sponsor":"jane.doe"}`))
+
+	csa, err := DecodeLegacyStreetAddress(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "Contractor", csa.AccountType)
+	assert.Equal(t, "FA8750-20-C-0001", csa.ContractNumber)
+	assert.Equal(t, "jane.doe", csa.Sponsor)
+
+	_, err = DecodeLegacyStreetAddress("123 Main Street")
+	assert.True(t, errors.Is(err, ErrNotLegacyStreetAddress))
+
+	_, err = DecodeLegacyStreetAddress("")
+	assert.True(t, errors.Is(err, ErrNotLegacyStreetAddress))
+
+	_, err = DecodeLegacyStreetAddress(b64.StdEncoding.EncodeToString([]byte(`{"AccountType":`)))
+	assert.True(t, errors.Is(err, ErrMalformedLegacyStreetAddress))
+}
+
+func TestLegacyStreetAddressPatch(t *testing.T) {
+	payload := b64.StdEncoding.EncodeToString([]byte(`{"AccountType":"Contractor","ContractNumber":"FA8750-20-C-0001","Justification":"Program access"}`))
+
+	azUser := models.NewUser()
+	azUser.SetId(cloudy.StringP("1234"))
+	azUser.SetStreetAddress(&payload)
+
+	// The contract number already held in a custom security attribute is kept
+	csa := models.NewCustomSecurityAttributeValue()
+	csa.GetAdditionalData()["cloudy"] = map[string]interface{}{
+		"ContractNumber": cloudy.StringP("FA8750-21-C-0002"),
+	}
+	azUser.SetCustomSecurityAttributes(csa)
+
+	legacy, err := DecodeLegacyStreetAddress(payload)
+	assert.Nil(t, err)
+
+	mappings := CustomSecurityAttributeMappings{
+		{Field: "AccountType", AttributeSet: "cloudy", Attribute: "AccountType", Type: CustomSecurityAttributeString},
+		{Field: "ContractNumber", AttributeSet: "cloudy", Attribute: "ContractNumber", Type: CustomSecurityAttributeString},
+	}
+
+	patch, attributes, unmapped := legacyStreetAddressPatch(azUser, legacy, mappings, nil, false)
+	assert.Equal(t, map[string]interface{}{
+		"AccountType":    "Contractor",
+		"ContractNumber": "FA8750-20-C-0001",
+		"Justification":  "Program access",
+	}, attributes)
+	assert.Equal(t, []string{"Justification"}, unmapped)
+
+	set := patch.GetCustomSecurityAttributes().GetAdditionalData()["cloudy"].(map[string]interface{})
+	assert.Equal(t, "Contractor", *set["AccountType"].(*string))
+	assert.Equal(t, "FA8750-21-C-0002", *set["ContractNumber"].(*string))
+
+	// the unmapped Justification only lives in streetAddress, so it is kept
+	assert.Nil(t, patch.GetStreetAddress())
+	_, exists := patch.GetAdditionalData()["streetAddress"]
+	assert.False(t, exists)
+
+	patch, _, _ = legacyStreetAddressPatch(azUser, legacy, mappings, nil, true)
+	streetAddress, exists := patch.GetAdditionalData()["streetAddress"]
+	assert.True(t, exists)
+	assert.Nil(t, streetAddress)
+
+	patch, _, _ = legacyStreetAddressPatch(azUser, legacy, mappings, func(user *cloudymodels.User) string {
+		return "123 Main Street"
+	}, true)
+	assert.Equal(t, "123 Main Street", *patch.GetStreetAddress())
+	_, exists = patch.GetAdditionalData()["streetAddress"]
+	assert.False(t, exists)
+}
+
+func TestStreetAddressFallback(t *testing.T) {
+	payload := b64.StdEncoding.EncodeToString([]byte(`{"AccountType":"Contractor"}`))
+
+	azUser := models.NewUser()
+	azUser.SetStreetAddress(&payload)
+
+	u, _ := UserToCloudyWithAttributes(azUser, DefaultCustomSecurityAttributes)
+	assert.Equal(t, "Contractor", u.AccountType)
+
+	graph := &MsGraph{Cfg: &MsGraphConfig{DisableStreetAddressFallback: true}}
+	assert.Equal(t, "", graph.userToCloudy(azUser).AccountType)
+}
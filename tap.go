@@ -0,0 +1,160 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// TemporaryAccessPass is a time limited passcode a user can sign in with to
+// register their other authentication methods. The Pass is only returned by
+// Graph when the pass is created and is empty when listing.
+type TemporaryAccessPass struct {
+	ID                    string
+	Pass                  string
+	StartDateTime         *time.Time
+	LifetimeInMinutes     int
+	IsUsableOnce          bool
+	IsUsable              bool
+	MethodUsabilityReason string
+	Created               *time.Time
+}
+
+type TemporaryAccessPassOptions struct {
+	// LifetimeInMinutes between 10 and 43200 (30 days). Zero uses the tenant default
+	LifetimeInMinutes int
+
+	// IsUsableOnce limits the pass to a single sign in
+	IsUsableOnce bool
+
+	// StartDateTime is when the pass becomes usable. Nil means immediately
+	StartDateTime *time.Time
+}
+
+// ErrUserNotFound is returned when a pass is issued for a user that does not exist, or is
+// not visible to the authentication endpoints yet
+var ErrUserNotFound = errors.New("user not found")
+
+// Number of attempts made to issue a pass for a newly created user, the user
+// is not always visible to the authentication endpoints right away
+const tapCreateAttempts = 5
+const tapRetryDelay = 3 * time.Second
+
+// CreateTemporaryAccessPass issues a new temporary access pass for a user. The returned pass
+// contains the pass value, which cannot be retrieved again.
+func (am *MsGraphAuthMethodManager) CreateTemporaryAccessPass(ctx context.Context, uid string, opts *TemporaryAccessPassOptions) (*TemporaryAccessPass, error) {
+	cloudy.Info(ctx, "[%s] CreateTemporaryAccessPass", uid)
+
+	body := TemporaryAccessPassToAzure(opts)
+
+	result, err := am.Client.Users().ByUserId(uid).Authentication().TemporaryAccessPassMethods().Post(ctx, body, nil)
+	if err != nil {
+		code, message := GetErrorCodeAndMessage(ctx, err)
+
+		if strings.EqualFold(code, ResourceNotFoundCode) {
+			cloudy.Warn(ctx, "[%s] CreateTemporaryAccessPass - ResourceNotFound - %s", uid, message)
+			return nil, ErrUserNotFound
+		}
+
+		return nil, cloudy.Error(ctx, "[%s] CreateTemporaryAccessPass Error: %s", uid, message)
+	}
+
+	return TemporaryAccessPassToCloudy(result), nil
+}
+
+// ListTemporaryAccessPasses lists the temporary access passes of a user. The pass
+// values are never included.
+func (am *MsGraphAuthMethodManager) ListTemporaryAccessPasses(ctx context.Context, uid string) ([]*TemporaryAccessPass, error) {
+	cloudy.Info(ctx, "[%s] ListTemporaryAccessPasses", uid)
+
+	result, err := am.Client.Users().ByUserId(uid).Authentication().TemporaryAccessPassMethods().Get(ctx, nil)
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return nil, cloudy.Error(ctx, "[%s] ListTemporaryAccessPasses Error: %s", uid, message)
+	}
+
+	rtn := []*TemporaryAccessPass{}
+	for _, tap := range result.GetValue() {
+		rtn = append(rtn, TemporaryAccessPassToCloudy(tap))
+	}
+
+	return rtn, nil
+}
+
+// DeleteTemporaryAccessPass removes a temporary access pass from a user
+func (am *MsGraphAuthMethodManager) DeleteTemporaryAccessPass(ctx context.Context, uid string, id string) error {
+	return am.RemoveMethod(ctx, uid, AuthMethodTemporaryAccessPass, id)
+}
+
+// NewUserWithTemporaryAccessPass creates a new user and issues a temporary access pass for
+// them so they can register a CAC or FIDO2 key on their first sign in. If the user is
+// created but the pass cannot be issued the created user is returned along with the error.
+func (um *MsGraphUserManager) NewUserWithTemporaryAccessPass(ctx context.Context, newUser *cloudymodels.User, opts *TemporaryAccessPassOptions) (*cloudymodels.User, *TemporaryAccessPass, error) {
+	created, err := um.NewUser(ctx, newUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	am := &MsGraphAuthMethodManager{MsGraph: um.MsGraph}
+
+	for attempt := 1; ; attempt++ {
+		tap, err := am.CreateTemporaryAccessPass(ctx, created.ID, opts)
+		if err == nil {
+			return created, tap, nil
+		}
+
+		if !errors.Is(err, ErrUserNotFound) || attempt >= tapCreateAttempts {
+			return created, nil, cloudy.Error(ctx, "[%s] NewUserWithTemporaryAccessPass - user created but pass not issued: %v", created.UPN, err)
+		}
+
+		cloudy.Info(ctx, "[%s] NewUserWithTemporaryAccessPass - user not found yet, retrying", created.UPN)
+		select {
+		case <-ctx.Done():
+			return created, nil, cloudy.Error(ctx, "[%s] NewUserWithTemporaryAccessPass - user created but pass not issued: %v", created.UPN, ctx.Err())
+		case <-time.After(tapRetryDelay):
+		}
+	}
+}
+
+func TemporaryAccessPassToAzure(opts *TemporaryAccessPassOptions) *models.TemporaryAccessPassAuthenticationMethod {
+	body := models.NewTemporaryAccessPassAuthenticationMethod()
+	if opts == nil {
+		return body
+	}
+
+	if opts.LifetimeInMinutes > 0 {
+		lifetime := int32(opts.LifetimeInMinutes)
+		body.SetLifetimeInMinutes(&lifetime)
+	}
+
+	body.SetIsUsableOnce(cloudy.BoolP(opts.IsUsableOnce))
+
+	if opts.StartDateTime != nil {
+		body.SetStartDateTime(opts.StartDateTime)
+	}
+
+	return body
+}
+
+func TemporaryAccessPassToCloudy(tap models.TemporaryAccessPassAuthenticationMethodable) *TemporaryAccessPass {
+	rtn := &TemporaryAccessPass{
+		ID:                    cloudy.StringFromP(tap.GetId()),
+		Pass:                  cloudy.StringFromP(tap.GetTemporaryAccessPass()),
+		StartDateTime:         tap.GetStartDateTime(),
+		IsUsableOnce:          cloudy.BoolFromP(tap.GetIsUsableOnce()),
+		IsUsable:              cloudy.BoolFromP(tap.GetIsUsable()),
+		MethodUsabilityReason: cloudy.StringFromP(tap.GetMethodUsabilityReason()),
+		Created:               tap.GetCreatedDateTime(),
+	}
+
+	if tap.GetLifetimeInMinutes() != nil {
+		rtn.LifetimeInMinutes = int(*tap.GetLifetimeInMinutes())
+	}
+
+	return rtn
+}
@@ -0,0 +1,38 @@
+package cloudymsgraph
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemporaryAccessPassModel(t *testing.T) {
+	start := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	opts := &TemporaryAccessPassOptions{
+		LifetimeInMinutes: 480,
+		IsUsableOnce:      true,
+		StartDateTime:     &start,
+	}
+
+	azTap := TemporaryAccessPassToAzure(opts)
+	assert.Equal(t, int32(480), *azTap.GetLifetimeInMinutes())
+	assert.True(t, *azTap.GetIsUsableOnce())
+	assert.Equal(t, start, *azTap.GetStartDateTime())
+
+	azTap.SetId(cloudy.StringP("tap-id"))
+	azTap.SetTemporaryAccessPass(cloudy.StringP("TAPRocks!"))
+	azTap.SetIsUsable(cloudy.BoolP(true))
+
+	tap := TemporaryAccessPassToCloudy(azTap)
+	assert.Equal(t, "tap-id", tap.ID)
+	assert.Equal(t, "TAPRocks!", tap.Pass)
+	assert.Equal(t, 480, tap.LifetimeInMinutes)
+	assert.True(t, tap.IsUsableOnce)
+	assert.True(t, tap.IsUsable)
+
+	defaults := TemporaryAccessPassToAzure(nil)
+	assert.Nil(t, defaults.GetLifetimeInMinutes())
+	assert.Nil(t, defaults.GetIsUsableOnce())
+}
@@ -2,6 +2,7 @@ package cloudymsgraph
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -32,6 +33,9 @@ func (umf *MsGraphUserManagerFactory) FromEnv(env *cloudy.Environment) (interfac
 
 type MsGraphUserManager struct {
 	*MsGraph
+
+	photos  profilePhotoCache
+	domains domainCache
 }
 
 func NewMsGraphUserManager(ctx context.Context, cfg *MsGraphConfig) (*MsGraphUserManager, error) {
@@ -62,15 +66,32 @@ func fromEnvironment(env *cloudy.Environment) *MsGraphConfig {
 	cfg.ClientSecret = env.Force("AZ_CLIENT_SECRET")
 	cfg.Region = env.Default("AZ_REGION", "usgovvirginia")
 	cfg.APIBase = env.Default("AZ_API_BASE", "https://graph.microsoft.us/v1.0")
+	cfg.MailSender = env.Default("AZ_MAIL_SENDER", "")
+	cfg.DriveSiteID = env.Default("AZ_DRIVE_SITE_ID", "")
+	cfg.DriveID = env.Default("AZ_DRIVE_ID", "")
 
 	return cfg
 }
 
 func (um *MsGraphUserManager) NewUser(ctx context.Context, newUser *cloudymodels.User) (*cloudymodels.User, error) {
+	created, _, err := um.NewUserWithAttributes(ctx, newUser, nil)
+	return created, err
+}
+
+// NewUserWithAttributes creates a new user with the extra custom security attributes, keyed
+// by the name of their mapping. Returns the created user and their extra attributes. The
+// domains are checked first, see DisableDomainValidation.
+func (um *MsGraphUserManager) NewUserWithAttributes(ctx context.Context, newUser *cloudymodels.User, attributes map[string]interface{}) (*cloudymodels.User, map[string]interface{}, error) {
 
 	cloudy.Info(ctx, "[%s] MsGraphUserManager NewUser", newUser.UPN)
 
-	body := UserToAzure(newUser)
+	err := um.validateUserDomains(ctx, newUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mappings := um.customSecurityAttributes()
+	body := UserToAzureWithAttributes(newUser, attributes, mappings)
 	body.SetAccountEnabled(cloudy.BoolP(true))
 
 	user, err := um.Client.Users().Post(ctx, body, nil)
@@ -78,22 +99,73 @@ func (um *MsGraphUserManager) NewUser(ctx context.Context, newUser *cloudymodels
 		code, message := GetErrorCodeAndMessage(ctx, err)
 
 		if strings.EqualFold(code, BadRequest) {
-			return nil, cloudy.Error(ctx, "[%s] NewUser - BadRequest - %s", newUser.UPN, message)
+			return nil, nil, cloudy.Error(ctx, "[%s] NewUser - BadRequest - %s", newUser.UPN, message)
 		} else {
-			return nil, cloudy.Error(ctx, "[%s] NewUser - %s - Error: %v", newUser.UPN, message, err)
+			return nil, nil, cloudy.Error(ctx, "[%s] NewUser - %s - Error: %v", newUser.UPN, message, err)
 		}
 	}
 
-	created := UserToCloudy(user)
-	return created, nil
+	created, createdAttributes := um.userToCloudyWithAttributes(user)
+	return created, createdAttributes, nil
+}
+
+// validateUserDomains checks the properties, userPrincipalName and mail when none are given,
+// use verified domains, unless turned off with DisableDomainValidation. Guests are not checked.
+func (um *MsGraphUserManager) validateUserDomains(ctx context.Context, user *cloudymodels.User, properties ...string) error {
+	if um.Cfg != nil && um.Cfg.DisableDomainValidation {
+		return nil
+	}
+	if isGuestUserPrincipalName(user.UPN) {
+		return nil
+	}
+	return um.domainManager().ValidateUserDomains(ctx, user, properties...)
+}
+
+// isGuestUser returns true for invited users, read from the user attributes when userType
+// was selected and from Graph otherwise
+func (um *MsGraphUserManager) isGuestUser(ctx context.Context, user *cloudymodels.User, attributes map[string]interface{}) bool {
+	if isGuestUserPrincipalName(user.UPN) {
+		return true
+	}
+	if userType, ok := lookupFold(attributes, "userType"); ok {
+		value, _ := toString(userType)
+		return strings.EqualFold(value, UserTypeGuest)
+	}
+
+	result, err := um.Client.Users().ByUserId(user.ID).Get(ctx,
+		&users.UserItemRequestBuilderGetRequestConfiguration{
+			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
+				Select: []string{"userType"},
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		cloudy.Warn(ctx, "[%s] unable to read the user type: %s", user.ID, message)
+		return false
+	}
+	return strings.EqualFold(cloudy.StringFromP(result.GetUserType()), UserTypeGuest)
+}
+
+// isGuestUserPrincipalName returns true for the user principal names of invited users,
+// e.g. jane_contoso.com#EXT#@example.com
+func isGuestUserPrincipalName(upn string) bool {
+	return strings.Contains(strings.ToUpper(upn), "#EXT#")
 }
 
 func (um *MsGraphUserManager) GetUser(ctx context.Context, uid string) (*cloudymodels.User, error) {
+	u, _, err := um.GetUserWithAttributes(ctx, uid)
+	return u, err
+}
+
+// GetUserWithAttributes retrieves a user along with the extra custom security attributes,
+// keyed by the name of their mapping, and the unmapped properties selected with
+// WithUserSelectFields, keyed by property name. Returns nil if the user does not exist.
+func (um *MsGraphUserManager) GetUserWithAttributes(ctx context.Context, uid string) (*cloudymodels.User, map[string]interface{}, error) {
 	cloudy.Info(ctx, "[%s] GetUser", uid)
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
-	fields := DefaultUserSelectFields
+	fields := um.userSelectFields(ctx, nil)
 
 	result, err := um.Client.Users().ByUserId(uid).Get(ctx,
 		&users.UserItemRequestBuilderGetRequestConfiguration{
@@ -107,25 +179,35 @@ func (um *MsGraphUserManager) GetUser(ctx context.Context, uid string) (*cloudym
 
 		if strings.EqualFold(code, ResourceNotFoundCode) {
 			cloudy.Info(ctx, "[%s] GetUser - ResourceNotFound - %s", uid, message)
-			return nil, nil
+			return nil, nil, nil
 		}
 
-		return nil, cloudy.Error(ctx, "[%s] GetUser - error: %v", uid, message)
+		return nil, nil, cloudy.Error(ctx, "[%s] GetUser - error: %v", uid, message)
 	}
 
-	return UserToCloudy(result), nil
+	u, attributes := um.userToCloudyWithSelect(ctx, result, fields)
+	return u, attributes, nil
 }
 
 func (um *MsGraphUserManager) GetUserByEmail(ctx context.Context, email string, opts *cloudy.UserOptions) (*cloudymodels.User, error) {
+	u, _, err := um.GetUserByEmailWithAttributes(ctx, email, opts)
+	return u, err
+}
+
+// GetUserByEmailWithAttributes retrieves the user with the email along with the extra custom
+// security attributes and the unmapped properties selected with WithUserSelectFields, as
+// GetUserWithAttributes does. Returns nil if no user has the email.
+func (um *MsGraphUserManager) GetUserByEmailWithAttributes(ctx context.Context, email string, opts *cloudy.UserOptions) (*cloudymodels.User, map[string]interface{}, error) {
 
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
 	requestFilter := fmt.Sprintf("mail eq '%v'", email)
 	count := true
+	fields := um.userSelectFields(ctx, opts, SigninActivityField)
 	requestParameters := &users.UsersRequestBuilderGetQueryParameters{
 		Filter: &requestFilter,
-		Select: append(DefaultUserSelectFields, SigninActivityField),
+		Select: fields,
 		Count:  &count,
 	}
 	configuration := &users.UsersRequestBuilderGetRequestConfiguration{
@@ -138,46 +220,57 @@ func (um *MsGraphUserManager) GetUserByEmail(ctx context.Context, email string,
 		code, message := GetErrorCodeAndMessage(ctx, err)
 
 		if strings.EqualFold(code, ResourceNotFoundCode) {
-			return nil, cloudy.Error(ctx, "GetUserByEmail Error: %s - ResourceNotFound - %s", email, message)
+			return nil, nil, cloudy.Error(ctx, "GetUserByEmail Error: %s - ResourceNotFound - %s", email, message)
 		}
 
-		return nil, cloudy.Error(ctx, "GetUserByEmail Error: %s %s", email, message)
+		return nil, nil, cloudy.Error(ctx, "GetUserByEmail Error: %s %s", email, message)
 	}
 
 	var rtn []*cloudymodels.User
+	var rtnAttributes []map[string]interface{}
 	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, um.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
-		rtn = append(rtn, UserToCloudy(pageItem))
+		u, attributes := um.userToCloudyWithSelect(ctx, pageItem, fields)
+		rtn = append(rtn, u)
+		rtnAttributes = append(rtnAttributes, attributes)
 		return true
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(rtn) == 0 {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	if opts != nil && opts.IncludeLastSignIn != nil && *opts.IncludeLastSignIn {
 		//OK ... this is really strange... we need to request "JUST" the "signinactivity" since it fig
 	}
 
-	return rtn[0], nil
+	return rtn[0], rtnAttributes[0], nil
 }
 
 func (um *MsGraphUserManager) ListUsers(ctx context.Context, page interface{}, filter interface{}) ([]*cloudymodels.User, interface{}, error) {
+	rtn, _, err := um.ListUsersWithAttributes(ctx)
+	return rtn, nil, err
+}
+
+// ListUsersWithAttributes lists the users along with their extra custom security attributes
+// and the unmapped properties selected with WithUserSelectFields, keyed by user id
+func (um *MsGraphUserManager) ListUsersWithAttributes(ctx context.Context) ([]*cloudymodels.User, map[string]map[string]interface{}, error) {
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
+	fields := um.userSelectFields(ctx, nil)
 	// requestCount := true
 	result, err := um.Client.Users().Get(ctx,
 		&users.UsersRequestBuilderGetRequestConfiguration{
 			Headers: headers,
 			QueryParameters: &users.UsersRequestBuilderGetQueryParameters{
 				// Count:  &requestCount,
-				Select: DefaultUserSelectFields,
+				Select: fields,
 			},
 		})
 	if err != nil {
@@ -185,178 +278,219 @@ func (um *MsGraphUserManager) ListUsers(ctx context.Context, page interface{}, f
 	}
 
 	var rtn []*cloudymodels.User
+	rtnAttributes := make(map[string]map[string]interface{})
 	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](result, um.Adapter, models.CreateUserCollectionResponseFromDiscriminatorValue)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
-		rtn = append(rtn, UserToCloudy(pageItem))
+		u, attributes := um.userToCloudyWithSelect(ctx, pageItem, fields)
+		rtn = append(rtn, u)
+		rtnAttributes[u.ID] = attributes
 		return true
 	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return rtn, nil, nil
+	return rtn, rtnAttributes, nil
 }
 
 func (um *MsGraphUserManager) UpdateUser(ctx context.Context, usr *cloudymodels.User) error {
-	if strings.EqualFold(usr.ID, "") {
-		return cloudy.Error(ctx, "Not user id set. Cannot update user: %v", usr)
-	}
+	return um.UpdateUserWithAttributes(ctx, usr, nil)
+}
 
-	currentUser, err := um.GetUser(ctx, usr.ID)
-	if err != nil {
-		_, message := GetErrorCodeAndMessage(ctx, err)
+// UpdateUserWithAttributes updates the user along with the extra custom security attributes,
+// keyed by the name of their mapping.
+func (um *MsGraphUserManager) UpdateUserWithAttributes(ctx context.Context, usr *cloudymodels.User, attributes map[string]interface{}) error {
+	_, err := um.UpdateUserWithChanges(ctx, usr, attributes)
+	return err
+}
 
-		return cloudy.Error(ctx, "UpdateUser Get Error %s", message)
+// UpdateUserWithChanges updates only the properties of the user that changed and returns
+// the changes made. Nothing is sent when the user is unchanged. Enabling or disabling the
+// account goes through Enable and Disable, when the sessions of a disabled user cannot be
+// revoked the changes are returned with ErrRevokeSessionsFailed.
+func (um *MsGraphUserManager) UpdateUserWithChanges(ctx context.Context, usr *cloudymodels.User, attributes map[string]interface{}) ([]*UserChange, error) {
+	if strings.EqualFold(usr.ID, "") {
+		return nil, cloudy.Error(ctx, "Not user id set. Cannot update user: %v", usr)
 	}
 
-	azUser := UserToPatch(usr, currentUser)
-
-	cloudy.Info(ctx, "Updating user with ID: %s (%s)", currentUser.ID, currentUser.UPN)
-
-	_, err = um.Client.Users().ByUserId(usr.ID).Patch(ctx, azUser, nil)
-
+	currentUser, currentAttributes, err := um.GetUserWithAttributes(ctx, usr.ID)
 	if err != nil {
 		_, message := GetErrorCodeAndMessage(ctx, err)
 
-		return cloudy.Error(ctx, "UpdateUser Patch Error %s", message)
+		return nil, cloudy.Error(ctx, "UpdateUser Get Error %s", message)
+	}
+	if currentUser == nil {
+		return nil, cloudy.Error(ctx, "[%s] UpdateUser - user not found", usr.ID)
 	}
 
-	return err
+	return um.updateUserWithChanges(ctx, usr, attributes, currentUser, currentAttributes)
 }
 
-func (um *MsGraphUserManager) Enable(ctx context.Context, uid string) error {
-	u := models.NewUser()
-	u.SetAccountEnabled(cloudy.BoolP(true))
+// userUpdatePatch returns the patch of the properties of the user that differ from the current
+// user and the changes. The domains of a changed userPrincipalName or mail are validated.
+func (um *MsGraphUserManager) userUpdatePatch(ctx context.Context, usr *cloudymodels.User, attributes map[string]interface{},
+	currentUser *cloudymodels.User, currentAttributes map[string]interface{}) (*models.User, []*UserChange, error) {
 
-	_, err := um.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
-	return err
-}
+	azUser, changes := UserToPatchWithAttributes(usr, currentUser, attributes, currentAttributes, um.customSecurityAttributes())
 
-func (um *MsGraphUserManager) UploadProfilePicture(ctx context.Context, uid string, picture []byte) error {
-	u, err := um.Client.Users().ByUserId(uid).Get(ctx, nil)
-	if err != nil {
-		return err
+	domainChanges := []string{}
+	for _, c := range changes {
+		if c.Property == "userPrincipalName" || c.Property == "mail" {
+			domainChanges = append(domainChanges, c.Property)
+		}
+	}
+	if len(domainChanges) > 0 && !um.isGuestUser(ctx, currentUser, currentAttributes) {
+		err := um.validateUserDomains(ctx, usr, domainChanges...)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	id := *u.GetId()
 
-	_, err = um.Client.Users().ByUserId(id).Photo().Content().Put(ctx, picture, nil)
-	return err
+	return azUser, changes, nil
 }
 
-func (um *MsGraphUserManager) GetProfilePicture(ctx context.Context, uid string) ([]byte, error) {
-	cloudy.Info(ctx, "GetProfilePicture for %s", uid)
+// updateUserWithChanges patches the properties of the user that differ from the current user.
+// A change of accountEnabled is applied with Enable or Disable once the patch is sent.
+func (um *MsGraphUserManager) updateUserWithChanges(ctx context.Context, usr *cloudymodels.User, attributes map[string]interface{},
+	currentUser *cloudymodels.User, currentAttributes map[string]interface{}) ([]*UserChange, error) {
 
-	u, err := um.Client.Users().ByUserId(uid).Get(ctx, nil)
+	azUser, changes, err := um.userUpdatePatch(ctx, usr, attributes, currentUser, currentAttributes)
 	if err != nil {
 		return nil, err
 	}
-
-	if u == nil {
-		cloudy.Info(ctx, "GetProfilePicture for %s - No user found", uid)
-		return nil, nil
+	if len(changes) == 0 {
+		cloudy.Info(ctx, "[%s] UpdateUser - no changes", usr.ID)
+		return changes, nil
 	}
 
-	photo, err := um.Client.Users().ByUserId(*u.GetId()).Photo().Content().Get(ctx, nil)
-	if err != nil {
-		code, message := GetErrorCodeAndMessage(ctx, err)
+	enabledChanged := azUser.GetAccountEnabled() != nil
+	azUser.SetAccountEnabled(nil)
 
-		if strings.EqualFold(code, ImageNotFoundCode) {
-			cloudy.Warn(ctx, "GetProfilePicture  %s - ImageNotFoundCode - %s", uid, message)
-			return nil, nil
-		}
+	cloudy.Info(ctx, "Updating user with ID: %s (%s)", currentUser.ID, currentUser.UPN)
 
-		return nil, cloudy.Error(ctx, "GetProfilePicture Error: %s %s", uid, message)
-	}
+	if len(changes) > 1 || !enabledChanged {
+		_, err = um.Client.Users().ByUserId(usr.ID).Patch(ctx, azUser, nil)
 
-	return photo, err
-}
+		if err != nil {
+			_, message := GetErrorCodeAndMessage(ctx, err)
 
-// Associates a certificate ID as a second factor authentication
-func (um *MsGraphUserManager) GetCertificateMFA(ctx context.Context, uid string) ([]string, error) {
-	azUser, err := um.Client.Users().ByUserId(uid).Get(ctx,
-		&users.UserItemRequestBuilderGetRequestConfiguration{
-			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
-				Select: DefaultUserSelectFields,
-			},
-		})
+			return nil, cloudy.Error(ctx, "UpdateUser Patch Error %s", message)
+		}
+	}
 
-	if err != nil {
-		return nil, err
+	if enabledChanged {
+		if usr.Enabled {
+			err = um.Enable(ctx, usr.ID)
+		} else {
+			err = um.Disable(ctx, usr.ID)
+		}
+		if errors.Is(err, ErrRevokeSessionsFailed) {
+			cloudy.Warn(ctx, "[%s] UpdateUser - %v", usr.ID, err)
+			return changes, err
+		}
+		if err != nil {
+			return nil, cloudy.Error(ctx, "[%s] UpdateUser - unable to change accountEnabled: %s", usr.ID, errorMessage(ctx, err))
+		}
 	}
 
-	info := azUser.GetAuthorizationInfo()
-	certIds := info.GetCertificateUserIds()
+	return changes, nil
+}
 
-	return certIds, nil
+func (um *MsGraphUserManager) Enable(ctx context.Context, uid string) error {
+	u := models.NewUser()
+	u.SetAccountEnabled(cloudy.BoolP(true))
+
+	_, err := um.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
+	return err
 }
 
 // Associates a certificate ID as a second factor authentication
-func (um *MsGraphUserManager) AssocateCerificateMFA(ctx context.Context, uid string, certId string, replace bool) error {
-	if !strings.HasPrefix(certId, "X509:<PN>") {
-		certId = fmt.Sprintf("X509:<PN>%v", certId)
-	}
-
-	azUser, err := um.Client.Users().ByUserId(uid).Get(ctx,
-		&users.UserItemRequestBuilderGetRequestConfiguration{
-			QueryParameters: &users.UserItemRequestBuilderGetQueryParameters{
-				Select: DefaultUserSelectFields,
-			},
-		})
+func (um *MsGraphUserManager) GetCertificateMFA(ctx context.Context, uid string) ([]string, error) {
+	return um.getCertificateUserIds(ctx, uid)
+}
 
-	if err != nil {
-		return err
+// Associates a certificate ID as a second factor authentication. The certId can be any
+// certificateUserIds value, values without the X509: prefix are bound as a principal name.
+func (um *MsGraphUserManager) AssocateCerificateMFA(ctx context.Context, uid string, certId string, replace bool) error {
+	binding := NewPrincipalNameBinding(certId)
+	if hasCertificateUserIdPrefix(certId) {
+		parsed, err := ParseCertificateBinding(certId)
+		if err != nil {
+			return cloudy.Error(ctx, "[%s] AssocateCerificateMFA - %v", uid, err)
+		}
+		binding = parsed
 	}
 
-	info := azUser.GetAuthorizationInfo()
-	certIds := info.GetCertificateUserIds()
-
-	var newCertIds []string
 	if replace {
-		newCertIds = append(newCertIds, certId)
-	} else {
-		newCertIds = append(certIds, certId)
+		return um.SetCertificateBindings(ctx, uid, []*CertificateBinding{binding})
 	}
 
-	info.SetCertificateUserIds(newCertIds)
-
-	_, err = um.Client.Users().ByUserId(uid).Patch(ctx, azUser, nil)
-	return err
+	return um.AddCertificateBindings(ctx, uid, binding)
 }
 
+// Disable disables the account. When RevokeSessionsOnDisable is configured the
+// sign in sessions of the user are revoked once the account is disabled. If the
+// revocation fails the account stays disabled and ErrRevokeSessionsFailed is returned.
 func (um *MsGraphUserManager) Disable(ctx context.Context, uid string) error {
 	u := models.NewUser()
 	u.SetAccountEnabled(cloudy.BoolP(false))
 	_, err := um.Client.Users().ByUserId(uid).Patch(ctx, u, nil)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if um.Cfg.RevokeSessionsOnDisable {
+		err = um.RevokeSignInSessions(ctx, uid)
+		if err != nil {
+			return fmt.Errorf("[%s] Disable - account disabled but %w: %v", uid, ErrRevokeSessionsFailed, err)
+		}
+	}
+
+	return nil
 }
 
+// DeleteUser deletes the user. When RevokeSessionsOnDisable is configured the
+// sign in sessions are revoked first and the user is not deleted if that fails.
 func (um *MsGraphUserManager) DeleteUser(ctx context.Context, uid string) error {
 	cloudy.Info(ctx, "MsGraphUserManager DeleteUser")
+
+	if um.Cfg.RevokeSessionsOnDisable {
+		err := um.RevokeSignInSessions(ctx, uid)
+		if err != nil {
+			return cloudy.Error(ctx, "[%s] DeleteUser - user not deleted: %v", uid, err)
+		}
+	}
+
 	err := um.Client.Users().ByUserId(uid).Delete(ctx, nil)
 	return err
 }
 
-func (um *MsGraphUserManager) ForceUserName(ctx context.Context, name string) (string, bool, error) {
-	u, err := um.GetUser(ctx, name)
+// RevokeSignInSessions invalidates all the refresh tokens and session cookies
+// issued to the user, forcing them to sign in again.
+func (um *MsGraphUserManager) RevokeSignInSessions(ctx context.Context, uid string) error {
+	cloudy.Info(ctx, "[%s] RevokeSignInSessions", uid)
+
+	result, err := um.Client.Users().ByUserId(uid).RevokeSignInSessions().PostAsRevokeSignInSessionsPostResponse(ctx, nil)
 	if err != nil {
-		return name, false, err
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return cloudy.Error(ctx, "[%s] RevokeSignInSessions Error: %s", uid, message)
 	}
 
-	if u != nil {
-		return name, true, nil
+	if result != nil && result.GetValue() != nil && !*result.GetValue() {
+		return cloudy.Error(ctx, "[%s] RevokeSignInSessions - sessions were not revoked", uid)
 	}
 
-	return name, false, nil
+	return nil
 }
 
 func (um *MsGraphUserManager) getUserWithCSA(ctx context.Context, uid string) (*cloudymodels.User, error) {
 	cloudy.Info(ctx, "[%s] getUserWithCSA", uid)
 
-	selectFields := append(DefaultUserSelectFields, "customSecurityAttributes")
+	selectFields := um.userSelectFields(ctx, nil, "customSecurityAttributes")
 	headers := abstractions.NewRequestHeaders()
 
 	headers.Add("ConsistencyLevel", "eventual")
@@ -379,5 +513,5 @@ func (um *MsGraphUserManager) getUserWithCSA(ctx context.Context, uid string) (*
 		return nil, cloudy.Error(ctx, "getUserWithCSA Error: %s %s", uid, message)
 	}
 
-	return UserToCloudy(result), nil
+	return um.userToCloudy(result), nil
 }
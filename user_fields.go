@@ -0,0 +1,339 @@
+package cloudymsgraph
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// UserFieldMapping maps a Graph user property to a field of the cloudy User or, when
+// Field is empty, to an entry in the user attributes map keyed by Property. Values are
+// handled as strings, dates are formatted as RFC3339.
+type UserFieldMapping struct {
+	// Property is the Graph property, also used in $select
+	Property string
+
+	// Field is the name of the cloudy User field
+	Field string
+
+	// Required properties are never cleared
+	Required bool
+
+	// ReadOnly properties are read but never written
+	ReadOnly bool
+
+	// OptIn properties need extra permissions and are not in DefaultUserSelectFields. They
+	// are read when added to MsGraphConfig.SelectFields or with WithUserSelectFields.
+	OptIn bool
+
+	get   func(models.Userable) (string, bool)
+	set   func(models.Userable, string)
+	clear func(models.Userable)
+	equal func(string, string) bool
+}
+
+// UserFieldMappings is the set of user properties read and written by the user manager
+type UserFieldMappings []*UserFieldMapping
+
+// DefaultUserFieldMappings drives $select, UserToAzure, UserToPatch and UserToCloudy
+var DefaultUserFieldMappings = UserFieldMappings{
+	stringField("id", "ID", models.Userable.GetId, models.Userable.SetId).readOnly(),
+	stringField("userPrincipalName", "UPN", models.Userable.GetUserPrincipalName, models.Userable.SetUserPrincipalName).required(),
+	stringField("displayName", "DisplayName", models.Userable.GetDisplayName, models.Userable.SetDisplayName).required(),
+	stringField("givenName", "FirstName", models.Userable.GetGivenName, models.Userable.SetGivenName),
+	stringField("surname", "LastName", models.Userable.GetSurname, models.Userable.SetSurname),
+	stringField("mail", "Email", models.Userable.GetMail, models.Userable.SetMail),
+	stringField("companyName", "Company", models.Userable.GetCompanyName, models.Userable.SetCompanyName),
+	stringField("jobTitle", "JobTitle", models.Userable.GetJobTitle, models.Userable.SetJobTitle),
+	stringField("department", "Department", models.Userable.GetDepartment, models.Userable.SetDepartment),
+	stringField("mobilePhone", "MobilePhone", models.Userable.GetMobilePhone, models.Userable.SetMobilePhone),
+	businessPhonesField("OfficePhone"),
+	accountEnabledField("Enabled"),
+	stringField("officeLocation", "", models.Userable.GetOfficeLocation, models.Userable.SetOfficeLocation),
+	stringField("employeeId", "", models.Userable.GetEmployeeId, models.Userable.SetEmployeeId),
+	stringField("employeeType", "", models.Userable.GetEmployeeType, models.Userable.SetEmployeeType),
+	dateField("employeeHireDate", "", models.Userable.GetEmployeeHireDate, models.Userable.SetEmployeeHireDate),
+	dateField("employeeLeaveDateTime", "", models.Userable.GetEmployeeLeaveDateTime, models.Userable.SetEmployeeLeaveDateTime).optIn(),
+	stringField("usageLocation", "", models.Userable.GetUsageLocation, models.Userable.SetUsageLocation),
+	stringField("city", "", models.Userable.GetCity, models.Userable.SetCity),
+	stringField("state", "", models.Userable.GetState, models.Userable.SetState),
+	stringField("country", "", models.Userable.GetCountry, models.Userable.SetCountry),
+	stringField("postalCode", "", models.Userable.GetPostalCode, models.Userable.SetPostalCode),
+	stringField("preferredLanguage", "", models.Userable.GetPreferredLanguage, models.Userable.SetPreferredLanguage),
+}
+
+// UserLifecycleSelectFields are the opt in lifecycle properties, reading them needs the
+// User-LifeCycleInfo.Read.All permission
+var UserLifecycleSelectFields = []string{"employeeLeaveDateTime"}
+
+// Properties lists the Graph properties of the mappings
+func (mappings UserFieldMappings) Properties() []string {
+	rtn := []string{}
+	for _, m := range mappings {
+		rtn = append(rtn, m.Property)
+	}
+	return rtn
+}
+
+// defaultProperties lists the Graph properties of the mappings that are not opt in
+func (mappings UserFieldMappings) defaultProperties() []string {
+	rtn := []string{}
+	for _, m := range mappings {
+		if !m.OptIn {
+			rtn = append(rtn, m.Property)
+		}
+	}
+	return rtn
+}
+
+// toAzure writes the non empty values of the user and the attributes to the Graph user
+func (mappings UserFieldMappings) toAzure(user *cloudymodels.User, attributes map[string]interface{}, u models.Userable) {
+	for _, m := range mappings {
+		if m.ReadOnly {
+			continue
+		}
+
+		value, _ := m.fromCloudy(user, attributes)
+		if value != "" {
+			m.set(u, value)
+		}
+	}
+}
+
+// toCloudy reads the Graph user into the user fields and attributes
+func (mappings UserFieldMappings) toCloudy(u models.Userable, user *cloudymodels.User, attributes map[string]interface{}) {
+	for _, m := range mappings {
+		value, ok := m.get(u)
+		if !ok {
+			continue
+		}
+
+		if m.Field != "" {
+			setUserField(user, m.Field, value)
+		} else {
+			attributes[m.Property] = value
+		}
+	}
+}
+
+// diff writes the values that changed to the patch and returns the changes. Properties
+// kept in the attributes map are only compared when present in attributes.
+func (mappings UserFieldMappings) diff(user *cloudymodels.User, attributes map[string]interface{},
+	current *cloudymodels.User, currentAttributes map[string]interface{}, patch models.Userable) []*UserChange {
+
+	changes := []*UserChange{}
+	for _, m := range mappings {
+		if m.ReadOnly {
+			continue
+		}
+
+		value, ok := m.fromCloudy(user, attributes)
+		if !ok {
+			continue
+		}
+		old, _ := m.fromCloudy(current, currentAttributes)
+
+		if m.same(value, old) || (value == "" && m.Required) {
+			continue
+		}
+
+		if value == "" {
+			m.clear(patch)
+		} else {
+			m.set(patch, value)
+		}
+		changes = append(changes, &UserChange{Property: m.Property, Old: old, New: value})
+	}
+	return changes
+}
+
+// fromCloudy returns the value of the mapping in the user or the attributes. Returns
+// false when the attribute is not in the map.
+func (m *UserFieldMapping) fromCloudy(user *cloudymodels.User, attributes map[string]interface{}) (string, bool) {
+	if m.Field != "" {
+		return userField(user, m.Field), true
+	}
+
+	raw, exists := attributes[m.Property]
+	if !exists {
+		return "", false
+	}
+
+	switch v := raw.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	case *time.Time:
+		if v != nil {
+			return v.Format(time.RFC3339), true
+		}
+		return "", true
+	}
+
+	value, _ := toString(raw)
+	return value, true
+}
+
+// same returns true when the values are equal
+func (m *UserFieldMapping) same(value string, old string) bool {
+	if m.equal != nil {
+		return m.equal(value, old)
+	}
+	return value == old
+}
+
+func (m *UserFieldMapping) optIn() *UserFieldMapping {
+	m.OptIn = true
+	return m
+}
+
+func (m *UserFieldMapping) required() *UserFieldMapping {
+	m.Required = true
+	return m
+}
+
+func (m *UserFieldMapping) readOnly() *UserFieldMapping {
+	m.ReadOnly = true
+	return m
+}
+
+func stringField(property string, field string, get func(models.Userable) *string, set func(models.Userable, *string)) *UserFieldMapping {
+	return &UserFieldMapping{
+		Property: property,
+		Field:    field,
+		get: func(u models.Userable) (string, bool) {
+			if get(u) == nil {
+				return "", false
+			}
+			return *get(u), true
+		},
+		set: func(u models.Userable, value string) {
+			set(u, &value)
+		},
+		clear: func(u models.Userable) {
+			setNull(u, property)
+		},
+	}
+}
+
+// dateField maps a date property. Values that are not RFC3339 or yyyy-mm-dd dates are not written
+func dateField(property string, field string, get func(models.Userable) *time.Time, set func(models.Userable, *time.Time)) *UserFieldMapping {
+	return &UserFieldMapping{
+		Property: property,
+		Field:    field,
+		get: func(u models.Userable) (string, bool) {
+			if get(u) == nil {
+				return "", false
+			}
+			return get(u).UTC().Format(time.RFC3339), true
+		},
+		set: func(u models.Userable, value string) {
+			t, _, err := parseUserDate(value)
+			if err == nil {
+				set(u, &t)
+			}
+		},
+		clear: func(u models.Userable) {
+			setNull(u, property)
+		},
+		equal: sameUserDate,
+	}
+}
+
+// parseUserDate parses a RFC3339 or yyyy-mm-dd date, returning true for a yyyy-mm-dd date
+func parseUserDate(value string) (time.Time, bool, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+// sameUserDate compares the dates at the precision of the least precise one, so a
+// yyyy-mm-dd date equals the midnight UTC date Graph returns for it
+func sameUserDate(value string, old string) bool {
+	t, valueDateOnly, err := parseUserDate(value)
+	if err != nil {
+		return value == old
+	}
+	oldT, oldDateOnly, err := parseUserDate(old)
+	if err != nil {
+		return false
+	}
+
+	if valueDateOnly || oldDateOnly {
+		return t.UTC().Format("2006-01-02") == oldT.UTC().Format("2006-01-02")
+	}
+	return t.Equal(oldT)
+}
+
+// businessPhonesField maps the first business phone, the only one that can be set for a user
+func businessPhonesField(field string) *UserFieldMapping {
+	return &UserFieldMapping{
+		Property: "businessPhones",
+		Field:    field,
+		get: func(u models.Userable) (string, bool) {
+			if len(u.GetBusinessPhones()) == 0 {
+				return "", false
+			}
+			return u.GetBusinessPhones()[0], true
+		},
+		set: func(u models.Userable, value string) {
+			u.SetBusinessPhones([]string{value})
+		},
+		clear: func(u models.Userable) {
+			u.SetBusinessPhones([]string{})
+		},
+	}
+}
+
+// accountEnabledField maps accountEnabled. UpdateUser applies a change of it with Enable or
+// Disable rather than the patch, so disabling revokes the sign in sessions
+func accountEnabledField(field string) *UserFieldMapping {
+	return &UserFieldMapping{
+		Property: "accountEnabled",
+		Field:    field,
+		Required: true,
+		get: func(u models.Userable) (string, bool) {
+			if u.GetAccountEnabled() == nil {
+				return "", false
+			}
+			return strconv.FormatBool(*u.GetAccountEnabled()), true
+		},
+		set: func(u models.Userable, value string) {
+			enabled, err := strconv.ParseBool(value)
+			if err == nil {
+				u.SetAccountEnabled(&enabled)
+			}
+		},
+		clear: func(u models.Userable) {},
+	}
+}
+
+// userField reads a string or bool field of the cloudy User as a string
+func userField(user *cloudymodels.User, field string) string {
+	f := reflect.Indirect(reflect.ValueOf(user)).FieldByName(field)
+	switch f.Kind() {
+	case reflect.String:
+		return f.String()
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool())
+	}
+	return ""
+}
+
+func setUserField(user *cloudymodels.User, field string, value string) {
+	f := reflect.Indirect(reflect.ValueOf(user)).FieldByName(field)
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			f.SetBool(b)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFieldUser() (*cloudymodels.User, map[string]interface{}) {
+	user := &cloudymodels.User{
+		ID:             "i",
+		UPN:            "john.doe@example.com",
+		DisplayName:    "John Doe",
+		FirstName:      "John",
+		LastName:       "Doe",
+		Email:          "john.doe@example.com",
+		Company:        "Example",
+		JobTitle:       "Engineer",
+		Department:     "Engineering",
+		MobilePhone:    "555-0100",
+		OfficePhone:    "555-0101",
+		Enabled:        true,
+		AccountType:    "Contractor",
+		Citizenship:    "US",
+		ContractDate:   "2030-01-01",
+		ContractNumber: "FA8750-20-C-0001",
+		Organization:   "Org",
+		Project:        "Baker",
+		ProgramRole:    "Lead",
+	}
+
+	attributes := map[string]interface{}{
+		"officeLocation":        "Building 1",
+		"employeeId":            "E100",
+		"employeeType":          "Contractor",
+		"employeeHireDate":      "2024-01-15T00:00:00Z",
+		"employeeLeaveDateTime": "2030-01-01T17:00:00Z",
+		"usageLocation":         "US",
+		"city":                  "Rome",
+		"state":                 "NY",
+		"country":               "United States",
+		"postalCode":            "13440",
+		"preferredLanguage":     "en-US",
+		"Justification":         "Program access",
+		"Sponsor":               "jane.doe",
+		"StatusReason":          "Active",
+	}
+
+	return user, attributes
+}
+
+func TestUserFieldMappingRoundTrip(t *testing.T) {
+	user, attributes := testFieldUser()
+
+	azUser := UserToAzureWithAttributes(user, attributes, DefaultCustomSecurityAttributes)
+	back, backAttributes := UserToCloudyWithAttributes(azUser, DefaultCustomSecurityAttributes)
+
+	assert.Equal(t, user, back)
+	assert.Equal(t, attributes, backAttributes)
+
+	// Every mapped property is written and selected
+	for _, m := range DefaultUserFieldMappings {
+		_, ok := m.get(azUser)
+		assert.True(t, ok, m.Property)
+		if m.OptIn {
+			assert.NotContains(t, DefaultUserSelectFields, m.Property)
+			assert.Contains(t, UserLifecycleSelectFields, m.Property)
+		} else {
+			assert.Contains(t, DefaultUserSelectFields, m.Property)
+		}
+	}
+}
+
+func TestUserFieldMappingPatch(t *testing.T) {
+	user, attributes := testFieldUser()
+
+	// Every field changes from empty
+	patch, changes := UserToPatchWithAttributes(user, &cloudymodels.User{}, attributes, nil, DefaultCustomSecurityAttributes)
+	properties := []string{}
+	for _, c := range changes {
+		properties = append(properties, c.Property)
+	}
+	for _, m := range DefaultUserFieldMappings {
+		if m.ReadOnly {
+			continue
+		}
+		assert.Contains(t, properties, m.Property)
+		_, ok := m.get(patch)
+		assert.True(t, ok, m.Property)
+	}
+	for _, m := range DefaultCustomSecurityAttributes {
+		assert.Contains(t, properties, m.AttributeSet+"."+m.Attribute)
+	}
+
+	// Every field is cleared, except the required ones
+	cleared := map[string]interface{}{}
+	for key := range attributes {
+		cleared[key] = nil
+	}
+	patch, changes = UserToPatchWithAttributes(&cloudymodels.User{ID: "i"}, user, cleared, attributes, DefaultCustomSecurityAttributes)
+	for _, c := range changes {
+		if c.Property != "accountEnabled" {
+			assert.Equal(t, "", c.New, c.Property)
+		}
+	}
+	assert.Nil(t, patch.GetUserPrincipalName())
+	assert.Nil(t, patch.GetDisplayName())
+	assert.Equal(t, []string{}, patch.GetBusinessPhones())
+	assert.False(t, *patch.GetAccountEnabled())
+	for _, property := range []string{"givenName", "surname", "mail", "employeeHireDate", "postalCode"} {
+		value, exists := patch.GetAdditionalData()[property]
+		assert.True(t, exists, property)
+		assert.Nil(t, value, property)
+	}
+}
+
+func TestUserFieldMappingDates(t *testing.T) {
+	current := &cloudymodels.User{ID: "i"}
+	currentAttributes := map[string]interface{}{"employeeHireDate": "2024-01-15T00:00:00Z"}
+
+	// a yyyy-mm-dd date is compared by day
+	_, changes := UserToPatchWithAttributes(current, current, map[string]interface{}{"employeeHireDate": "2024-01-15"}, currentAttributes, nil)
+	assert.Empty(t, changes)
+
+	patch, changes := UserToPatchWithAttributes(current, current, map[string]interface{}{"employeeHireDate": "2024-01-16"}, currentAttributes, nil)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "2024-01-16T00:00:00Z", patch.GetEmployeeHireDate().Format(time.RFC3339))
+
+	// other offsets of the same instant are the same date
+	_, changes = UserToPatchWithAttributes(current, current, map[string]interface{}{"employeeHireDate": "2024-01-14T19:00:00-05:00"}, currentAttributes, nil)
+	assert.Empty(t, changes)
+}
+
+func TestUpdateAzUser(t *testing.T) {
+	azUser := models.NewUser()
+	UpdateAzUser(context.Background(), azUser, &cloudymodels.User{ID: "i", FirstName: "John", LastName: "Doe"})
+
+	assert.Equal(t, "i", *azUser.GetId())
+	assert.Equal(t, "John", *azUser.GetGivenName())
+	assert.Equal(t, "Doe", *azUser.GetSurname())
+}
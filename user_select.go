@@ -0,0 +1,130 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+type userSelectFieldsKey struct{}
+
+// Selected properties that are read into the user by other means and are not
+// returned in the attributes map
+var handledUserSelectFields = []string{
+	"customSecurityAttributes",
+	"assignedLicenses",
+	"authorizationInfo",
+	"streetAddress",
+	SigninActivityField,
+}
+
+// WithUserSelectFields adds the properties to $select for the user reads made with
+// the context. Properties that are not mapped to the user are returned in the user
+// attributes map, e.g. by GetUserWithAttributes.
+func WithUserSelectFields(ctx context.Context, fields ...string) context.Context {
+	existing := UserSelectFieldsFromContext(ctx)
+	return context.WithValue(ctx, userSelectFieldsKey{}, append(append([]string{}, existing...), fields...))
+}
+
+// UserSelectFieldsFromContext returns the properties added with WithUserSelectFields
+func UserSelectFieldsFromContext(ctx context.Context) []string {
+	fields, _ := ctx.Value(userSelectFieldsKey{}).([]string)
+	return fields
+}
+
+// userSelectFields returns a new slice with the configured select fields, the fields
+// added to the context, the sign in activity when requested and the extra fields
+func (graph *MsGraph) userSelectFields(ctx context.Context, opts *cloudy.UserOptions, extra ...string) []string {
+	fields := DefaultUserSelectFields
+	if graph.Cfg != nil && len(graph.Cfg.SelectFields) > 0 {
+		fields = graph.Cfg.SelectFields
+	}
+
+	rtn := []string{}
+	add := func(values ...string) {
+		for _, v := range values {
+			if !containsFold(rtn, v) {
+				rtn = append(rtn, v)
+			}
+		}
+	}
+
+	add(fields...)
+	if graph.Cfg != nil {
+		add(graph.extensionNames(graph.Cfg.ExtensionProperties)...)
+	}
+	add(UserSelectFieldsFromContext(ctx)...)
+	if opts != nil && opts.IncludeLastSignIn != nil && *opts.IncludeLastSignIn {
+		add(SigninActivityField)
+	}
+	add(extra...)
+
+	return rtn
+}
+
+// userToCloudyWithSelect converts the user and adds the selected properties that are not
+// mapped to the attributes map, keyed by property name
+func (graph *MsGraph) userToCloudyWithSelect(ctx context.Context, user models.Userable, fields []string) (*cloudymodels.User, map[string]interface{}) {
+	u, attributes := graph.userToCloudyWithAttributes(user)
+
+	unmapped := []string{}
+	properties := DefaultUserFieldMappings.Properties()
+	for _, field := range fields {
+		if !containsFold(properties, field) && !containsFold(handledUserSelectFields, field) {
+			unmapped = append(unmapped, field)
+		}
+	}
+	if len(unmapped) == 0 {
+		return u, attributes
+	}
+
+	values, err := userProperties(user)
+	if err != nil {
+		cloudy.Warn(ctx, "[%s] unable to read the selected properties %v: %v", u.ID, unmapped, err)
+		return u, attributes
+	}
+
+	for _, field := range unmapped {
+		for property, value := range values {
+			if strings.EqualFold(property, field) && value != nil {
+				attributes[property] = value
+			}
+		}
+	}
+
+	return u, attributes
+}
+
+// userProperties serializes the user to read the properties by name
+func userProperties(user models.Userable) (map[string]interface{}, error) {
+	writer := jsonserialization.NewJsonSerializationWriter()
+	defer writer.Close()
+
+	err := writer.WriteObjectValue("", user)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := writer.GetSerializedContent()
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := make(map[string]interface{})
+	err = json.Unmarshal(content, &rtn)
+	return rtn, err
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
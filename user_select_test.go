@@ -0,0 +1,44 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserSelectFields(t *testing.T) {
+	defaults := append([]string{}, DefaultUserSelectFields...)
+
+	graph := &MsGraph{Cfg: &MsGraphConfig{}}
+	assert.Equal(t, DefaultUserSelectFields, graph.userSelectFields(context.Background(), nil))
+
+	graph.Cfg.SelectFields = []string{"id", "displayName"}
+	ctx := WithUserSelectFields(context.Background(), "faxNumber", "ID")
+	ctx = WithUserSelectFields(ctx, "onPremisesSamAccountName")
+
+	fields := graph.userSelectFields(ctx, &cloudy.UserOptions{IncludeLastSignIn: cloudy.BoolP(true)}, "customSecurityAttributes")
+	assert.Equal(t, []string{"id", "displayName", "faxNumber", "onPremisesSamAccountName", SigninActivityField, "customSecurityAttributes"}, fields)
+
+	fields = graph.userSelectFields(ctx, nil, "city")
+	assert.Equal(t, []string{"id", "displayName", "faxNumber", "onPremisesSamAccountName", "city"}, fields)
+	assert.Equal(t, []string{"id", "displayName"}, graph.Cfg.SelectFields)
+	assert.Equal(t, defaults, DefaultUserSelectFields)
+}
+
+func TestUserToCloudyWithSelect(t *testing.T) {
+	azUser := models.NewUser()
+	azUser.SetId(cloudy.StringP("i"))
+	azUser.SetDisplayName(cloudy.StringP("John Doe"))
+	azUser.SetFaxNumber(cloudy.StringP("555-0102"))
+	azUser.SetStreetAddress(cloudy.StringP("123 Main Street"))
+
+	graph := &MsGraph{Cfg: &MsGraphConfig{}}
+	u, attributes := graph.userToCloudyWithSelect(context.Background(), azUser,
+		[]string{"id", "displayName", "faxNumber", "streetAddress", "onPremisesSamAccountName"})
+
+	assert.Equal(t, "John Doe", u.DisplayName)
+	assert.Equal(t, map[string]interface{}{"faxNumber": "555-0102"}, attributes)
+}
@@ -229,3 +229,75 @@ func TestUserModel(t *testing.T) {
 	assert.Equal(t, cloudyU1, cloudyU2)
 
 }
+
+func TestUserToPatch(t *testing.T) {
+	current := &cloudymodels.User{
+		ID:             "i",
+		UPN:            "a",
+		DisplayName:    "b",
+		FirstName:      "d",
+		LastName:       "e",
+		Company:        "f",
+		Email:          "h",
+		OfficePhone:    "l",
+		Enabled:        true,
+		ContractNumber: "FA8750-20-C-0001",
+		Citizenship:    "US",
+	}
+
+	updated := *current
+	patch, changes := UserToPatchWithAttributes(&updated, current, nil, nil, DefaultCustomSecurityAttributes)
+	assert.Empty(t, changes)
+	assert.Nil(t, patch.GetCustomSecurityAttributes())
+
+	updated.DisplayName = "bb"
+	updated.Company = ""
+	updated.OfficePhone = ""
+	updated.Enabled = false
+	updated.Citizenship = ""
+	updated.ContractNumber = "FA8750-21-C-0002"
+	updated.UPN = ""
+
+	patch, changes = UserToPatchWithAttributes(&updated, current,
+		map[string]interface{}{OfficeLocationAttribute: "Building 1", "Sponsor": "jane.doe"},
+		map[string]interface{}{"Sponsor": "jane.doe"},
+		DefaultCustomSecurityAttributes)
+
+	assert.Equal(t, []*UserChange{
+		{Property: "displayName", Old: "b", New: "bb"},
+		{Property: "companyName", Old: "f", New: ""},
+		{Property: "businessPhones", Old: "l", New: ""},
+		{Property: "accountEnabled", Old: "true", New: "false"},
+		{Property: OfficeLocationAttribute, Old: "", New: "Building 1"},
+		{Property: "cloudy.Citizenship", Old: "US", New: ""},
+		{Property: "cloudy.ContractNumber", Old: "FA8750-20-C-0001", New: "FA8750-21-C-0002"},
+	}, changes)
+
+	assert.Nil(t, patch.GetUserPrincipalName())
+	assert.Equal(t, "bb", *patch.GetDisplayName())
+	assert.Nil(t, patch.GetGivenName())
+	assert.Equal(t, []string{}, patch.GetBusinessPhones())
+	assert.False(t, *patch.GetAccountEnabled())
+	assert.Equal(t, "Building 1", *patch.GetOfficeLocation())
+
+	company, exists := patch.GetAdditionalData()["companyName"]
+	assert.True(t, exists)
+	assert.Nil(t, company)
+
+	set := patch.GetCustomSecurityAttributes().GetAdditionalData()["cloudy"].(map[string]interface{})
+	assert.Equal(t, "FA8750-21-C-0002", *set["ContractNumber"].(*string))
+	citizenship, exists := set["Citizenship"]
+	assert.True(t, exists)
+	assert.Nil(t, citizenship)
+	_, exists = set["Sponsor"]
+	assert.False(t, exists)
+	_, exists = set["AccountType"]
+	assert.False(t, exists)
+}
+
+func TestRevokeSignInSessions(t *testing.T) {
+	ctx, um := testUM()
+
+	err := um.RevokeSignInSessions(ctx, "unittest@collider.onmicrosoft.us")
+	assert.Nil(t, err)
+}
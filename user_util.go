@@ -2,35 +2,23 @@ package cloudymsgraph
 
 import (
 	"context"
-	b64 "encoding/base64"
-	"encoding/json"
 	"strings"
 
 	"github.com/appliedres/cloudy"
 	cloudymodels "github.com/appliedres/cloudy/models"
 	"github.com/go-openapi/strfmt"
+	absser "github.com/microsoft/kiota-abstractions-go/serialization"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 )
 
-var DefaultUserSelectFields = []string{
-	"accountEnabled",
+// DefaultUserSelectFields are the mapped user properties, except the opt in ones, along with
+// the properties used by the custom security attributes, licenses and certificate bindings
+var DefaultUserSelectFields = append(DefaultUserFieldMappings.defaultProperties(),
 	"customSecurityAttributes",
-	// "signInActivity",
-	"businessPhones",
-	"displayName",
-	"givenName",
-	"id",
-	"jobTitle",
-	"mail",
-	"mobilePhone",
-	"officeLocation",
-	"surname",
-	"userPrincipalName",
 	"assignedLicenses",
-	"companyName",
 	"authorizationInfo",
 	"streetAddress",
-}
+)
 
 var SigninActivityField = "signInActivity"
 
@@ -45,46 +33,25 @@ type UserCustomSecurityAttributes struct {
 	StatusReason           string `json:"StatusReason,omitempty"`
 }
 
+// UserToAzure converts the user using the DefaultCustomSecurityAttributes
 func UserToAzure(user *cloudymodels.User) *models.User {
+	return UserToAzureWithAttributes(user, nil, DefaultCustomSecurityAttributes)
+}
+
+// UserToAzureWithAttributes converts the user, writing the custom security attributes
+// with the given mappings. The attributes are the values of the unbound mappings.
+func UserToAzureWithAttributes(user *cloudymodels.User, attributes map[string]interface{}, mappings CustomSecurityAttributeMappings) *models.User {
 	u := models.NewUser()
 
 	if !strings.EqualFold(user.ID, "") {
 		u.SetId(&user.ID)
 	}
 
-	u.SetUserPrincipalName(&user.UPN)
-	u.SetDisplayName(&user.DisplayName)
+	DefaultUserFieldMappings.toAzure(user, attributes, u)
 
 	emailNickname := cloudy.TrimDomain(user.UPN)
 	u.SetMailNickname(&emailNickname)
 
-	if user.Email != "" {
-		u.SetMail(&user.Email)
-	}
-
-	u.SetGivenName(&user.FirstName)
-	u.SetSurname(&user.LastName)
-
-	if user.Company != "" {
-		u.SetCompanyName(&user.Company)
-	}
-
-	if user.JobTitle != "" {
-		u.SetJobTitle(&user.JobTitle)
-	}
-
-	if user.OfficePhone != "" {
-		u.SetBusinessPhones([]string{user.OfficePhone})
-	}
-
-	if user.MobilePhone != "" {
-		u.SetMobilePhone(&user.MobilePhone)
-	}
-
-	if user.Department != "" {
-		u.SetDepartment(&user.Department)
-	}
-
 	if user.MustChangePassword || user.Password != "" {
 		profile := models.NewPasswordProfile()
 		profile.SetForceChangePasswordNextSignIn(cloudy.BoolP(user.MustChangePassword))
@@ -92,7 +59,7 @@ func UserToAzure(user *cloudymodels.User) *models.User {
 		u.SetPasswordProfile(profile)
 	}
 
-	customSecurityAttributes := ParseUserCustomSecurityAttributes(user)
+	customSecurityAttributes := mappings.ToAzure(user, attributes)
 
 	if customSecurityAttributes != nil {
 		u.SetCustomSecurityAttributes(customSecurityAttributes)
@@ -101,151 +68,64 @@ func UserToAzure(user *cloudymodels.User) *models.User {
 	return u
 }
 
+// ParseUserCustomSecurityAttributes builds the custom security attributes of the user
+// using the DefaultCustomSecurityAttributes
 func ParseUserCustomSecurityAttributes(user *cloudymodels.User) *models.CustomSecurityAttributeValue {
-	hasCustomSecurityAttributes := false
-
-	// TODO: When Microsoft fixes the bug with Custom Security Attributes this will need to be changed
-	cloudyattr := make(map[string]interface{})
-
-	if user.AccountType != "" {
-		cloudyattr["AccountType"] = &user.AccountType
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.Citizenship != "" {
-		cloudyattr["Citizenship"] = &user.Citizenship
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.ContractDate != "" {
-		cloudyattr["ContractExpirationDate"] = &user.ContractDate
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.ContractNumber != "" {
-		cloudyattr["ContractNumber"] = &user.ContractNumber
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.Organization != "" {
-		cloudyattr["Organization"] = &user.Organization
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.Project != "" {
-		cloudyattr["Project"] = &user.Project
-		hasCustomSecurityAttributes = true
-	}
-
-	if user.ProgramRole != "" {
-		cloudyattr["ProgramRole"] = &user.ProgramRole
-		hasCustomSecurityAttributes = true
-	}
-
-	if hasCustomSecurityAttributes {
-
-		customSecurityAttributes := models.NewCustomSecurityAttributeValue()
+	return DefaultCustomSecurityAttributes.ToAzure(user, nil)
+}
 
-		odata := "#microsoft.graph.customSecurityAttributeValue"
-		// odata := "#Microsoft.DirectoryServices.CustomSecurityAttributeValue"
-		cloudyattr["@odata.type"] = &odata
+// UserChange is a property changed by a patch. Custom security attributes are named
+// {attributeSet}.{attribute}
+type UserChange struct {
+	Property string
+	Old      string
+	New      string
+}
 
-		customSecurityAttributes.GetAdditionalData()["cloudy"] = cloudyattr
+// OfficeLocationAttribute is the key of the office location in the user attributes map
+const OfficeLocationAttribute = "officeLocation"
 
-		return customSecurityAttributes
-	}
-
-	return nil
+// UserToPatch builds the patch for the user using the DefaultCustomSecurityAttributes
+func UserToPatch(user *cloudymodels.User, currentUser *cloudymodels.User) *models.User {
+	u, _ := UserToPatchWithAttributes(user, currentUser, nil, nil, DefaultCustomSecurityAttributes)
+	return u
 }
 
-func UserToPatch(user *cloudymodels.User, currentUser *cloudymodels.User) *models.User {
+// UserToPatchWithAttributes builds a patch holding only the properties that differ from
+// the current user. Cleared values are sent as explicit nulls. The user properties and
+// custom security attributes kept in the attributes map are only compared when present
+// in the map, a nil or empty value clears them. Returns the patch and the changes it makes.
+func UserToPatchWithAttributes(user *cloudymodels.User, currentUser *cloudymodels.User, attributes map[string]interface{},
+	currentAttributes map[string]interface{}, mappings CustomSecurityAttributeMappings) (*models.User, []*UserChange) {
 
 	u := models.NewUser()
 	u.SetId(&user.ID)
 
-	if user.FirstName != currentUser.FirstName {
-		u.SetGivenName(&user.FirstName)
-	}
-
-	if user.LastName != currentUser.LastName {
-		u.SetSurname(&user.LastName)
-	}
-
-	if user.JobTitle != currentUser.JobTitle {
-		u.SetJobTitle(&user.JobTitle)
-	}
-
-	if user.MobilePhone != currentUser.MobilePhone {
-		u.SetMobilePhone(&user.MobilePhone)
-	}
-
-	if user.Department != currentUser.Department {
-		u.SetDepartment(&user.Department)
-	}
-
-	customSecurityAttributes := ParseUserCustomSecurityAttributes(user)
+	changes := DefaultUserFieldMappings.diff(user, attributes, currentUser, currentAttributes, u)
 
+	customSecurityAttributes, csaChanges := mappings.Diff(user, attributes, currentUser, currentAttributes)
 	if customSecurityAttributes != nil {
 		u.SetCustomSecurityAttributes(customSecurityAttributes)
 	}
+	changes = append(changes, csaChanges...)
 
-	return u
+	return u, changes
 }
 
+// UserToCloudy converts the user using the DefaultCustomSecurityAttributes
 func UserToCloudy(user models.Userable) *cloudymodels.User {
-	u := &cloudymodels.User{}
-
-	if user.GetId() != nil {
-		u.ID = *user.GetId()
-	}
-
-	if user.GetUserPrincipalName() != nil {
-		u.UPN = *user.GetUserPrincipalName()
-	}
-
-	if user.GetGivenName() != nil {
-		u.FirstName = *user.GetGivenName()
-	}
-
-	if user.GetSurname() != nil {
-		u.LastName = *user.GetSurname()
-	}
-
-	if user.GetMail() != nil {
-		u.Email = *user.GetMail()
-	}
-
-	if user.GetCompanyName() != nil {
-		u.Company = *user.GetCompanyName()
-	}
-
-	if user.GetJobTitle() != nil {
-		u.JobTitle = *user.GetJobTitle()
-	}
-
-	if user.GetDisplayName() != nil {
-		u.DisplayName = *user.GetDisplayName()
-	}
-
-	if user.GetDepartment() != nil {
-		u.Department = *user.GetDepartment()
-	}
-
-	if user.GetDepartment() != nil {
-		u.Department = *user.GetDepartment()
-	}
-
-	if user.GetMobilePhone() != nil {
-		u.MobilePhone = *user.GetMobilePhone()
-	}
+	u, _ := UserToCloudyWithAttributes(user, DefaultCustomSecurityAttributes)
+	return u
+}
 
-	if len(user.GetBusinessPhones()) >= 1 {
-		u.OfficePhone = user.GetBusinessPhones()[0]
-	}
+// UserToCloudyWithAttributes converts the user, reading the custom security attributes
+// with the given mappings. Returns the values of the unbound mappings.
+func UserToCloudyWithAttributes(user models.Userable, mappings CustomSecurityAttributeMappings) (*cloudymodels.User, map[string]interface{}) {
+	return userToCloudy(user, mappings, true)
+}
 
-	if user.GetAccountEnabled() != nil {
-		u.Enabled = *user.GetAccountEnabled()
-	}
+func userToCloudy(user models.Userable, mappings CustomSecurityAttributeMappings, legacyFallback bool) (*cloudymodels.User, map[string]interface{}) {
+	u := &cloudymodels.User{}
 
 	if user.GetSignInActivity() != nil && user.GetSignInActivity().GetLastSignInDateTime() != nil {
 		lastSignIn := *user.GetSignInActivity().GetLastSignInDateTime()
@@ -262,100 +142,21 @@ func UserToCloudy(user models.Userable) *cloudymodels.User {
 		}
 	}
 
-	allAttributes := readAllCustomSecurityAttributes(user, "cloudy")
-	if allAttributes != nil {
-
-		// Read the Contract Number
-		contractNumber, exists := allAttributes["ContractNumber"]
-		if exists && contractNumber != nil {
-			u.ContractNumber = *contractNumber
-		}
-
-		// Read the Contract Date
-		contractDate, exists := allAttributes["ContractExpirationDate"]
-		if exists && contractDate != nil {
-			u.ContractDate = *contractDate
-		}
-
-		// Read the Account Type
-		accountType, exists := allAttributes["AccountType"]
-		if exists && accountType != nil {
-			u.AccountType = *accountType
-		}
-
-		// Read the Citizenship
-		citizenship, exists := allAttributes["Citizenship"]
-		if exists && citizenship != nil {
-			u.Citizenship = *citizenship
-		}
-
-		// Read the Citizenship
-		organization, exists := allAttributes["Organization"]
-		if exists && organization != nil {
-			u.Organization = *organization
-		}
-
-		// Read the Citizenship
-		programRole, exists := allAttributes["ProgramRole"]
-		if exists && programRole != nil {
-			u.ProgramRole = *programRole
-		}
-
-	} else if user.GetStreetAddress() != nil {
-		// TODO: When Microsoft fixes the bug with Custom Security Attributes this will need to be changed to user.GetCustomSecurityAttributes and tested
-		// also change cloudy user model CustomSecurityAttributes from string to object and implement interface
-
-		sDec, _ := b64.StdEncoding.DecodeString(*user.GetStreetAddress())
-		csa := UserCustomSecurityAttributes{}
-		json.Unmarshal(sDec, &csa)
-
-		u.AccountType = csa.AccountType
-		u.Citizenship = csa.Citizenship
-		u.ContractNumber = csa.ContractNumber
-		u.ContractDate = csa.ContractExpirationDate
-	}
-
-	return u
-}
-
-func readAllCustomSecurityAttributes(user models.Userable, attributeSet string) map[string]*string {
-	allAttributes := make(map[string]*string)
-
-	if user.GetCustomSecurityAttributes() != nil && user.GetCustomSecurityAttributes().GetAdditionalData() != nil {
-		attrs := user.GetCustomSecurityAttributes().GetAdditionalData()
-
-		attributeSetMap := attrs[attributeSet]
-		if attributeSetMap != nil && attributeSetMap.(map[string]interface{}) != nil {
-			attributeSetMap := attributeSetMap.(map[string]interface{})
-			for attributeName := range attributeSetMap {
-
-				attributeValue := attributeSetMap[attributeName]
-				if attributeValue != nil && attributeValue.(*string) != nil {
-					allAttributes[attributeName] = attributeValue.(*string)
-				} else {
-					allAttributes[attributeName] = nil
-				}
-			}
-
-			return allAttributes
-		}
-	}
-
-	return nil
-}
-
-func readCustomAttributeStr(user models.Userable, attributeSet string, attributeName string) *string {
-
-	allAttributes := readAllCustomSecurityAttributes(user, attributeSet)
-	if allAttributes != nil {
-
-		attributeValue, exists := allAttributes[attributeName]
-		if exists && attributeValue != nil {
-			return attributeValue
+	attributes, hasAttributes := mappings.ToCloudy(user, u)
+	DefaultUserFieldMappings.toCloudy(user, u, attributes)
+	if !hasAttributes && legacyFallback && user.GetStreetAddress() != nil {
+		// Workaround for the old Microsoft bug with Custom Security Attributes, the attributes
+		// were stored base64 encoded in streetAddress. SEE: MigrateStreetAddressAttributes
+		csa, err := DecodeLegacyStreetAddress(*user.GetStreetAddress())
+		if err == nil {
+			u.AccountType = csa.AccountType
+			u.Citizenship = csa.Citizenship
+			u.ContractNumber = csa.ContractNumber
+			u.ContractDate = csa.ContractExpirationDate
 		}
 	}
 
-	return nil
+	return u, attributes
 }
 
 func UpdateAzUser(ctx context.Context, azUser models.Userable, cUser *cloudymodels.User) {
@@ -364,16 +165,22 @@ func UpdateAzUser(ctx context.Context, azUser models.Userable, cUser *cloudymode
 		azUser.SetId(&cUser.ID)
 	}
 
-	if azUser.GetSurname() == nil || !strings.EqualFold(*azUser.GetSurname(), cUser.FirstName) {
-		if azUser.GetSurname() == nil {
-			silly := ""
-			azUser.SetSurname(&silly)
-		}
-		azUser.SetSurname(&cUser.FirstName)
+	if azUser.GetGivenName() == nil || !strings.EqualFold(*azUser.GetGivenName(), cUser.FirstName) {
+		azUser.SetGivenName(&cUser.FirstName)
 	}
 
-	if azUser.GetGivenName() == nil || !strings.EqualFold(*azUser.GetGivenName(), cUser.LastName) {
-		azUser.SetGivenName(&cUser.LastName)
+	if azUser.GetSurname() == nil || !strings.EqualFold(*azUser.GetSurname(), cUser.LastName) {
+		azUser.SetSurname(&cUser.LastName)
 	}
 
 }
+
+// setNull sends an explicit null for the property, nil values are left out of the request
+func setNull(entity absser.AdditionalDataHolder, property string) {
+	data := entity.GetAdditionalData()
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data[property] = nil
+	entity.SetAdditionalData(data)
+}
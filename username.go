@@ -0,0 +1,280 @@
+package cloudymsgraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/directory"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultUsernamePattern builds user names as first.last. The pattern tokens are {first},
+// {last}, {f} and {l}, the first letter of the first and last name.
+const DefaultUsernamePattern = "{first}.{last}"
+
+// DefaultUsernameMaxAttempts is the number of numeric suffixes tried for a unique user name
+const DefaultUsernameMaxAttempts = 100
+
+// maxUsernameLength is the longest mailNickname, and user principal name prefix, Graph allows
+const maxUsernameLength = 64
+
+var ErrInvalidUsername = errors.New("invalid user name")
+var ErrNoUniqueUsername = errors.New("unable to find a unique user name")
+
+// UsernameOptions controls how a user name is generated
+type UsernameOptions struct {
+	// Pattern builds the user name from the user. Defaults to the configured
+	// UsernamePattern or DefaultUsernamePattern
+	Pattern string
+
+	// Domain of the user principal name. Defaults to the default domain of the tenant
+	Domain string
+
+	// MaxAttempts is the number of numeric suffixes tried. Defaults to DefaultUsernameMaxAttempts
+	MaxAttempts int
+}
+
+// usernameTransliterations are the letters that are not a base letter and a mark
+var usernameTransliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+// ForceUserName makes the proposed name a valid user principal name in a verified domain
+// of the tenant, the default domain when it has none, and appends a numeric suffix until
+// no user, deleted user or proxy address uses it. Returns the unique user name and true
+// when the proposed name was already taken.
+func (um *MsGraphUserManager) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	local, domain, _ := strings.Cut(name, "@")
+	return um.uniqueUserName(ctx, "ForceUserName", SanitizeUsername(local), domain, 0)
+}
+
+// GenerateUserName builds the user name of the user from the pattern and makes it unique
+// as ForceUserName does
+func (um *MsGraphUserManager) GenerateUserName(ctx context.Context, user *cloudymodels.User, opts *UsernameOptions) (string, error) {
+	if opts == nil {
+		opts = &UsernameOptions{}
+	}
+
+	pattern := opts.Pattern
+	if pattern == "" && um.Cfg != nil {
+		pattern = um.Cfg.UsernamePattern
+	}
+	if pattern == "" {
+		pattern = DefaultUsernamePattern
+	}
+
+	name, _, err := um.uniqueUserName(ctx, "GenerateUserName", UsernameFromPattern(pattern, user), opts.Domain, opts.MaxAttempts)
+	return name, err
+}
+
+// uniqueUserName makes the name unique, method is the name of the calling method in the logs
+func (um *MsGraphUserManager) uniqueUserName(ctx context.Context, method string, local string, domain string, maxAttempts int) (string, bool, error) {
+	if local == "" {
+		return "", false, cloudy.Error(ctx, "%s Error: %v", method, ErrInvalidUsername)
+	}
+
+	domain, err := um.userNameDomain(ctx, method, domain)
+	if err != nil {
+		return "", false, err
+	}
+
+	taken := func(upn string, nickname string) (bool, error) {
+		deleted, err := um.deletedUserNameTaken(ctx, method, upn, nickname)
+		if err != nil || deleted {
+			return deleted, err
+		}
+		return um.userNameTaken(ctx, method, upn, nickname)
+	}
+
+	name, attempt, err := uniqueUsername(local, domain, maxAttempts, taken)
+	if err != nil {
+		return "", false, cloudy.Error(ctx, "[%s@%s] %s Error: %v", local, domain, method, err)
+	}
+
+	cloudy.Info(ctx, "[%s@%s] %s %s", local, domain, method, name)
+	return name, attempt > 0, nil
+}
+
+// userNameDomain returns the domain when it is a verified domain of the tenant, or the
+// default domain of the tenant when empty
+func (um *MsGraphUserManager) userNameDomain(ctx context.Context, method string, domain string) (string, error) {
+	dm := um.domainManager()
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	if domain == "" {
+		d, err := dm.DefaultDomain(ctx)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToLower(d.Name), nil
+	}
+
+	d, err := dm.GetDomain(ctx, domain)
+	if err != nil {
+		return "", err
+	}
+	if d == nil {
+		return "", cloudy.Error(ctx, "[%s] %s Error: %v", domain, method, ErrUnknownDomain)
+	}
+	if !d.IsVerified {
+		return "", cloudy.Error(ctx, "[%s] %s Error: %v", domain, method, ErrUnverifiedDomain)
+	}
+
+	return domain, nil
+}
+
+// userNameTaken returns true when a user has the user principal name, the mail, the mail
+// nickname or the proxy address
+func (um *MsGraphUserManager) userNameTaken(ctx context.Context, method string, upn string, nickname string) (bool, error) {
+	filter := fmt.Sprintf("userPrincipalName eq '%[1]s' or mail eq '%[1]s' or mailNickname eq '%[2]s' or proxyAddresses/any(p:p eq 'smtp:%[1]s')",
+		odataString(upn), odataString(nickname))
+	count := true
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	result, err := um.Client.Users().Get(ctx,
+		&users.UsersRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.UsersRequestBuilderGetQueryParameters{
+				Filter: &filter,
+				Select: []string{"id"},
+				Count:  &count,
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return false, cloudy.Error(ctx, "[%s] %s - unable to search the users: %s", upn, method, message)
+	}
+
+	return len(result.GetValue()) > 0, nil
+}
+
+// deletedUserNameTaken returns true when a deleted user had the user principal name, the
+// mail or the mail nickname. Deleted users keep their user principal name prefixed with
+// their id, so it is searched with endswith and compared once the prefix is removed.
+func (um *MsGraphUserManager) deletedUserNameTaken(ctx context.Context, method string, upn string, nickname string) (bool, error) {
+	filter := fmt.Sprintf("endswith(userPrincipalName,'%[1]s') or mail eq '%[1]s' or mailNickname eq '%[2]s'",
+		odataString(upn), odataString(nickname))
+	count := true
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	result, err := um.Client.Directory().DeletedItems().GraphUser().Get(ctx,
+		&directory.DeletedItemsGraphUserRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &directory.DeletedItemsGraphUserRequestBuilderGetQueryParameters{
+				Filter: &filter,
+				Select: []string{"id", "userPrincipalName", "mail", "mailNickname"},
+				Count:  &count,
+			},
+		})
+	if err != nil {
+		_, message := GetErrorCodeAndMessage(ctx, err)
+		return false, cloudy.Error(ctx, "[%s] %s - unable to search the deleted users: %s", upn, method, message)
+	}
+
+	for _, d := range result.GetValue() {
+		if deletedUserNameMatches(d, upn, nickname) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deletedUserNameMatches returns true when the deleted user had the user principal name,
+// the mail or the mail nickname
+func deletedUserNameMatches(d models.Userable, upn string, nickname string) bool {
+	deletedUPN := deletedUserPrincipalName(cloudy.StringFromP(d.GetId()), cloudy.StringFromP(d.GetUserPrincipalName()))
+	return strings.EqualFold(deletedUPN, upn) ||
+		strings.EqualFold(cloudy.StringFromP(d.GetMail()), upn) ||
+		strings.EqualFold(cloudy.StringFromP(d.GetMailNickname()), nickname)
+}
+
+// uniqueUsername returns the first name that is not taken, trying local, local.2, local.3
+// and so on, and the number of names that were taken before it
+func uniqueUsername(local string, domain string, maxAttempts int, taken func(upn string, nickname string) (bool, error)) (string, int, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultUsernameMaxAttempts
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		nickname := local
+		if attempt > 0 {
+			suffix := fmt.Sprintf(".%d", attempt+1)
+			nickname = truncateUsername(local, maxUsernameLength-len(suffix)) + suffix
+		}
+		upn := nickname + "@" + domain
+
+		exists, err := taken(upn, nickname)
+		if err != nil {
+			return "", attempt, err
+		}
+		if !exists {
+			return upn, attempt, nil
+		}
+	}
+
+	return "", maxAttempts, ErrNoUniqueUsername
+}
+
+// UsernameFromPattern replaces the {first}, {last}, {f} and {l} tokens of the pattern with
+// the names of the user and sanitizes the result
+func UsernameFromPattern(pattern string, user *cloudymodels.User) string {
+	first := SanitizeUsername(user.FirstName)
+	last := SanitizeUsername(user.LastName)
+
+	replacer := strings.NewReplacer(
+		"{first}", first,
+		"{last}", last,
+		"{f}", firstLetter(first),
+		"{l}", firstLetter(last),
+	)
+	return SanitizeUsername(replacer.Replace(pattern))
+}
+
+// SanitizeUsername transliterates accented letters to ASCII, lower cases the name and
+// removes the characters that are not valid in a user principal name or mail nickname.
+// Spaces and repeated or leading and trailing dots are removed.
+func SanitizeUsername(name string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFD.String(strings.ToLower(strings.TrimSpace(name))) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		case r == '.':
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), ".") {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteString(usernameTransliterations[r])
+		}
+	}
+
+	return truncateUsername(strings.TrimRight(b.String(), "."), maxUsernameLength)
+}
+
+func truncateUsername(name string, max int) string {
+	if len(name) > max {
+		name = strings.TrimRight(name[:max], ".")
+	}
+	return name
+}
+
+func firstLetter(name string) string {
+	if name == "" {
+		return ""
+	}
+	return name[:1]
+}
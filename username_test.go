@@ -0,0 +1,76 @@
+package cloudymsgraph
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	cloudymodels "github.com/appliedres/cloudy/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeUsername(t *testing.T) {
+	assert.Equal(t, "jose.garcia", SanitizeUsername(" José.García "))
+	assert.Equal(t, "strasse", SanitizeUsername("Straße"))
+	assert.Equal(t, "soren.lokke", SanitizeUsername("Søren.Løkke"))
+	assert.Equal(t, "oconnor-smith", SanitizeUsername("O'Connor-Smith"))
+	assert.Equal(t, "mary.ann", SanitizeUsername("..Mary..Ann.."))
+	assert.Equal(t, "vanderberg", SanitizeUsername("van der Berg"))
+	assert.Equal(t, "", SanitizeUsername("李"))
+	assert.Len(t, SanitizeUsername(strings.Repeat("a", 100)), 64)
+}
+
+func TestUsernameFromPattern(t *testing.T) {
+	user := &cloudymodels.User{FirstName: "Zoë", LastName: "D'Angelo"}
+	assert.Equal(t, "zoe.dangelo", UsernameFromPattern(DefaultUsernamePattern, user))
+	assert.Equal(t, "zdangelo", UsernameFromPattern("{f}{last}", user))
+	assert.Equal(t, "dangelo_z", UsernameFromPattern("{last}_{f}", user))
+	assert.Equal(t, "zoe", UsernameFromPattern(DefaultUsernamePattern, &cloudymodels.User{FirstName: "Zoë"}))
+}
+
+func TestUniqueUsername(t *testing.T) {
+	existing := map[string]bool{"john.doe@example.com": true, "john.doe.2": true}
+	taken := func(upn string, nickname string) (bool, error) {
+		return existing[upn] || existing[nickname], nil
+	}
+
+	name, attempt, err := uniqueUsername("john.doe", "example.com", 0, taken)
+	assert.NoError(t, err)
+	assert.Equal(t, "john.doe.3@example.com", name)
+	assert.Equal(t, 2, attempt)
+
+	name, attempt, err = uniqueUsername("jane.doe", "example.com", 0, taken)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", name)
+	assert.Equal(t, 0, attempt)
+
+	_, _, err = uniqueUsername("john.doe", "example.com", 2, taken)
+	assert.ErrorIs(t, err, ErrNoUniqueUsername)
+
+	// The suffix fits in the longest name
+	long := strings.Repeat("a", 64)
+	name, _, err = uniqueUsername(long, "example.com", 0, func(upn string, nickname string) (bool, error) {
+		return nickname == long, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 62)+".2@example.com", name)
+
+	failed := errors.New("failed")
+	_, _, err = uniqueUsername("john.doe", "example.com", 0, func(upn string, nickname string) (bool, error) {
+		return false, failed
+	})
+	assert.ErrorIs(t, err, failed)
+}
+
+func TestDeletedUserNameMatches(t *testing.T) {
+	d := models.NewUser()
+	d.SetId(cloudy.StringP("0f8b6a2e-1c3d-4e5f-9a8b-7c6d5e4f3a2b"))
+	d.SetUserPrincipalName(cloudy.StringP("0f8b6a2e1c3d4e5f9a8b7c6d5e4f3a2bjohn.doe@example.com"))
+	d.SetMailNickname(cloudy.StringP("jdoe"))
+
+	assert.True(t, deletedUserNameMatches(d, "John.Doe@example.com", "john.doe"))
+	assert.True(t, deletedUserNameMatches(d, "jdoe@example.com", "jdoe"))
+	assert.False(t, deletedUserNameMatches(d, "doe@example.com", "doe"))
+}